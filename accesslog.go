@@ -0,0 +1,267 @@
+package statiq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// accessLogFlushInterval is how often buffered access log lines are written out
+const accessLogFlushInterval = time.Second
+
+// clfTimeFormat is the timestamp layout of the Common Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// redactedHeaders are never written to the access log, even when listed
+var redactedHeaders = map[string]bool{"Authorization": true}
+
+// accessLogEntry is one JSON line of the access log
+type accessLogEntry struct {
+	Time       string            `json:"time"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	DurationMs float64           `json:"duration_ms"`
+	RemoteIP   string            `json:"remote_ip"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	TraceID    string            `json:"trace_id,omitempty"`
+
+	// Further request details for the text formats and templates
+	Start      time.Time `json:"-"`
+	RequestURI string    `json:"-"`
+	Proto      string    `json:"-"`
+	Referer    string    `json:"-"`
+}
+
+// accessLogger writes access log entries, one per line
+type accessLogger struct {
+	mu       sync.Mutex
+	out      io.Writer
+	headers  []string
+	format   string
+	template *template.Template
+}
+
+// newAccessLogger sets up the access log destination and format. Logs
+// written to AccessLogPath are buffered and flushed from a background
+// goroutine, which also closes the file once ctx is done.
+func newAccessLogger(ctx context.Context, config *Config) (*accessLogger, error) {
+	al := &accessLogger{out: config.AccessLogWriter, headers: config.LogRequestHeaders, format: config.AccessLogFormat}
+
+	switch al.format {
+	case "", "json", "clf", "combined":
+	default:
+		if !strings.Contains(al.format, "{{") {
+			return nil, fmt.Errorf("accessLogFormat must be json, clf, combined or a template, got %q", al.format)
+		}
+		tmpl, err := template.New("accessLog").Parse(al.format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accessLogFormat template: %w", err)
+		}
+		al.template = tmpl
+	}
+
+	if config.AccessLogPath == "" {
+		if al.out == nil {
+			al.out = os.Stdout
+		}
+		return al, nil
+	}
+
+	var out *os.File
+	switch config.AccessLogPath {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(config.AccessLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		out = f
+	}
+	buffered := bufio.NewWriter(out)
+	al.out = buffered
+	go al.flushPeriodically(ctx, buffered, out)
+	return al, nil
+}
+
+// flushPeriodically writes buffered lines out until ctx is done
+func (al *accessLogger) flushPeriodically(ctx context.Context, buffered *bufio.Writer, f *os.File) {
+	ticker := time.NewTicker(accessLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			al.mu.Lock()
+			buffered.Flush()
+			al.mu.Unlock()
+		case <-ctx.Done():
+			al.mu.Lock()
+			buffered.Flush()
+			al.mu.Unlock()
+			if f != os.Stdout && f != os.Stderr {
+				f.Close()
+			}
+			return
+		}
+	}
+}
+
+// logRecorder captures the status code and body size of a response
+type logRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (lr *logRecorder) WriteHeader(code int) {
+	if lr.status == 0 {
+		lr.status = code
+	}
+	lr.ResponseWriter.WriteHeader(code)
+}
+
+func (lr *logRecorder) Write(p []byte) (int, error) {
+	if lr.status == 0 {
+		lr.status = http.StatusOK
+	}
+	n, err := lr.ResponseWriter.Write(p)
+	lr.bytes += int64(n)
+	return n, err
+}
+
+// Hijack keeps detached sends working behind the access log
+func (lr *logRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("statiq: response writer does not support hijacking")
+	}
+	// A hijacked response is only ever a detached 200 send of the whole file
+	lr.status = http.StatusOK
+	lr.bytes, _ = strconv.ParseInt(lr.Header().Get("Content-Length"), 10, 64)
+	return hijacker.Hijack()
+}
+
+// Push keeps HTTP/2 server push working behind the access log
+func (lr *logRecorder) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := lr.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+// wrapAccessLog logs every request served by next
+func (h *StatiqHandler) wrapAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &logRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		h.accessLog.write(accessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     recorder.status,
+			Bytes:      recorder.bytes,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			RemoteIP:   h.clientIP(r),
+			UserAgent:  r.UserAgent(),
+			Headers:    h.accessLog.requestHeaders(r),
+			TraceID:    h.traceID(r),
+			Start:      start,
+			RequestURI: r.RequestURI,
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+		})
+	})
+}
+
+// requestHeaders collects the configured request headers, redacting credentials
+func (al *accessLogger) requestHeaders(r *http.Request) map[string]string {
+	if len(al.headers) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string, len(al.headers))
+	for _, name := range al.headers {
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[REDACTED]"
+		}
+		headers[name] = value
+	}
+
+	return headers
+}
+
+// formatEntry renders an entry in the configured format, without the trailing newline
+func (al *accessLogger) formatEntry(entry accessLogEntry) ([]byte, error) {
+	switch al.format {
+	case "", "json":
+		return json.Marshal(entry)
+	case "clf", "combined":
+		return []byte(commonLogLine(entry, al.format == "combined")), nil
+	}
+
+	var buf bytes.Buffer
+	if err := al.template.Execute(&buf, entry); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// commonLogLine formats an entry in the Common Log Format, adding the
+// referer and user agent for the combined format
+func commonLogLine(entry accessLogEntry, combined bool) string {
+	requestURI := entry.RequestURI
+	if requestURI == "" {
+		requestURI = entry.Path
+	}
+	size := "-"
+	if entry.Bytes > 0 {
+		size = strconv.FormatInt(entry.Bytes, 10)
+	}
+
+	line := fmt.Sprintf("%s - - [%s] %s %d %s", entry.RemoteIP, entry.Start.Format(clfTimeFormat),
+		strconv.Quote(entry.Method+" "+requestURI+" "+entry.Proto), entry.Status, size)
+	if combined {
+		line += " " + strconv.Quote(entry.Referer) + " " + strconv.Quote(entry.UserAgent)
+	}
+	return line
+}
+
+func (al *accessLogger) write(entry accessLogEntry) {
+	line, err := al.formatEntry(entry)
+	if err != nil {
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.out.Write(append(line, '\n'))
+}
@@ -0,0 +1,232 @@
+package statiq_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestLogRequestHeaders(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+	cfg.AccessLog = true
+	cfg.AccessLogWriter = &out
+	cfg.LogRequestHeaders = []string{"X-Forwarded-Host", "Authorization", "X-Missing"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/index.html", nil)
+	req.Header.Set("X-Forwarded-Host", "example.com")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Other", "not listed")
+	serve(handler, req)
+
+	var entry struct {
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON access log line, got %q: %v", out.String(), err)
+	}
+
+	if entry.Method != http.MethodGet || entry.Path != "/index.html" || entry.Status != http.StatusOK {
+		t.Errorf("Unexpected access log entry %+v", entry)
+	}
+	if entry.Headers["X-Forwarded-Host"] != "example.com" {
+		t.Errorf("Expected listed header to be logged, got %v", entry.Headers)
+	}
+	if entry.Headers["Authorization"] != "[REDACTED]" {
+		t.Errorf("Expected Authorization to be redacted, got %q", entry.Headers["Authorization"])
+	}
+	if _, ok := entry.Headers["X-Other"]; ok || len(entry.Headers) != 2 {
+		t.Errorf("Expected only listed, present headers, got %v", entry.Headers)
+	}
+}
+
+func TestTraceHeaders(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+	cfg.AccessLog = true
+	cfg.AccessLogWriter = &out
+	cfg.TraceHeaders = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry struct {
+		TraceID string `json:"trace_id"`
+	}
+	lastTraceID := func() string {
+		t.Helper()
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		if err := json.Unmarshal(lines[len(lines)-1], &entry); err != nil {
+			t.Fatalf("Expected a JSON access log line, got %q: %v", out.String(), err)
+		}
+		return entry.TraceID
+	}
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := newRequest(t, http.MethodGet, "http://localhost/index.html", nil)
+	req.Header.Set("traceparent", traceparent)
+	req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+	recorder := serve(handler, req)
+	if got := recorder.Header().Get("traceparent"); got != traceparent {
+		t.Errorf("Expected traceparent to be propagated, got %q", got)
+	}
+	if got := recorder.Header().Get("tracestate"); got != "congo=t61rcWkgMzE" {
+		t.Errorf("Expected tracestate to be propagated, got %q", got)
+	}
+	if got := lastTraceID(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace ID in the access log, got %q", got)
+	}
+
+	// Missing or malformed trace context starts a new trace
+	for _, incoming := range []string{"", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "garbage"} {
+		req := newRequest(t, http.MethodGet, "http://localhost/index.html", nil)
+		if incoming != "" {
+			req.Header.Set("traceparent", incoming)
+			req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+		}
+		recorder := serve(handler, req)
+		got := recorder.Header().Get("traceparent")
+		if len(got) != 55 || !strings.HasPrefix(got, "00-") || got == incoming {
+			t.Errorf("Expected a new traceparent for %q, got %q", incoming, got)
+			continue
+		}
+		if recorder.Header().Get("tracestate") != "" {
+			t.Errorf("Expected no tracestate for a new trace, got %q", recorder.Header().Get("tracestate"))
+		}
+		if traceID := lastTraceID(); traceID != got[3:35] {
+			t.Errorf("Expected the new trace ID %q in the access log, got %q", got[3:35], traceID)
+		}
+	}
+}
+
+func TestAccessLogFormat(t *testing.T) {
+	t.Parallel()
+
+	root := newTestRoot(t, map[string]string{"page.txt": "hello"})
+	newLoggingHandler := func(ctx context.Context, configure func(*statiq.Config)) http.Handler {
+		t.Helper()
+		cfg := statiq.CreateConfig()
+		cfg.Root = root
+		cfg.AccessLog = true
+		configure(cfg)
+		handler, err := statiq.New(ctx, next(t), cfg, "statiq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	request := func() *http.Request {
+		req := newRequest(t, http.MethodGet, "http://localhost/page.txt?v=1", nil)
+		req.RequestURI = "/page.txt?v=1"
+		req.RemoteAddr = "192.0.2.7:4321"
+		req.Header.Set("Referer", "https://example.com/")
+		req.Header.Set("User-Agent", "TestAgent/1.0")
+		return req
+	}
+
+	var combined bytes.Buffer
+	serve(newLoggingHandler(context.Background(), func(cfg *statiq.Config) {
+		cfg.AccessLogWriter = &combined
+		cfg.AccessLogFormat = "combined"
+	}), request())
+	expected := regexp.MustCompile(`^192\.0\.2\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /page\.txt\?v=1 HTTP/1\.1" 200 5 "https://example\.com/" "TestAgent/1\.0"\n$`)
+	if !expected.MatchString(combined.String()) {
+		t.Errorf("Unexpected combined log line %q", combined.String())
+	}
+
+	var custom bytes.Buffer
+	serve(newLoggingHandler(context.Background(), func(cfg *statiq.Config) {
+		cfg.AccessLogWriter = &custom
+		cfg.AccessLogFormat = "{{.Method}} {{.Path}} {{.Status}} {{.Bytes}}"
+	}), request())
+	if custom.String() != "GET /page.txt 200 5\n" {
+		t.Errorf("Unexpected template log line %q", custom.String())
+	}
+
+	// File logs are buffered and flushed when the context ends
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	ctx, cancel := context.WithCancel(context.Background())
+	serve(newLoggingHandler(ctx, func(cfg *statiq.Config) {
+		cfg.AccessLogPath = logFile
+		cfg.AccessLogFormat = "clf"
+	}), request())
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(logFile)
+		if strings.HasSuffix(string(data), `"GET /page.txt?v=1 HTTP/1.1" 200 5`+"\n") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the CLF line in the log file, got %q", data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = root
+	cfg.AccessLog = true
+	cfg.AccessLogFormat = "apache"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an unknown access log format")
+	}
+}
+
+func TestServiceMeshHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello"})
+	cfg.ServiceMeshHeaders = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/page.txt", nil)
+	req.Header.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	req.Header.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	req.Header.Set("X-B3-Sampled", "1")
+	header := serve(handler, req).Header()
+	if header.Get("X-B3-TraceId") != "80f198ee56343ba864fe8b2a57d3eff7" || header.Get("X-B3-Sampled") != "1" {
+		t.Errorf("Expected the B3 trace to be propagated, got %v", header)
+	}
+	if spanID := header.Get("X-B3-SpanId"); len(spanID) != 16 || spanID == "e457b5a2e4d86bd1" {
+		t.Errorf("Expected a new span ID, got %q", spanID)
+	}
+	if header.Get("X-B3-ParentSpanId") != "e457b5a2e4d86bd1" {
+		t.Errorf("Expected the caller's span as parent, got %q", header.Get("X-B3-ParentSpanId"))
+	}
+
+	// Requests outside a B3 trace are left alone
+	header = serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.txt", nil)).Header()
+	if header.Get("X-B3-TraceId") != "" || header.Get("X-B3-SpanId") != "" {
+		t.Errorf("Expected no B3 headers without an incoming trace, got %v", header)
+	}
+}
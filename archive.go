@@ -0,0 +1,172 @@
+package statiq
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isArchiveName reports whether ArchiveRoot names a supported archive
+func isArchiveName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// archiveBuilder collects the files of an archive into memFS entries
+type archiveBuilder struct {
+	entries map[string]*memEntry
+	modTime time.Time
+}
+
+// loadArchive unpacks a .zip, .tar.gz or .tgz file into an in-memory root.
+// Entry names are cleaned below the root, so none can escape it; links and
+// other special entries are skipped.
+func loadArchive(name string) (*memFS, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	b := &archiveBuilder{modTime: info.ModTime()}
+	b.entries = map[string]*memEntry{
+		"/": {info: &memFileInfo{name: "/", mode: fs.ModeDir | 0755, modTime: b.modTime}},
+	}
+
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		err = b.readZip(name)
+	} else {
+		err = b.readTarGz(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	for urlPath, e := range b.entries {
+		if urlPath != "/" {
+			parent := b.entries[path.Dir(urlPath)]
+			parent.children = append(parent.children, e.info)
+		}
+	}
+	for _, e := range b.entries {
+		sort.Slice(e.children, func(i, j int) bool { return e.children[i].Name() < e.children[j].Name() })
+	}
+	return &memFS{entries: b.entries}, nil
+}
+
+func (b *archiveBuilder) readZip(name string) error {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			if err := b.dir(path.Clean("/"+f.Name), f.Modified); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err := b.file(path.Clean("/"+f.Name), data, f.Modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *archiveBuilder) readTarGz(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := b.dir(path.Clean("/"+hdr.Name), hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			if err := b.file(path.Clean("/"+hdr.Name), data, hdr.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dir adds a directory and any missing parents
+func (b *archiveBuilder) dir(urlPath string, modTime time.Time) error {
+	if e, ok := b.entries[urlPath]; ok {
+		if !e.info.IsDir() {
+			return fmt.Errorf("%s is both a file and a directory", urlPath)
+		}
+		return nil
+	}
+	if err := b.dir(path.Dir(urlPath), b.modTime); err != nil {
+		return err
+	}
+
+	b.entries[urlPath] = &memEntry{info: &memFileInfo{
+		name:    path.Base(urlPath),
+		mode:    fs.ModeDir | 0755,
+		modTime: modTime,
+	}}
+	return nil
+}
+
+// file adds a regular file, replacing an earlier entry of the same name
+func (b *archiveBuilder) file(urlPath string, data []byte, modTime time.Time) error {
+	if e, ok := b.entries[urlPath]; ok && e.info.IsDir() || urlPath == "/" {
+		return fmt.Errorf("%s is both a file and a directory", urlPath)
+	}
+	if err := b.dir(path.Dir(urlPath), b.modTime); err != nil {
+		return err
+	}
+
+	b.entries[urlPath] = &memEntry{data: data, info: &memFileInfo{
+		name:    path.Base(urlPath),
+		size:    int64(len(data)),
+		mode:    0644,
+		modTime: modTime,
+	}}
+	return nil
+}
@@ -0,0 +1,143 @@
+package statiq_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+// archiveFiles is the site packed into the test archives
+var archiveFiles = map[string]string{
+	"index.html":      "home",
+	"docs/index.html": "docs",
+	"assets/app.js":   "code",
+	"../escape.txt":   "contained",
+}
+
+func writeZip(t *testing.T, name string) {
+	t.Helper()
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for file, content := range archiveFiles {
+		w, err := zw.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, name string) {
+	t.Helper()
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range archiveFiles {
+		hdr := &tar.Header{Name: file, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "link.html", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveRoot(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archives := map[string]func(*testing.T, string){
+		filepath.Join(dir, "site.zip"):    writeZip,
+		filepath.Join(dir, "site.tar.gz"): writeTarGz,
+	}
+
+	for name, write := range archives {
+		write(t, name)
+
+		cfg := statiq.CreateConfig()
+		cfg.ArchiveRoot = name
+		cfg.EnableDirectoryListing = true
+
+		handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(name), err)
+		}
+
+		for target, expected := range map[string]string{
+			"/index.html":      "home",
+			"/docs/index.html": "docs",
+			"/assets/app.js":   "code",
+			"/escape.txt":      "contained",
+			"/link.html":       "404",
+		} {
+			recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+			got := recorder.Body.String()
+			if recorder.Code == http.StatusNotFound {
+				got = "404"
+			}
+			if got != expected {
+				t.Errorf("%s: expected %q for %s, got %d %q", filepath.Base(name), expected, target, recorder.Code, recorder.Body.String())
+			}
+		}
+
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/docs/", nil))
+		if location := recorder.Header().Get("Location"); location != "/docs/index.html" {
+			t.Errorf("%s: expected the directory to resolve to its index file, got %d %q", filepath.Base(name), recorder.Code, location)
+		}
+		recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/assets/", nil))
+		if !strings.Contains(recorder.Body.String(), "app.js") {
+			t.Errorf("%s: expected a listing of the directory, got %d %q", filepath.Base(name), recorder.Code, recorder.Body.String())
+		}
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.ArchiveRoot = filepath.Join(dir, "site.zip")
+	cfg.Root = dir
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected root and archiveRoot to be mutually exclusive")
+	}
+	cfg.Root = ""
+	cfg.ArchiveRoot = filepath.Join(dir, "site.rar")
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an unsupported archive")
+	}
+}
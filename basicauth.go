@@ -0,0 +1,206 @@
+package statiq
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultBasicAuthRealm is announced when BasicAuth.Realm is unset
+const defaultBasicAuthRealm = "Restricted"
+
+// bcryptHashLen is the length of an encoded "$2y$10$<salt><checksum>" hash
+const bcryptHashLen = 60
+
+// maxVerifiedCredentials bounds the cache of credentials that passed a bcrypt check
+const maxVerifiedCredentials = 1024
+
+// credential is the stored hash of a user's password
+type credential interface {
+	matches(password []byte) bool
+}
+
+// bcryptCredential is an htpasswd -B "$2y$" hash
+type bcryptCredential []byte
+
+func (c bcryptCredential) matches(password []byte) bool {
+	return bcrypt.CompareHashAndPassword(c, password) == nil
+}
+
+// sha1Credential is an htpasswd -s "{SHA}" hash
+type sha1Credential []byte
+
+func (c sha1Credential) matches(password []byte) bool {
+	sum := sha1.Sum(password)
+	return subtle.ConstantTimeCompare(sum[:], c) == 1
+}
+
+// basicAuth checks requests against the users of an htpasswd file
+type basicAuth struct {
+	challenge string
+	users     map[string]credential
+	exclude   []string
+
+	// dummy is checked for unknown users so they take as long as known ones
+	dummy credential
+
+	// verified remembers credentials that passed, bcrypt being far too slow
+	// to run on every request of a page load. Entries are keyed by an HMAC
+	// under a random per-process key, so they never hold a password equivalent.
+	verifiedKey []byte
+	verified    *lruCache
+}
+
+// verifiedMark is the value stored for a verified credential; every entry
+// weighs one byte, so the cache holds maxVerifiedCredentials entries
+var verifiedMark = []byte{1}
+
+// loadBasicAuth reads the htpasswd file of the configuration
+func loadBasicAuth(config BasicAuth) (*basicAuth, error) {
+	data, err := os.ReadFile(config.HtpasswdFile)
+	if err != nil {
+		return nil, err
+	}
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", config.HtpasswdFile, err)
+	}
+
+	realm := config.Realm
+	if realm == "" {
+		realm = defaultBasicAuthRealm
+	}
+	a := &basicAuth{
+		challenge: "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`,
+		users:     users,
+		exclude:   config.Exclude,
+		verified:  newLRUCache(maxVerifiedCredentials),
+	}
+	a.verifiedKey = make([]byte, sha256.Size)
+	if _, err := rand.Read(a.verifiedKey); err != nil {
+		return nil, err
+	}
+
+	// The dummy costs as much as the most expensive stored hash
+	cost := 0
+	for _, c := range users {
+		if b, ok := c.(bcryptCredential); ok {
+			if hashCost, _ := bcrypt.Cost(b); hashCost > cost {
+				cost = hashCost
+			}
+		}
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	if cost == 0 {
+		sum := sha1.Sum(random)
+		a.dummy = sha1Credential(sum[:])
+		return a, nil
+	}
+	dummy, err := bcrypt.GenerateFromPassword(random, cost)
+	if err != nil {
+		return nil, err
+	}
+	a.dummy = bcryptCredential(dummy)
+	return a, nil
+}
+
+// parseHtpasswd decodes "user:hash" lines with bcrypt or {SHA} hashes,
+// skipping blank lines and # comments
+func parseHtpasswd(data []byte) (map[string]credential, error) {
+	users := map[string]credential{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", line)
+		}
+		if _, ok := users[user]; ok {
+			return nil, fmt.Errorf("line %d: duplicate user %q", line, user)
+		}
+
+		switch {
+		case strings.HasPrefix(hash, "$2"):
+			if len(hash) != bcryptHashLen {
+				return nil, fmt.Errorf("line %d: malformed bcrypt hash", line)
+			}
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			users[user] = bcryptCredential(hash)
+		case strings.HasPrefix(hash, "{SHA}"):
+			sum, err := base64.StdEncoding.DecodeString(hash[len("{SHA}"):])
+			if err != nil || len(sum) != sha1.Size {
+				return nil, fmt.Errorf("line %d: malformed {SHA} hash", line)
+			}
+			users[user] = sha1Credential(sum)
+		default:
+			return nil, fmt.Errorf("line %d: unsupported hash for user %q, use bcrypt (htpasswd -B) or SHA1 (htpasswd -s)", line, user)
+		}
+	}
+	return users, scanner.Err()
+}
+
+// excludes reports whether a request path bypasses authentication
+func (a *basicAuth) excludes(p string) bool {
+	for _, prefix := range a.exclude {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate reports whether the request carries valid credentials.
+// Unknown users are checked against the dummy hash, so response times do
+// not reveal which users exist.
+func (a *basicAuth) authenticate(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// The length prefix keeps user and password from running into each other
+	mac := hmac.New(sha256.New, a.verifiedKey)
+	mac.Write([]byte(strconv.Itoa(len(user)) + ":" + user + password))
+	key := string(mac.Sum(nil))
+	if _, verified := a.verified.Get(key); verified {
+		return true
+	}
+
+	c, known := a.users[user]
+	if !known {
+		c = a.dummy
+	}
+	if !c.matches([]byte(password)) || !known {
+		return false
+	}
+
+	a.verified.Add(key, verifiedMark)
+	return true
+}
+
+// serveUnauthorized asks the client for credentials
+func (a *basicAuth) serveUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", a.challenge)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,116 @@
+package statiq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseHtpasswdBcrypt(t *testing.T) {
+	t.Parallel()
+
+	// Hashes produced by crypt(3) in each of the versions htpasswd writes
+	for password, encoded := range map[string]string{
+		"U*U":                          "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"":                             "$2b$04$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxjiq",
+		"correct horse battery staple": "$2y$06$0123456789abcdefghijkeh5EYuTQp5F9p.ZOGN24mdsHNpBbgdAm",
+	} {
+		users, err := parseHtpasswd([]byte("alice:" + encoded + "\n"))
+		if err != nil {
+			t.Fatalf("%s: %v", encoded, err)
+		}
+		if !users["alice"].matches([]byte(password)) {
+			t.Errorf("Expected %q to match %s", password, encoded)
+		}
+		if users["alice"].matches([]byte("!" + password)) {
+			t.Errorf("Expected %q not to match %s", "!"+password, encoded)
+		}
+	}
+
+	for _, encoded := range []string{
+		"$2b$04$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxji",
+		"$2b$03$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxjiq",
+		"$2b$xx$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxjiq",
+	} {
+		if _, err := parseHtpasswd([]byte("alice:" + encoded + "\n")); err == nil {
+			t.Errorf("Expected %s to be rejected", encoded)
+		}
+	}
+}
+
+func TestBasicAuthVerifiedCache(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var htpasswd strings.Builder
+	for i := 0; i < maxVerifiedCredentials+10; i++ {
+		htpasswd.WriteString("user" + strconv.Itoa(i) + ":" + string(hash) + "\n")
+	}
+	users, err := parseHtpasswd([]byte(htpasswd.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &basicAuth{users: users, verifiedKey: []byte("key"), verified: newLRUCache(maxVerifiedCredentials), dummy: users["user0"]}
+
+	login := func(user, password string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, password)
+		return a.authenticate(req)
+	}
+
+	for i := 0; i < maxVerifiedCredentials+10; i++ {
+		if !login("user"+strconv.Itoa(i), "secret") {
+			t.Fatalf("Expected user%d to be accepted", i)
+		}
+	}
+
+	// The cache stays bounded and evicts the least recently used entries
+	a.verified.mu.Lock()
+	entries := len(a.verified.items)
+	a.verified.mu.Unlock()
+	if entries != maxVerifiedCredentials {
+		t.Errorf("Expected %d cached credentials, got %d", maxVerifiedCredentials, entries)
+	}
+
+	// Only credentials that passed are remembered
+	if login("user1", "wrong") {
+		t.Error("Expected a wrong password to be rejected")
+	}
+}
+
+func TestBasicAuthUnknownUser(t *testing.T) {
+	users, err := parseHtpasswd([]byte("alice:$2y$08$0123456789abcdefghijkednwuX6IcnWdWrYip/ZU1tS56vVMn7QO\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &basicAuth{users: users, verifiedKey: []byte("key"), verified: newLRUCache(maxVerifiedCredentials)}
+	dummy, err := bcrypt.GenerateFromPassword([]byte("dummy"), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.dummy = bcryptCredential(dummy)
+
+	elapsed := func(user string) time.Duration {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, "wrong")
+		start := time.Now()
+		if a.authenticate(req) {
+			t.Fatalf("Expected %s to be rejected", user)
+		}
+		return time.Since(start)
+	}
+
+	// An unknown user pays for a full bcrypt check, like a wrong password does
+	known, unknown := elapsed("alice"), elapsed("mallory")
+	if unknown < known/4 {
+		t.Errorf("Expected unknown users to take as long as known ones, got %s and %s", unknown, known)
+	}
+}
@@ -0,0 +1,72 @@
+package statiq
+
+import (
+	"path"
+	"strings"
+)
+
+// maxCaseFoldDepth bounds the path segments resolved by CaseInsensitive
+const maxCaseFoldDepth = 32
+
+// caseFoldBatch is how many directory entries are read at a time while
+// looking for a segment, so large directories are not listed in full
+const caseFoldBatch = 128
+
+// caseFoldedPath resolves upath one segment at a time, taking a segment as
+// it is when it exists and otherwise the first entry of its directory that
+// differs only in case. It returns false when a segment has no match or the
+// match is a file that is never served.
+func (h *StatiqHandler) caseFoldedPath(upath string) (string, bool) {
+	segments := strings.Split(strings.Trim(upath, "/"), "/")
+	if len(segments) > maxCaseFoldDepth {
+		return "", false
+	}
+
+	resolved := "/"
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		if f, err := h.root.Open(path.Join(resolved, segment)); err == nil {
+			f.Close()
+			resolved = path.Join(resolved, segment)
+			continue
+		}
+		match, ok := h.findCaseFolded(resolved, segment)
+		if !ok {
+			return "", false
+		}
+		resolved = path.Join(resolved, match)
+	}
+
+	// Files hidden by name stay hidden whatever case they are asked for in
+	if h.hidesDotPath(resolved) || h.readSidecars && path.Base(resolved) == sidecarFileName {
+		return "", false
+	}
+	if strings.HasSuffix(upath, "/") && resolved != "/" {
+		resolved += "/"
+	}
+	return resolved, true
+}
+
+// findCaseFolded returns the name of the first entry of dir equal to name
+// under case folding
+func (h *StatiqHandler) findCaseFolded(dir, name string) (string, bool) {
+	f, err := h.root.Open(dir)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	for {
+		entries, err := f.Readdir(caseFoldBatch)
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name(), name) {
+				return entry.Name(), true
+			}
+		}
+		if err != nil || len(entries) == 0 {
+			return "", false
+		}
+	}
+}
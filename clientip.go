@@ -0,0 +1,127 @@
+package statiq
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client that issued the request. With
+// TrustedProxies set, forwarding headers are only believed when the
+// connection comes from a trusted proxy: X-Real-IP first when trusted, then
+// the X-Forwarded-For walk. Without TrustedProxies, a trusted X-Real-IP is
+// preferred, followed by the first X-Forwarded-For hop. Otherwise the
+// connection's remote address is used. This is the address used by
+// IP-based access control, rate limiting and access logs.
+func (h *StatiqHandler) clientIP(r *http.Request) string {
+	if len(h.trustedProxies) > 0 {
+		if h.trustXRealIP && containsIP(h.trustedProxies, net.ParseIP(remoteIP(r))) {
+			if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+				return ip
+			}
+		}
+		return h.forwardedIP(r)
+	}
+
+	if h.trustXRealIP {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			if ip := strings.TrimSpace(strings.Split(xff, ",")[0]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	return remoteIP(r)
+}
+
+// forwardedIP walks X-Forwarded-For from the nearest hop back while each
+// address is a trusted proxy, returning the first one that is not. Hops
+// added by untrusted peers are never believed, since clients can send any
+// X-Forwarded-For they like.
+func (h *StatiqHandler) forwardedIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !containsIP(h.trustedProxies, net.ParseIP(ip)) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		parsed := net.ParseIP(hop)
+		if parsed == nil {
+			// A malformed hop ends the chain at the last proxy that vouched for it
+			return ip
+		}
+		ip = hop
+		if !containsIP(h.trustedProxies, parsed) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// parseCIDRs parses CIDR ranges, accepting single addresses as ranges of one
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether ip lies in any of the ranges
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockedIP applies IPDenyList and IPAllowList to the client IP. A denied
+// address is blocked even when it is also allowed, and addresses that
+// cannot be parsed are blocked whenever either list is set.
+func (h *StatiqHandler) isBlockedIP(r *http.Request) bool {
+	if len(h.ipAllowList) == 0 && len(h.ipDenyList) == 0 {
+		return false
+	}
+
+	ip := net.ParseIP(h.clientIP(r))
+	if ip == nil || containsIP(h.ipDenyList, ip) {
+		return true
+	}
+	return len(h.ipAllowList) > 0 && !containsIP(h.ipAllowList, ip)
+}
+
+// remoteIP strips the port from the connection's remote address
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,125 @@
+package statiq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		trust    bool
+		headers  map[string]string
+		expected string
+	}{
+		{name: "remote address", expected: "192.0.2.1"},
+		{name: "untrusted headers ignored", headers: map[string]string{"X-Real-IP": "198.51.100.7"}, expected: "192.0.2.1"},
+		{name: "x-real-ip", trust: true, headers: map[string]string{"X-Real-IP": "198.51.100.7", "X-Forwarded-For": "203.0.113.9"}, expected: "198.51.100.7"},
+		{name: "x-forwarded-for fallback", trust: true, headers: map[string]string{"X-Forwarded-For": "203.0.113.9, 10.0.0.1"}, expected: "203.0.113.9"},
+		{name: "remote address fallback", trust: true, expected: "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			req.RemoteAddr = "192.0.2.1:41234"
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			h := &StatiqHandler{trustXRealIP: test.trust}
+			if got := h.clientIP(req); got != test.expected {
+				t.Errorf("Expected client IP %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		remote   string
+		xff      string
+		expected string
+	}{
+		{name: "untrusted peer", remote: "198.51.100.7:1234", xff: "203.0.113.9", expected: "198.51.100.7"},
+		{name: "single hop", remote: "10.1.2.3:1234", xff: "203.0.113.9", expected: "203.0.113.9"},
+		{name: "multiple hops", remote: "10.1.2.3:1234", xff: "203.0.113.9, 198.51.100.20, 10.9.9.9", expected: "198.51.100.20"},
+		{name: "spoofed first hop ignored", remote: "192.0.2.1:1234", xff: "1.1.1.1, 203.0.113.9", expected: "203.0.113.9"},
+		{name: "all hops trusted", remote: "10.1.2.3:1234", xff: "10.0.0.7, 10.0.0.8", expected: "10.0.0.7"},
+		{name: "malformed hop", remote: "10.1.2.3:1234", xff: "203.0.113.9, not-an-ip, 10.0.0.8", expected: "10.0.0.8"},
+		{name: "no header", remote: "10.1.2.3:1234", expected: "10.1.2.3"},
+		{name: "ipv6 proxy", remote: "[2001:db8:ffff::1]:443", xff: "2001:db8:1::42", expected: "2001:db8:1::42"},
+		{name: "ipv6 outside range", remote: "[2001:db8:fffe::1]:443", xff: "2001:db8:1::42", expected: "2001:db8:fffe::1"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			req.RemoteAddr = test.remote
+			if test.xff != "" {
+				req.Header.Set("X-Forwarded-For", test.xff)
+			}
+
+			h := &StatiqHandler{trustedProxies: trusted}
+			if got := h.clientIP(req); got != test.expected {
+				t.Errorf("Expected client IP %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestTrustXRealIPWithTrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		remote   string
+		headers  map[string]string
+		expected string
+	}{
+		{name: "spoofed x-real-ip from untrusted peer", remote: "198.51.100.7:1234", headers: map[string]string{"X-Real-IP": "10.0.0.1"}, expected: "198.51.100.7"},
+		{name: "spoofed x-forwarded-for from untrusted peer", remote: "198.51.100.7:1234", headers: map[string]string{"X-Forwarded-For": "10.0.0.1"}, expected: "198.51.100.7"},
+		{name: "x-real-ip from trusted proxy", remote: "10.1.2.3:1234", headers: map[string]string{"X-Real-IP": "203.0.113.9", "X-Forwarded-For": "1.1.1.1"}, expected: "203.0.113.9"},
+		{name: "trusted proxy without x-real-ip", remote: "10.1.2.3:1234", headers: map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.9"}, expected: "203.0.113.9"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			req.RemoteAddr = test.remote
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			h := &StatiqHandler{trustXRealIP: true, trustedProxies: trusted}
+			if got := h.clientIP(req); got != test.expected {
+				t.Errorf("Expected client IP %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package statiq
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// defaultGzipMinSize is the smallest file compressed when GzipMinSize is unset
+const defaultGzipMinSize = 1024
+
+// defaultBrotliQuality is the compression effort used when BrotliQuality is unset
+const defaultBrotliQuality = 4
+
+// compressibleTypes are the compressible media types beyond text/*
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/xml":           true,
+	"application/rss+xml":       true,
+	"application/atom+xml":      true,
+	"application/wasm":          true,
+	"image/svg+xml":             true,
+}
+
+// isCompressible reports whether a Content-Type benefits from compression
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// responseEncoding picks the content coding the file is compressed with on
+// the fly for this request, or "" when it is served as it is. Brotli wins
+// over gzip unless the client gives gzip a higher quality value. Compressible
+// responses are marked as varying on Accept-Encoding.
+func (h *StatiqHandler) responseEncoding(w http.ResponseWriter, r *http.Request, d fs.FileInfo) string {
+	if !h.gzip && !h.brotli || d.Size() < h.gzipMinSize || !isCompressible(w.Header().Get("Content-Type")) {
+		return ""
+	}
+
+	addVary(w.Header(), "Accept-Encoding")
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	brQuality, gzipQuality := 0.0, 0.0
+	if h.brotli {
+		brQuality = acceptQuality(acceptEncoding, "br")
+	}
+	if h.gzip {
+		gzipQuality = acceptQuality(acceptEncoding, "gzip")
+	}
+
+	switch {
+	case brQuality > 0 && brQuality >= gzipQuality:
+		return "br"
+	case gzipQuality > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// newEncoder starts the compressed stream for a content coding
+func (h *StatiqHandler) newEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	if encoding == "br" {
+		return brotli.NewWriterLevel(w, h.brotliQuality), nil
+	}
+	return gzip.NewWriterLevel(w, h.gzipLevel)
+}
+
+// compressWriter compresses successful response bodies. The compressed
+// stream is only started by the first body write, so HEAD requests and
+// empty bodies stay empty.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	newEncoder  func(encoding string, w io.Writer) (io.WriteCloser, error)
+	encoder     io.WriteCloser
+	active      bool
+	wroteHeader bool
+}
+
+func (h *StatiqHandler) newCompressWriter(w http.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{ResponseWriter: w, encoding: encoding, newEncoder: h.newEncoder}
+}
+
+// WriteHeader drops Content-Length since the compressed length differs
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.active = code == http.StatusOK
+	if cw.active {
+		cw.Header().Del("Content-Length")
+		cw.Header().Set("Content-Encoding", cw.encoding)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.active {
+		return cw.ResponseWriter.Write(p)
+	}
+
+	if cw.encoder == nil {
+		encoder, err := cw.newEncoder(cw.encoding, cw.ResponseWriter)
+		if err != nil {
+			return 0, err
+		}
+		cw.encoder = encoder
+	}
+	return cw.encoder.Write(p)
+}
+
+// Close completes the compressed stream, if one was started
+func (cw *compressWriter) Close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+	return cw.encoder.Close()
+}
@@ -0,0 +1,125 @@
+package statiq_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestGzip(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("body { color: red; }\n", 100)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"large.css":    large,
+		"small.css":    "body{}",
+		"image.png":    strings.Repeat("x", 2048),
+		"cached.js":    large,
+		"cached.js.gz": "precompressed",
+	})
+	cfg.Gzip = true
+	cfg.GzipLevel = gzip.BestCompression
+	cfg.PreCompressed = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/large.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	recorder := serve(handler, req)
+	if recorder.Header().Get("Content-Encoding") != "gzip" || recorder.Header().Get("Content-Length") != "" {
+		t.Fatalf("Expected a gzip response without Content-Length, got %v", recorder.Header())
+	}
+	if vary := recorder.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+		t.Errorf("Expected a single Vary: Accept-Encoding, got %q", vary)
+	}
+	gz, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(gz); err != nil || string(body) != large {
+		t.Errorf("Expected the decompressed file, got %d bytes: %v", len(body), err)
+	}
+
+	tests := map[string]struct {
+		path, acceptEncoding, body string
+	}{
+		"no gzip support":     {"/large.css", "br", large},
+		"below the threshold": {"/small.css", "gzip", "body{}"},
+		"not compressible":    {"/image.png", "gzip", strings.Repeat("x", 2048)},
+		"pre-compressed":      {"/cached.js", "gzip", "precompressed"},
+	}
+	for name, test := range tests {
+		req := newRequest(t, http.MethodGet, "http://localhost"+test.path, nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		recorder := serve(handler, req)
+		if recorder.Body.String() != test.body {
+			t.Errorf("%s: expected the body to be served as is, got %q", name, recorder.Body.String())
+		}
+	}
+
+	req = newRequest(t, http.MethodHead, "http://localhost/large.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if recorder = serve(handler, req); recorder.Body.Len() != 0 || recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected gzip headers and no body for HEAD, got %d bytes", recorder.Body.Len())
+	}
+
+	cfg.GzipLevel = 10
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an out of range gzip level")
+	}
+}
+
+func TestBrotli(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("console.log('statiq');\n", 200)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"app.js": large})
+	cfg.Brotli = true
+	cfg.Gzip = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"gzip, deflate, br":    "br",
+		"br;q=0.5, gzip":       "gzip",
+		"br;q=0.8, gzip;q=0.8": "br",
+		"br;q=0, gzip":         "gzip",
+		"identity":             "",
+	}
+	for acceptEncoding, want := range tests {
+		req := newRequest(t, http.MethodGet, "http://localhost/app.js", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		recorder := serve(handler, req)
+		if got := recorder.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("Expected %q encoding for %q, got %q", want, acceptEncoding, got)
+		}
+		if want == "br" && recorder.Body.Len() >= len(large)/10 {
+			t.Errorf("Expected a compressed body for %q, got %d of %d bytes", acceptEncoding, recorder.Body.Len(), len(large))
+		}
+		if want == "br" {
+			decoded, err := io.ReadAll(brotli.NewReader(recorder.Body))
+			if err != nil || string(decoded) != large {
+				t.Errorf("Expected the Brotli body for %q to decode to the original, got %d bytes: %v", acceptEncoding, len(decoded), err)
+			}
+		}
+	}
+
+	cfg.BrotliQuality = 12
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an out of range Brotli quality")
+	}
+}
@@ -0,0 +1,131 @@
+package statiq
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultCORSMethods are allowed in preflights when CORS.AllowMethods is unset
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// corsPolicy is the CORS configuration with its header values joined once
+type corsPolicy struct {
+	origins     []string
+	methods     string
+	headers     string
+	expose      string
+	credentials bool
+	maxAge      string
+}
+
+func newCORSPolicy(config CORS) *corsPolicy {
+	methods := config.AllowMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	p := &corsPolicy{
+		origins:     config.AllowOrigins,
+		methods:     strings.Join(methods, ", "),
+		headers:     strings.Join(config.AllowHeaders, ", "),
+		expose:      strings.Join(config.ExposeHeaders, ", "),
+		credentials: config.AllowCredentials,
+	}
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(config.MaxAge)
+	}
+	return p
+}
+
+// allows reports whether origin matches one of the allowed origins; "*"
+// matches any origin and other patterns are matched with path.Match, so
+// "https://*.example.com" allows every subdomain
+func (p *corsPolicy) allows(origin string) bool {
+	for _, pattern := range p.origins {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreflight reports whether r is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// setCORSHeaders sets the cross-origin response headers
+func (h *StatiqHandler) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	// Let cross-origin scripts read the listed response headers
+	if h.exposeHeaders != "" {
+		w.Header().Set("Access-Control-Expose-Headers", h.exposeHeaders)
+	}
+
+	if h.cors != nil {
+		h.setCORSOriginHeaders(w, r)
+	}
+
+	// Preflights from public pages to a private network address must be
+	// explicitly allowed (Private Network Access)
+	if h.corsAllowPrivateNetwork && r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+		w.Header().Set("Access-Control-Allow-Private-Network", "true")
+	}
+}
+
+// serveOptions answers an OPTIONS request with the configured body
+func (h *StatiqHandler) serveOptions(w http.ResponseWriter) {
+	w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if h.optionsResponse == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, h.optionsResponse)
+}
+
+// setCORSOriginHeaders grants an allowed Origin access to the response and,
+// for preflights, to the requested method and headers
+func (h *StatiqHandler) setCORSOriginHeaders(w http.ResponseWriter, r *http.Request) {
+	p := h.cors
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !p.allows(origin) {
+		return
+	}
+
+	// A lone wildcard is sent as is; credentials cannot be combined with it
+	if len(p.origins) == 1 && p.origins[0] == "*" {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if p.expose != "" {
+		header.Set("Access-Control-Expose-Headers", p.expose)
+	}
+
+	if !isPreflight(r) {
+		return
+	}
+	header.Set("Access-Control-Allow-Methods", p.methods)
+	if p.headers != "" {
+		header.Set("Access-Control-Allow-Headers", p.headers)
+	}
+	if p.maxAge != "" {
+		header.Set("Access-Control-Max-Age", p.maxAge)
+	}
+}
@@ -0,0 +1,184 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestAccessControlExposeHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"schema.json": "{}"})
+	cfg.AccessControlExposeHeaders = []string{"ETag", "Content-Length", "X-Request-ID"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/schema.json", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	recorder := serve(handler, req)
+
+	expected := "ETag, Content-Length, X-Request-ID"
+	if got := recorder.Header().Get("Access-Control-Expose-Headers"); got != expected {
+		t.Errorf("Expected Access-Control-Expose-Headers: %s, got %s", expected, got)
+	}
+}
+
+func TestOptionsResponse(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodOptions, "http://localhost/index.html", nil))
+	if recorder.Code != http.StatusNoContent || recorder.Body.Len() != 0 {
+		t.Errorf("Expected empty 204 for OPTIONS, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	cfg.OptionsResponse = "methods: GET, HEAD"
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodOptions, "http://localhost/index.html", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "methods: GET, HEAD" {
+		t.Errorf("Expected 200 with custom body, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+		t.Errorf("Expected text/plain Content-Type, got %s", contentType)
+	}
+}
+
+func TestCORSAllowPrivateNetwork(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+	cfg.CORSAllowPrivateNetwork = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preflight := func(privateNetwork string) string {
+		req := newRequest(t, http.MethodOptions, "http://localhost/index.html", nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		if privateNetwork != "" {
+			req.Header.Set("Access-Control-Request-Private-Network", privateNetwork)
+		}
+		return serve(handler, req).Header().Get("Access-Control-Allow-Private-Network")
+	}
+
+	if got := preflight("true"); got != "true" {
+		t.Errorf("Expected Access-Control-Allow-Private-Network: true, got %q", got)
+	}
+	if got := preflight(""); got != "" {
+		t.Errorf("Expected no private network header without the request header, got %q", got)
+	}
+
+	// Only preflights are answered
+	req := newRequest(t, http.MethodGet, "http://localhost/index.html", nil)
+	req.Header.Set("Access-Control-Request-Private-Network", "true")
+	if got := serve(handler, req).Header().Get("Access-Control-Allow-Private-Network"); got != "" {
+		t.Errorf("Expected no private network header on GET, got %q", got)
+	}
+
+	cfg.CORSAllowPrivateNetwork = false
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := preflight("true"); got != "" {
+		t.Errorf("Expected no private network header when disabled, got %q", got)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"font.woff2": "font"})
+	cfg.CORS = statiq.CORS{
+		AllowOrigins:     []string{"https://app.example.com", "https://*.cdn.example.com"},
+		AllowHeaders:     []string{"X-Requested-With"},
+		ExposeHeaders:    []string{"ETag"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(origin string) http.Header {
+		req := newRequest(t, http.MethodGet, "http://localhost/font.woff2", nil)
+		req.Header.Set("Origin", origin)
+		recorder := serve(handler, req)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected 200 for %s, got %d", origin, recorder.Code)
+		}
+		return recorder.Header()
+	}
+
+	for _, origin := range []string{"https://app.example.com", "https://eu.cdn.example.com"} {
+		header := get(origin)
+		if header.Get("Access-Control-Allow-Origin") != origin || header.Get("Access-Control-Allow-Credentials") != "true" ||
+			header.Get("Access-Control-Expose-Headers") != "ETag" || header.Get("Vary") != "Origin" {
+			t.Errorf("Unexpected CORS headers for %s: %v", origin, header)
+		}
+		if header.Get("Access-Control-Allow-Methods") != "" {
+			t.Errorf("Expected preflight headers only on preflights, got %v", header)
+		}
+	}
+	if header := get("https://evil.example.org"); header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no access for an unlisted origin, got %v", header)
+	}
+
+	req := newRequest(t, http.MethodOptions, "http://localhost/missing.woff2", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	recorder := serve(handler, req)
+	header := recorder.Header()
+	if recorder.Code != http.StatusNoContent || recorder.Body.Len() != 0 {
+		t.Errorf("Expected an empty 204 preflight response, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if header.Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS" || header.Get("Access-Control-Allow-Headers") != "X-Requested-With" ||
+		header.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Unexpected preflight headers %v", header)
+	}
+
+	// Without credentials a lone wildcard is sent as is
+	cfg.CORS = statiq.CORS{AllowOrigins: []string{"*"}}
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := get("https://anywhere.example"); header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Expected Access-Control-Allow-Origin: *, got %v", header)
+	}
+
+	cfg.CORS = statiq.CORS{AllowOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for credentials with the wildcard origin")
+	}
+
+	cfg.CORS = statiq.CORS{AllowOrigins: []string{"https://[.example.com"}}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a malformed origin pattern")
+	}
+}
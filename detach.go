@@ -0,0 +1,152 @@
+package statiq
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// detachWriteTimeout is how long a detached send may go without the client
+// accepting any data before the connection is dropped
+const detachWriteTimeout = 30 * time.Second
+
+// inFlightContextKey holds the request's *inFlightSlot when RequestCountLimit is set
+type inFlightContextKey struct{}
+
+// inFlightSlot is a request's share of RequestCountLimit. A detached send
+// takes it over so the slot stays held until the file is sent.
+type inFlightSlot struct {
+	detached bool
+}
+
+// detachInFlightSlot hands the request's slot to a detached send and returns
+// the function releasing it, or a no-op when there is no limit
+func (h *StatiqHandler) detachInFlightSlot(ctx context.Context) func() {
+	slot, ok := ctx.Value(inFlightContextKey{}).(*inFlightSlot)
+	if !ok {
+		return func() {}
+	}
+
+	slot.detached = true
+	return func() { h.inFlight.Add(-1) }
+}
+
+// canDetach reports whether the response for d may be sent from a detached
+// goroutine. Only plain, unconditional GETs of whole files qualify; anything
+// that needs http.ServeContent's range or precondition logic stays attached.
+func (h *StatiqHandler) canDetach(r *http.Request, d fs.FileInfo) bool {
+	if !h.detachLargeFiles || d.Size() <= h.detachThreshold || r.Method != http.MethodGet || r.ProtoMajor != 1 {
+		return false
+	}
+
+	for _, header := range []string{"Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// transformsContent reports whether the body served for the file differs
+// from the bytes on disk, which rules out sending the file directly
+func (h *StatiqHandler) transformsContent(w http.ResponseWriter, r *http.Request, d fs.FileInfo) bool {
+	if _, ok := h.imageOptionsFor(w, r, d); ok {
+		return true
+	}
+	return h.transformsHTML() && isHTMLResponse(w) || h.minifiesHTML(w, d) ||
+		h.minifiesCSS(w, r.URL.Path) || h.minifiesJS(w, r.URL.Path, d) || h.responseEncoding(w, r, d) != ""
+}
+
+// serveDetached hijacks the connection and streams the file from a new
+// goroutine so the request goroutine returns immediately. The connection is
+// closed afterwards since a hijacked connection cannot go back to the pool.
+// It returns false when the connection cannot be hijacked.
+func (h *StatiqHandler) serveDetached(w http.ResponseWriter, r *http.Request, upath string, d fs.FileInfo) bool {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return false
+	}
+
+	// Open a dedicated handle, the caller closes its own when it returns
+	f, err := h.root.Open(upath)
+	if err != nil {
+		return false
+	}
+
+	// The headers are completed before hijacking so wrapping writers see the
+	// final response: header hooks run on them and the access log reads the length
+	header := w.Header()
+	header.Set("Content-Length", strconv.FormatInt(d.Size(), 10))
+	header.Set("Connection", "close")
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		header.Del("Connection")
+		f.Close()
+		return false
+	}
+
+	header = header.Clone()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/octet-stream")
+	}
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	// The hijacked buffered writer is empty, so the response goes through a
+	// fresh one that keeps pushing the write deadline out
+	bw := bufio.NewWriter(&deadlineWriter{conn: conn, timeout: detachWriteTimeout})
+	release := h.detachInFlightSlot(r.Context())
+
+	go func() {
+		defer release()
+		defer conn.Close()
+		defer f.Close()
+
+		if err := writeDetachedResponse(bw, header, f); err != nil {
+			log.Printf("statiq: detached send of %s failed: %v", upath, err)
+		}
+	}()
+
+	return true
+}
+
+// writeDetachedResponse writes a complete 200 response to the raw connection
+func writeDetachedResponse(bw *bufio.Writer, header http.Header, body io.Reader) error {
+	if _, err := fmt.Fprintf(bw, "HTTP/1.1 %d %s\r\n", http.StatusOK, http.StatusText(http.StatusOK)); err != nil {
+		return err
+	}
+	if err := header.Write(bw); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, body); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// deadlineWriter extends the connection's write deadline before every write,
+// so a client that stops reading cannot hold a detached send open forever
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if err := dw.conn.SetWriteDeadline(time.Now().Add(dw.timeout)); err != nil {
+		return 0, err
+	}
+	return dw.conn.Write(p)
+}
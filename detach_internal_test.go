@@ -0,0 +1,40 @@
+package statiq
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDeadlineWriter(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	dw := &deadlineWriter{conn: server, timeout: 50 * time.Millisecond}
+
+	// Each write gets a fresh deadline while the client keeps reading
+	go func() {
+		buf := make([]byte, 4)
+		for i := 0; i < 2; i++ {
+			time.Sleep(30 * time.Millisecond)
+			if _, err := client.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		if _, err := dw.Write([]byte("data")); err != nil {
+			t.Fatalf("Expected write %d to succeed, got %v", i, err)
+		}
+	}
+
+	// A client that stops reading times the write out
+	if _, err := dw.Write([]byte("data")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected a deadline error, got %v", err)
+	}
+}
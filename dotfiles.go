@@ -0,0 +1,22 @@
+package statiq
+
+import "strings"
+
+// isDotName reports whether a file name is hidden by HideDotFiles
+func (h *StatiqHandler) isDotName(name string) bool {
+	return h.hideDotFiles && strings.HasPrefix(name, ".") && name != "." && !h.allowDotFiles[name]
+}
+
+// hidesDotPath reports whether any segment of a request path names a hidden
+// file or directory, so that /.git/config is hidden along with /.git
+func (h *StatiqHandler) hidesDotPath(upath string) bool {
+	if !h.hideDotFiles {
+		return false
+	}
+	for _, segment := range strings.Split(upath, "/") {
+		if h.isDotName(segment) {
+			return true
+		}
+	}
+	return false
+}
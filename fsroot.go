@@ -0,0 +1,22 @@
+package statiq
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+)
+
+// fsRoot serves an fs.FS, such as an embed.FS, as the root. Names are cleaned
+// the way http.Dir cleans them, since fs.FS refuses trailing slashes and dot
+// segments.
+type fsRoot struct {
+	http.FileSystem
+}
+
+func newFSRoot(fsys fs.FS) fsRoot {
+	return fsRoot{FileSystem: http.FS(fsys)}
+}
+
+func (r fsRoot) Open(name string) (http.File, error) {
+	return r.FileSystem.Open(path.Clean("/" + name))
+}
@@ -0,0 +1,63 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestNewFromFS(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte("home")},
+		"docs/index.html":  {Data: []byte("docs")},
+		"assets/app.js":    {Data: []byte("code")},
+		"assets/style.css": {Data: []byte("body{}")},
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = "/nonexistent"
+	cfg.EnableDirectoryListing = true
+	cfg.SPAMode = true
+	cfg.SPAIndex = "index.html"
+
+	handler, err := statiq.NewFromFS(context.Background(), next(t), cfg, "statiq", fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		target, expected string
+	}{
+		{"/assets/app.js", "code"},
+		{"/index.html", "home"},
+		{"/docs/index.html", "docs"},
+		{"/users/42", "home"},
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+test.target, nil))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != test.expected {
+			t.Errorf("Expected %q for %s, got %d %q", test.expected, test.target, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/docs/", nil))
+	if location := recorder.Header().Get("Location"); recorder.Code != http.StatusMovedPermanently || location != "/docs/index.html" {
+		t.Errorf("Expected the directory to resolve to its index file, got %d %q", recorder.Code, location)
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/assets/", nil))
+	if body := recorder.Body.String(); recorder.Code != http.StatusOK || !strings.Contains(body, "app.js") || !strings.Contains(body, "style.css") {
+		t.Errorf("Expected a listing of the directory, got %d %q", recorder.Code, body)
+	}
+
+	// Options working on the root directory itself have nothing to work on
+	cfg.ServeFromMemory = true
+	if _, err := statiq.NewFromFS(context.Background(), next(t), cfg, "statiq", fsys); err == nil {
+		t.Error("Expected serveFromMemory to be refused with a file system")
+	}
+}
@@ -0,0 +1,122 @@
+package statiq
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeCacheFile writes a disk cache entry. With SyncOnShutdown, entries
+// written after the cache has been synced are committed straight away.
+func (h *StatiqHandler) writeCacheFile(name string, data []byte) error {
+	h.cacheWrites.Add(1)
+	defer h.cacheWrites.Add(-1)
+
+	if err := writeFileAtomic(name, data); err != nil {
+		return err
+	}
+
+	if h.syncOnShutdown && h.cacheSynced.Load() {
+		return syncFile(name)
+	}
+	return nil
+}
+
+// syncOnDone runs Close once ctx is done, after waiting up to shutdownTimeout
+// for cache writes still in progress to finish
+func (h *StatiqHandler) syncOnDone(ctx context.Context) {
+	<-ctx.Done()
+
+	deadline := time.Now().Add(shutdownTimeout)
+	for h.cacheWrites.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := h.Close(); err != nil {
+		log.Printf("statiq: syncing the disk cache failed: %v", err)
+	}
+}
+
+// Close flushes the disk cache files written since startup, and the
+// directories holding them, to stable storage when SyncOnShutdown is set.
+// New and NewFromFS run it once their context is done; the standalone server
+// runs it after requests have drained. Calling it again syncs anything new.
+func (h *StatiqHandler) Close() error {
+	if !h.syncOnShutdown {
+		return nil
+	}
+	h.cacheSynced.Store(true)
+
+	var firstErr error
+	dirs := map[string]bool{}
+	for _, root := range []string{h.imageCachePath, h.thumbnailDir, h.prerenderCachePath} {
+		if root == "" || dirs[root] {
+			continue
+		}
+		dirs[root] = true
+
+		// The cache directories are walked rather than remembering every
+		// written name, so memory use does not grow with the cache
+		filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				dirs[name] = true
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.ModTime().Before(h.cacheSince) {
+				return nil
+			}
+			if err := syncFile(name); err != nil && !os.IsNotExist(err) && firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		})
+	}
+	for dir := range dirs {
+		// Not every platform can sync a directory; the file contents are what matter
+		syncFile(dir)
+	}
+	return firstErr
+}
+
+// syncFile commits a file or directory to stable storage. It is a variable so
+// tests can observe which files are synced.
+var syncFile = func(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// writeFileAtomic writes data to name through a temporary file in the same
+// directory, so readers never observe a partially written file
+func writeFileAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
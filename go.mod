@@ -1,3 +1,10 @@
 module github.com/hhftechnology/statiq
 
 go 1.19
+
+require (
+	github.com/andybalholm/brotli v1.1.1
+	golang.org/x/crypto v0.23.0
+	golang.org/x/image v0.21.0
+	golang.org/x/net v0.25.0
+)
@@ -0,0 +1,115 @@
+package statiq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultHealthCheckPath is where the health endpoint is served when HealthCheckPath is unset
+const defaultHealthCheckPath = "/health"
+
+// healthPollInterval is how often the health check file is checked for changes
+const healthPollInterval = time.Second
+
+// healthOK is served while no health check file exists
+var healthOK = []byte(`{"status":"ok"}`)
+
+// healthFile keeps the last read contents of the health check file so
+// requests never touch the disk
+type healthFile struct {
+	path    string
+	mu      sync.RWMutex
+	body    []byte
+	healthy bool
+	modTime time.Time
+	size    int64
+}
+
+func newHealthFile(ctx context.Context, path string) *healthFile {
+	hf := &healthFile{path: path, body: healthOK, healthy: true}
+	hf.reload()
+	go hf.watch(ctx)
+	return hf
+}
+
+// watch re-reads the file whenever its modification time or size changes
+func (hf *healthFile) watch(ctx context.Context) {
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hf.reload()
+		}
+	}
+}
+
+// reload reads the file if it appeared, disappeared or changed since the last read
+func (hf *healthFile) reload() {
+	info, err := os.Stat(hf.path)
+
+	var modTime time.Time
+	var size int64
+	if err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	hf.mu.RLock()
+	unchanged := modTime.Equal(hf.modTime) && size == hf.size
+	hf.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	body, healthy := healthOK, true
+	if err == nil {
+		data, err := os.ReadFile(hf.path)
+		if err != nil {
+			// Retry on the next poll
+			return
+		}
+		body, healthy = data, reportsHealthy(data)
+	}
+
+	hf.mu.Lock()
+	hf.body, hf.healthy, hf.modTime, hf.size = body, healthy, modTime, size
+	hf.mu.Unlock()
+}
+
+// reportsHealthy reports whether a health document has status "ok". Documents
+// that are not JSON objects or carry no status are treated as healthy.
+func reportsHealthy(data []byte) bool {
+	var doc struct {
+		Status *string `json:"status"`
+	}
+	if json.Unmarshal(data, &doc) != nil || doc.Status == nil {
+		return true
+	}
+	return *doc.Status == "ok"
+}
+
+// ServeHTTP answers with the cached document, using 503 when the document
+// reports anything but "ok" so load balancers take the server out of rotation
+func (hf *healthFile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hf.mu.RLock()
+	body, healthy := hf.body, hf.healthy
+	hf.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
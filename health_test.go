@@ -0,0 +1,57 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestHealthCheckFile(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healthFile := filepath.Join(t.TempDir(), "health.json")
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+	cfg.HealthCheckFile = healthFile
+
+	handler, err := statiq.New(ctx, next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing file reports ok
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/health", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `{"status":"ok"}` {
+		t.Errorf("Expected default ok document, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %q", ct)
+	}
+
+	// Writing a maintenance document takes the server out of rotation once the watcher sees it
+	maintenance := `{"status":"maintenance"}`
+	if err := os.WriteFile(healthFile, []byte(maintenance), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/health", nil))
+		if recorder.Body.String() == maintenance || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Body.String() != maintenance {
+		t.Errorf("Expected 503 with the maintenance document, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
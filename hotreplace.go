@@ -0,0 +1,50 @@
+package statiq
+
+import (
+	"net/http"
+	"path"
+	"sync"
+)
+
+// HotReplacer holds the request path → replacement file map of a handler. Go
+// users keep a reference, pass it as Config.HotReplacer and call Set to swap
+// the map at runtime; it keeps working whatever wraps the returned handler.
+// The zero value serves no replacements.
+type HotReplacer struct {
+	mu    sync.RWMutex
+	files map[string]string
+}
+
+// Set atomically swaps the request path → replacement file map. Passing nil
+// or an empty map disables all replacements. It is safe to call while
+// requests are being served.
+func (hr *HotReplacer) Set(files map[string]string) {
+	replacements := make(map[string]string, len(files))
+	for from, to := range files {
+		replacements[from] = path.Join("/", to)
+	}
+
+	hr.mu.Lock()
+	hr.files = replacements
+	hr.mu.Unlock()
+}
+
+// lookup returns the replacement file registered for upath
+func (hr *HotReplacer) lookup(upath string) (string, bool) {
+	hr.mu.RLock()
+	defer hr.mu.RUnlock()
+	replacement, ok := hr.files[upath]
+	return replacement, ok
+}
+
+// serveHotReplacement serves the replacement file registered for upath. It
+// returns false when there is none or the replacement does not exist.
+func (h *StatiqHandler) serveHotReplacement(w http.ResponseWriter, r *http.Request, upath string) bool {
+	replacement, ok := h.hotReplace.lookup(upath)
+	if !ok || !h.isRegularFile(replacement) {
+		return false
+	}
+
+	h.serveRootFile(w, r, replacement)
+	return true
+}
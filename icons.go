@@ -0,0 +1,51 @@
+package statiq
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// directoryIcon is shown for subdirectories when MIME icons are enabled
+const directoryIcon = "📁"
+
+// defaultMimeIcons maps MIME types or "type/*" categories to listing icons;
+// the "*" entry is used for everything else
+var defaultMimeIcons = map[string]string{
+	"image/*":         "🖼️",
+	"video/*":         "🎬",
+	"audio/*":         "🎵",
+	"text/*":          "📄",
+	"application/pdf": "📕",
+	"*":               "📎",
+}
+
+// newMimeIcons merges the configured overrides into the default icon mapping
+func newMimeIcons(overrides map[string]string) map[string]string {
+	icons := make(map[string]string, len(defaultMimeIcons)+len(overrides))
+	for mimeType, icon := range defaultMimeIcons {
+		icons[mimeType] = icon
+	}
+	for mimeType, icon := range overrides {
+		icons[mimeType] = icon
+	}
+	return icons
+}
+
+// mimeIcon picks the icon for a file name: an exact MIME type match first,
+// then its category, then the fallback
+func (h *StatiqHandler) mimeIcon(name string) string {
+	mimeType, _, _ := strings.Cut(h.typeByExtension(filepath.Ext(name)), ";")
+	mimeType = strings.TrimSpace(mimeType)
+
+	if mimeType != "" {
+		if icon, ok := h.mimeIcons[mimeType]; ok {
+			return icon
+		}
+		category, _, _ := strings.Cut(mimeType, "/")
+		if icon, ok := h.mimeIcons[category+"/*"]; ok {
+			return icon
+		}
+	}
+
+	return h.mimeIcons["*"]
+}
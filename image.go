@@ -0,0 +1,303 @@
+package statiq
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// defaultImageQuality is the JPEG quality used when ImageQuality is unset
+const defaultImageQuality = 85
+
+// defaultImageMaxDimension caps resize requests when ImageMaxWidth or ImageMaxHeight is unset
+const defaultImageMaxDimension = 2048
+
+// maxImageTransformSize bounds the image files decoded for transformation
+const maxImageTransformSize = 16 << 20
+
+// maxImagePixels bounds the decoded size of an image so a small, highly
+// compressed file cannot exhaust memory
+const maxImagePixels = 40 * 1000 * 1000
+
+// errImageTooLarge is returned for images over maxImagePixels
+var errImageTooLarge = errors.New("image too large to transform")
+
+// imageOptions describes the variant of an image to serve
+type imageOptions struct {
+	format   string // source codec, "jpeg" or "png"
+	quality  int
+	optimize bool // re-encode in the source format
+	webp     bool // the client accepts a WebP variant
+	width    int  // requested bounding box, 0 when unconstrained
+	height   int
+	gray     bool // convert to grayscale
+}
+
+// resizes reports whether the variant is a resized copy of the image
+func (o imageOptions) resizes() bool {
+	return o.width > 0 || o.height > 0
+}
+
+// modifies reports whether the variant's pixels differ from the original's
+func (o imageOptions) modifies() bool {
+	return o.resizes() || o.gray
+}
+
+// key identifies the variant in the transform cache
+func (o imageOptions) key() string {
+	key := "img:" + o.format + ":q" + strconv.Itoa(o.quality)
+	if o.optimize {
+		key += ":opt"
+	}
+	if o.webp {
+		key += ":webp"
+	}
+	if o.resizes() {
+		key += ":" + strconv.Itoa(o.width) + "x" + strconv.Itoa(o.height)
+	}
+	if o.gray {
+		key += ":gray"
+	}
+	return key
+}
+
+// imageFormat returns the codec name for an image content type
+func imageFormat(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return "jpeg"
+	case "image/png":
+		return "png"
+	}
+	return ""
+}
+
+// imageOptionsFor returns the variant to serve for the request, and false
+// when the file is served as it is on disk
+func (h *StatiqHandler) imageOptionsFor(w http.ResponseWriter, r *http.Request, d fs.FileInfo) (imageOptions, bool) {
+	query := r.URL.Query()
+	if !h.imageOptimize && !h.webpConvert && !h.imageResize && !h.grayscaleImages || d.Size() > maxImageTransformSize || query.Get("original") == "1" {
+		return imageOptions{}, false
+	}
+
+	format := imageFormat(w.Header().Get("Content-Type"))
+	if format == "" {
+		return imageOptions{}, false
+	}
+
+	opts := imageOptions{
+		format:   format,
+		quality:  h.imageQuality,
+		optimize: h.imageOptimize,
+		webp:     h.webpConvert && acceptsMediaType(r.Header.Get("Accept"), "image/webp"),
+		gray:     h.grayscaleImages && query.Get("gray") == "1",
+	}
+	if h.imageResize {
+		opts.width = boundedDimension(query.Get("w"), h.imageMaxWidth)
+		opts.height = boundedDimension(query.Get("h"), h.imageMaxHeight)
+	}
+	return opts, opts.optimize || opts.webp || opts.modifies()
+}
+
+// boundedDimension parses a requested width or height, capping it at limit.
+// Missing or invalid values yield 0.
+func boundedDimension(value string, limit int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
+
+// serveImage replaces content with the requested image variant
+func (h *StatiqHandler) serveImage(w http.ResponseWriter, r *http.Request, d fs.FileInfo, content io.Reader, opts imageOptions) (io.ReadSeeker, error) {
+	img, err := h.transformedImage(r, d, content, opts)
+	if err != nil {
+		return nil, err
+	}
+	h.varyCacheKeyHeaders(w)
+	if opts.webp {
+		w.Header().Set("Content-Type", http.DetectContentType(img))
+	}
+
+	return bytes.NewReader(img), nil
+}
+
+// transformedImage returns the smallest of the original and the requested
+// re-encodings, reusing the transform cache
+func (h *StatiqHandler) transformedImage(r *http.Request, d fs.FileInfo, content io.Reader, opts imageOptions) ([]byte, error) {
+	key := transformCacheKey(opts.key(), h.cacheKeyPath(r), d)
+	if cached, ok := h.transformCache.Get(key); ok {
+		return cached, nil
+	}
+
+	// Resized variants are also kept on disk since they are costly to produce.
+	// The disk cache is keyed by path alone: it is not bounded like the
+	// transform cache, so header values must not multiply its files.
+	cacheFile := ""
+	if opts.resizes() {
+		cacheFile = h.imageCacheFile(transformCacheKey(opts.key(), r.URL.Path, d))
+		if cached, err := os.ReadFile(cacheFile); err == nil {
+			h.transformCache.Add(key, cached)
+			return cached, nil
+		}
+	}
+
+	original, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	result := original
+	if img, err := decodeImage(original); err == nil {
+		if opts.resizes() {
+			img = resizeImage(img, opts.width, opts.height)
+		}
+		if opts.gray {
+			img = grayscaleImage(img)
+		}
+
+		encodings := encodeImage(img, opts)
+		if opts.modifies() && len(encodings) > 0 {
+			// The original is no candidate once the pixels change
+			result = encodings[0]
+		}
+		for _, encoded := range encodings {
+			if len(encoded) < len(result) {
+				result = encoded
+			}
+		}
+	}
+
+	if cacheFile != "" {
+		if err := h.writeCacheFile(cacheFile, result); err != nil {
+			log.Printf("statiq: caching resized image %s failed: %v", r.URL.Path, err)
+		}
+	}
+	h.transformCache.Add(key, result)
+	return result, nil
+}
+
+// imageCacheFile is the disk cache location for a transformed image
+func (h *StatiqHandler) imageCacheFile(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(h.imageCachePath, hex.EncodeToString(sum[:]))
+}
+
+// resizeImage scales img down to fit within width × height, keeping its
+// aspect ratio. A zero dimension is unconstrained and images are never
+// enlarged. The bilinear kernel widens with the scale factor, so every source
+// pixel contributes to the result.
+func resizeImage(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	scale := 1.0
+	if width > 0 && width < srcW {
+		scale = float64(width) / float64(srcW)
+	}
+	if height > 0 && float64(height) < float64(srcH)*scale {
+		scale = float64(height) / float64(srcH)
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	dstW, dstH := int(float64(srcW)*scale+0.5), int(float64(srcH)*scale+0.5)
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	draw.BiLinear.Scale(dst, dst.Rect, img, bounds, draw.Src, nil)
+	return dst
+}
+
+// decodeImage decodes an image, refusing ones over maxImagePixels
+func decodeImage(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// encodeImage returns the re-encodings of img requested by opts
+func encodeImage(img image.Image, opts imageOptions) [][]byte {
+	var encodings [][]byte
+
+	if opts.webp {
+		if encoded, err := encodeWebP(img); err == nil {
+			encodings = append(encodings, encoded)
+		}
+	}
+
+	// Modified images are always encoded in their source format as well
+	if opts.optimize || opts.modifies() {
+		var buf bytes.Buffer
+		var err error
+		if opts.format == "jpeg" {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.quality})
+		} else {
+			err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+		}
+		if err == nil {
+			encodings = append(encodings, buf.Bytes())
+		}
+	}
+
+	return encodings
+}
+
+// grayscaleImage converts img to grayscale. Opaque images become *image.Gray,
+// which the encoders store as single-channel images; transparency is kept
+// otherwise.
+func grayscaleImage(img image.Image) image.Image {
+	bounds := img.Bounds()
+	opaque := true
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+
+	if opaque {
+		gray := image.NewGray(bounds)
+		draw.Draw(gray, bounds, img, bounds.Min, draw.Src)
+		return gray
+	}
+
+	// Drawing into a Gray image would premultiply by alpha, so translucent
+	// images are converted to NRGBA and their color channels replaced by luma
+	nrgba := image.NewNRGBA(bounds)
+	draw.Draw(nrgba, bounds, img, bounds.Min, draw.Src)
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		pix := nrgba.Pix[i : i+4 : i+4]
+		g := color.GrayModel.Convert(color.RGBA{R: pix[0], G: pix[1], B: pix[2], A: 0xff}).(color.Gray).Y
+		pix[0], pix[1], pix[2] = g, g, g
+	}
+	return nrgba
+}
@@ -0,0 +1,298 @@
+package statiq_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	statiq "github.com/hhftechnology/statiq"
+	"golang.org/x/image/webp"
+)
+
+// newTestImage draws a gradient so encoders have something to compress
+func newTestImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// newNoiseImage fills an image with pseudo-random colors and fully opaque or
+// fully transparent pixels, which leaves predictors little to work with
+func newNoiseImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for i := 0; i < len(img.Pix); i += 4 {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = uint8(seed>>24), uint8(seed>>16), uint8(seed>>8)
+		img.Pix[i+3] = 0xff
+		if seed&0x70 == 0 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 0, 0, 0, 0
+		}
+	}
+	return img
+}
+
+// assertWebPPixels decodes a WebP body with golang.org/x/image/webp and
+// checks that every pixel matches the source image
+func assertWebPPixels(t *testing.T, name string, body []byte, want image.Image) {
+	t.Helper()
+
+	got, err := webp.Decode(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Expected %s to decode as WebP: %v", name, err)
+	}
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("Expected %s to decode at %v, got %v", name, want.Bounds().Size(), got.Bounds().Size())
+	}
+
+	bounds := want.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			wr, wg, wb, wa := want.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			gr, gg, gb, ga := got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y).RGBA()
+			if wr != gr || wg != gg || wb != gb || wa != ga {
+				t.Fatalf("Expected %s pixel (%d,%d) to be %v, got %v", name, x, y,
+					[4]uint32{wr, wg, wb, wa}, [4]uint32{gr, gg, gb, ga})
+			}
+		}
+	}
+}
+
+// encodeTestPNG encodes img without compression
+func encodeTestPNG(t *testing.T, img image.Image) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// encodeTestJPEG encodes img at the highest quality
+func encodeTestJPEG(t *testing.T, img image.Image) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestImageOptimize(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(64, 64)
+	rawPNG, rawJPEG := encodeTestPNG(t, img), encodeTestJPEG(t, img)
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"a.png": rawPNG, "a.jpg": rawJPEG})
+	cfg.ImageOptimize = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, raw := range map[string]string{"a.png": rawPNG, "a.jpg": rawJPEG} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/"+name, nil))
+		if recorder.Code != http.StatusOK || recorder.Body.Len() >= len(raw) {
+			t.Errorf("Expected %s to be served smaller than %d bytes, got %d bytes", name, len(raw), recorder.Body.Len())
+		}
+		if _, _, err := image.Decode(recorder.Body); err != nil {
+			t.Errorf("Expected %s to stay a valid image: %v", name, err)
+		}
+
+		recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/"+name+"?original=1", nil))
+		if recorder.Body.String() != raw {
+			t.Errorf("Expected ?original=1 to serve the raw %s", name)
+		}
+	}
+}
+
+func TestWebPConvert(t *testing.T) {
+	t.Parallel()
+
+	// A gradient compresses far better as lossless WebP than as uncompressed PNG
+	gradient := newTestImage(64, 64)
+	noise := newNoiseImage(67, 35)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"a.png":     encodeTestPNG(t, gradient),
+		"noise.png": encodeTestPNG(t, noise),
+	})
+	cfg.WebPConvert = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/a.png", nil)
+	req.Header.Set("Accept", "image/avif,image/webp,*/*")
+	recorder := serve(handler, req)
+	if ct := recorder.Header().Get("Content-Type"); ct != "image/webp" {
+		t.Fatalf("Expected image/webp, got %q", ct)
+	}
+	if body := recorder.Body.String(); !strings.HasPrefix(body, "RIFF") || body[8:16] != "WEBPVP8L" {
+		t.Errorf("Expected a lossless WebP body, got %q", body[:16])
+	}
+	assertWebPPixels(t, "a.png", recorder.Body.Bytes(), gradient)
+
+	// Odd sizes, partial tiles and transparency survive a reference decoder unchanged
+	req = newRequest(t, http.MethodGet, "http://localhost/noise.png", nil)
+	req.Header.Set("Accept", "image/webp")
+	recorder = serve(handler, req)
+	if ct := recorder.Header().Get("Content-Type"); ct != "image/webp" {
+		t.Fatalf("Expected image/webp for noise.png, got %q", ct)
+	}
+	assertWebPPixels(t, "noise.png", recorder.Body.Bytes(), noise)
+
+	req = newRequest(t, http.MethodGet, "http://localhost/a.png", nil)
+	req.Header.Set("Accept", "image/avif,image/webp,*/*")
+	recorder = serve(handler, req)
+	if recorder.Header().Get("Vary") != "Accept" {
+		t.Errorf("Expected Vary: Accept, got %q", recorder.Header().Get("Vary"))
+	}
+
+	// Clients without WebP support get the original format
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/a.png", nil))
+	if ct := recorder.Header().Get("Content-Type"); ct != "image/png" || recorder.Header().Get("Vary") != "Accept" {
+		t.Errorf("Expected image/png with Vary: Accept, got %q", ct)
+	}
+}
+
+func TestImageResize(t *testing.T) {
+	t.Parallel()
+
+	cacheDir := t.TempDir()
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"a.png": encodeTestPNG(t, newTestImage(80, 40))})
+	cfg.ImageResize = true
+	cfg.ImageMaxWidth = 30
+	cfg.ImageCachePath = cacheDir
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decode := func(target string) image.Config {
+		t.Helper()
+		recorder := serve(handler, newRequest(t, http.MethodGet, target, nil))
+		cfg, err := png.DecodeConfig(recorder.Body)
+		if err != nil {
+			t.Fatalf("Expected a PNG for %s: %v", target, err)
+		}
+		return cfg
+	}
+
+	// The aspect ratio is kept and the requested width is capped at imageMaxWidth
+	if got := decode("http://localhost/a.png?w=20"); got.Width != 20 || got.Height != 10 {
+		t.Errorf("Expected 20x10, got %dx%d", got.Width, got.Height)
+	}
+	if got := decode("http://localhost/a.png?w=1000&h=1000"); got.Width != 30 || got.Height != 15 {
+		t.Errorf("Expected the width capped at 30, got %dx%d", got.Width, got.Height)
+	}
+	if got := decode("http://localhost/a.png"); got.Width != 80 {
+		t.Errorf("Expected the original without a size, got %dx%d", got.Width, got.Height)
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Expected two resized variants cached on disk, got %d (%v)", len(entries), err)
+	}
+}
+
+func TestGrayscaleImages(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(16, 16)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"a.png": encodeTestPNG(t, img), "a.jpg": encodeTestJPEG(t, img)})
+	cfg.GrayscaleImages = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, format := range map[string]string{"a.png": "png", "a.jpg": "jpeg"} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/"+name+"?gray=1", nil))
+		got, gotFormat, err := image.Decode(recorder.Body)
+		if err != nil || gotFormat != format {
+			t.Fatalf("Expected %s to stay %s, got %q: %v", name, format, gotFormat, err)
+		}
+		r, g, b, _ := got.At(12, 3).RGBA()
+		if diff := int(r>>8) - int(b>>8); r != g || diff > 2 || diff < -2 {
+			t.Errorf("Expected a gray pixel in %s, got %d,%d,%d", name, r>>8, g>>8, b>>8)
+		}
+	}
+
+	// Without the query parameter the file is served untouched
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/a.png", nil))
+	if recorder.Body.String() != encodeTestPNG(t, img) {
+		t.Error("Expected the original image without ?gray=1")
+	}
+}
+
+func TestThumbnailDir(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"photos/a.jpg":     encodeTestJPEG(t, newTestImage(320, 160)),
+		"photos/notes.txt": "notes",
+	})
+	cfg.EnableDirectoryListing = true
+	cfg.ThumbnailDir = t.TempDir()
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listing := serve(handler, newRequest(t, http.MethodGet, "http://localhost/photos/", nil)).Body.String()
+	if !strings.Contains(listing, `<img class="thumb" src="/_thumbs/photos/a.jpg"`) {
+		t.Errorf("Expected a thumbnail for the image, got %q", listing)
+	}
+	if !strings.Contains(listing, `<span class="icon">📄</span> <a href="notes.txt">`) {
+		t.Errorf("Expected a placeholder icon for the text file, got %q", listing)
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/_thumbs/photos/a.jpg", nil))
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "image/jpeg" {
+			t.Fatalf("Expected a JPEG thumbnail, got %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+		}
+		thumb, err := jpeg.Decode(recorder.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := thumb.Bounds().Size(); size.X != 64 || size.Y != 32 {
+			t.Errorf("Expected a 64x32 thumbnail, got %v", size)
+		}
+	}
+
+	cached, err := os.ReadDir(cfg.ThumbnailDir)
+	if err != nil || len(cached) != 1 {
+		t.Errorf("Expected one cached thumbnail, got %d: %v", len(cached), err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/_thumbs/photos/notes.txt", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for the thumbnail of a non-image, got %d", recorder.Code)
+	}
+}
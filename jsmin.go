@@ -0,0 +1,240 @@
+package statiq
+
+import "bytes"
+
+// utf8BOM is skipped at the start of scripts
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// jsEOF marks the end of input in the JavaScript minifier
+const jsEOF = -1
+
+// jsRegexKeywords may be directly followed by a regular expression literal
+var jsRegexKeywords = map[string]bool{
+	"case": true, "delete": true, "do": true, "else": true, "in": true, "instanceof": true,
+	"new": true, "return": true, "throw": true, "typeof": true, "void": true, "yield": true,
+}
+
+// jsMinifier is a port of Douglas Crockford's JSMin. It removes comments
+// (including sourceMappingURL annotations, which no longer match the output)
+// and whitespace that is not needed to separate tokens. Identifiers are never
+// renamed, so the result is safe for any script JSMin accepts.
+type jsMinifier struct {
+	src       []byte
+	pos       int
+	lookahead int
+	out       []byte
+	a, b      int
+	word      []byte // identifier or keyword most recently written
+}
+
+// minifyJS minifies a complete script
+func minifyJS(src []byte) []byte {
+	m := &jsMinifier{src: src, lookahead: jsEOF, out: make([]byte, 0, len(src))}
+	m.run()
+	// Leading comments leave a newline behind
+	return bytes.TrimLeft(m.out, "\n")
+}
+
+func isJSAlphanum(c int) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' ||
+		c == '_' || c == '$' || c == '\\' || c > 126
+}
+
+// get returns the next byte, turning carriage returns into newlines and other
+// control characters into spaces
+func (m *jsMinifier) get() int {
+	c := m.lookahead
+	m.lookahead = jsEOF
+	if c == jsEOF {
+		if m.pos >= len(m.src) {
+			return jsEOF
+		}
+		c = int(m.src[m.pos])
+		m.pos++
+	}
+	if c >= ' ' || c == '\n' || c == jsEOF {
+		return c
+	}
+	if c == '\r' {
+		return '\n'
+	}
+	return ' '
+}
+
+func (m *jsMinifier) peek() int {
+	m.lookahead = m.get()
+	return m.lookahead
+}
+
+// next returns the next byte, replacing comments by a newline or a space
+func (m *jsMinifier) next() int {
+	c := m.get()
+	if c != '/' {
+		return c
+	}
+
+	switch m.peek() {
+	case '/':
+		for {
+			c = m.get()
+			if c == '\n' || c == jsEOF {
+				return c
+			}
+		}
+	case '*':
+		m.get()
+		for c != ' ' {
+			switch m.get() {
+			case '*':
+				if m.peek() == '/' {
+					m.get()
+					c = ' '
+				}
+			case jsEOF:
+				// Unterminated comment
+				return jsEOF
+			}
+		}
+	}
+	return c
+}
+
+func (m *jsMinifier) put(c int) {
+	if c == jsEOF {
+		return
+	}
+	m.out = append(m.out, byte(c))
+	if isJSAlphanum(c) {
+		m.word = append(m.word, byte(c))
+	} else {
+		m.word = m.word[:0]
+	}
+}
+
+// repeatsSign reports whether c is a '+' or '-' that would merge with the
+// same sign just written
+func (m *jsMinifier) repeatsSign(c int) bool {
+	return (c == '+' || c == '-') && len(m.out) > 0 && int(m.out[len(m.out)-1]) == c
+}
+
+// regexAllowed reports whether a '/' following a may start a regular expression
+func (m *jsMinifier) regexAllowed() bool {
+	switch m.a {
+	case '(', ',', '=', ':', '[', '!', '&', '|', '?', '+', '-', '~', '*', '/', '{', '}', ';', '\n':
+		return true
+	}
+	return isJSAlphanum(m.a) && jsRegexKeywords[string(m.word)]
+}
+
+// action implements JSMin's three moves: 1 outputs a, 2 drops a, 3 drops b.
+// Strings and regular expression literals are copied verbatim.
+func (m *jsMinifier) action(d int) {
+	if d <= 1 {
+		m.put(m.a)
+	}
+
+	if d <= 2 {
+		m.a = m.b
+		if m.a == '\'' || m.a == '"' || m.a == '`' {
+			for {
+				m.put(m.a)
+				m.a = m.get()
+				if m.a == m.b || m.a == jsEOF {
+					break
+				}
+				if m.a == '\\' {
+					m.put(m.a)
+					m.a = m.get()
+				}
+			}
+		}
+	}
+
+	m.b = m.next()
+	if m.b == '/' && m.regexAllowed() {
+		m.put(m.a)
+		if m.a == '/' || m.a == '*' {
+			m.put(' ')
+		}
+		m.put(m.b)
+		for {
+			m.a = m.get()
+			if m.a == '[' {
+				// Slashes inside a character class do not end the literal
+				for {
+					m.put(m.a)
+					m.a = m.get()
+					if m.a == ']' || m.a == jsEOF {
+						break
+					}
+					if m.a == '\\' {
+						m.put(m.a)
+						m.a = m.get()
+					}
+				}
+			} else if m.a == '/' || m.a == jsEOF {
+				break
+			} else if m.a == '\\' {
+				m.put(m.a)
+				m.a = m.get()
+			}
+			m.put(m.a)
+		}
+		m.b = m.next()
+	}
+}
+
+func (m *jsMinifier) run() {
+	if bytes.HasPrefix(m.src, utf8BOM) {
+		m.pos = len(utf8BOM)
+	}
+
+	m.a = '\n'
+	m.action(3)
+	for m.a != jsEOF {
+		switch m.a {
+		case ' ':
+			if isJSAlphanum(m.b) || m.repeatsSign(m.b) {
+				m.action(1)
+			} else {
+				m.action(2)
+			}
+		case '\n':
+			switch m.b {
+			case '{', '[', '(', '+', '-', '!', '~':
+				m.action(1)
+			case ' ':
+				m.action(3)
+			default:
+				if isJSAlphanum(m.b) {
+					m.action(1)
+				} else {
+					m.action(2)
+				}
+			}
+		default:
+			switch m.b {
+			case ' ':
+				// Keep "a + +b" and "a - -b" from turning into increments
+				if isJSAlphanum(m.a) || (m.a == '+' || m.a == '-') && m.peek() == m.a {
+					m.action(1)
+				} else {
+					m.action(3)
+				}
+			case '\n':
+				switch m.a {
+				case '}', ']', ')', '+', '-', '"', '\'', '`':
+					m.action(1)
+				default:
+					if isJSAlphanum(m.a) {
+						m.action(1)
+					} else {
+						m.action(3)
+					}
+				}
+			default:
+				m.action(1)
+			}
+		}
+	}
+}
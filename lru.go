@@ -0,0 +1,85 @@
+package statiq
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// lruCache is a goroutine-safe cache of byte slices bounded by their total
+// size. The least recently used entries are evicted first.
+type lruCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+// lruEntry is the value stored in the cache's list elements
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{maxBytes: maxBytes, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get returns the cached value for key and marks it as recently used
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+// Add stores value under key, evicting old entries to stay within the size
+// bound. Values larger than the whole cache are not stored.
+func (c *lruCache) Add(key string, value []byte) {
+	if c == nil || int64(len(value)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		c.size += int64(len(value)) - int64(len(entry.value))
+		entry.value = value
+		c.order.MoveToFront(elem)
+	} else {
+		c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+		c.size += int64(len(value))
+	}
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*lruEntry)
+		c.order.Remove(oldest)
+		delete(c.items, entry.key)
+		c.size -= int64(len(entry.value))
+	}
+}
+
+// stats returns the number of lookups that found an entry and that did not
+func (c *lruCache) stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.hits.Load(), c.misses.Load()
+}
@@ -0,0 +1,29 @@
+package statiq
+
+import "testing"
+
+func TestLRUCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	c := newLRUCache(10)
+	c.Add("a", []byte("aaaa"))
+	c.Add("b", []byte("bbbb"))
+
+	// Using a keeps it over b when c needs the room
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	c.Add("c", []byte("cccc"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to stay cached", key)
+		}
+	}
+	if c.size != 8 {
+		t.Errorf("Expected 8 cached bytes, got %d", c.size)
+	}
+}
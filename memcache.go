@@ -0,0 +1,64 @@
+package statiq
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// defaultMemCacheMaxSize bounds the memory cache when MemCacheMaxSize is unset
+	defaultMemCacheMaxSize = 64 << 20
+	// defaultMemCacheMaxFile is the size from which files bypass the memory cache
+	defaultMemCacheMaxFile = 512 << 10
+)
+
+// memCacheFS serves regular files smaller than maxFile from an LRU cache of
+// their contents, reading them from the wrapped file system on a miss.
+// Entries are keyed by absolute path, modification time and size, so every
+// Open validates the cached copy against the file's current metadata and
+// changed files are read again. The ETag is derived from that metadata too.
+type memCacheFS struct {
+	http.FileSystem
+	root    string
+	maxFile int64
+	cache   *lruCache
+	h       *StatiqHandler
+}
+
+func (m memCacheFS) Open(name string) (http.File, error) {
+	f, err := m.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := f.Stat()
+	if err != nil || !d.Mode().IsRegular() || d.Size() >= m.maxFile {
+		return f, nil
+	}
+
+	key := filepath.Join(m.root, filepath.FromSlash(path.Clean("/"+name))) + "\x00" +
+		strconv.FormatInt(d.ModTime().UnixNano(), 10) + "\x00" + strconv.FormatInt(d.Size(), 10)
+	data, ok := m.cache.Get(key)
+	if ok {
+		f.Close()
+	} else {
+		data, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		m.cache.Add(key, data)
+	}
+
+	e := &memEntry{data: data, info: &memFileInfo{
+		name:        d.Name(),
+		size:        int64(len(data)),
+		mode:        d.Mode(),
+		modTime:     d.ModTime(),
+		contentType: m.h.typeByExtension(filepath.Ext(d.Name())),
+	}}
+	return &memFile{Reader: bytes.NewReader(data), entry: e}, nil
+}
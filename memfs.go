@@ -0,0 +1,176 @@
+package statiq
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// memFileInfo describes a file loaded by ServeFromMemory or MemCache, along
+// with the ETag and content type computed at load time
+type memFileInfo struct {
+	name        string
+	size        int64
+	mode        fs.FileMode
+	modTime     time.Time
+	etag        string
+	contentType string
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+// memEntry is a file's content or a directory's sorted entries
+type memEntry struct {
+	info     *memFileInfo
+	data     []byte
+	children []fs.FileInfo
+}
+
+// errMemFSFull stops the startup walk once MemFSMaxSizeMB is reached
+var errMemFSFull = errors.New("memory file system size limit reached")
+
+// memFS is an http.FileSystem holding a snapshot of the root directory,
+// keyed by URL path. A snapshot cut short by the size limit holds files
+// only and leaves everything else to fallback.
+type memFS struct {
+	entries  map[string]*memEntry
+	fallback http.FileSystem
+}
+
+// loadMemFS reads every regular file below root into memory, up to maxSize
+// bytes when it is positive. Symbolic links to files are followed; links to
+// directories are skipped.
+func (h *StatiqHandler) loadMemFS(root string, maxSize int64) (*memFS, error) {
+	files := map[string]*memEntry{}
+	var total int64
+	err := filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		urlPath := path.Join("/", filepath.ToSlash(rel))
+
+		info, err := os.Stat(name)
+		if err != nil {
+			if entry.Type()&fs.ModeSymlink != 0 && os.IsNotExist(err) {
+				// Dangling links are skipped
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && entry.Type()&fs.ModeSymlink != 0 || !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink != 0 && h.symlinks != nil && !h.symlinks.allows(urlPath) {
+			return nil
+		}
+
+		e := &memEntry{info: &memFileInfo{
+			name:    info.Name(),
+			size:    info.Size(),
+			mode:    info.Mode(),
+			modTime: info.ModTime(),
+		}}
+		if urlPath == "/" {
+			e.info.name = "/"
+		}
+		if !info.IsDir() {
+			if maxSize > 0 && total+info.Size() > maxSize {
+				return errMemFSFull
+			}
+			if e.data, err = os.ReadFile(name); err != nil {
+				return err
+			}
+			e.info.size = int64(len(e.data))
+			e.info.etag = h.fileETag(urlPath, e.info)
+			total += e.info.size
+		}
+
+		files[urlPath] = e
+		if urlPath != "/" {
+			parent := files[path.Dir(urlPath)]
+			parent.children = append(parent.children, e.info)
+		}
+		return nil
+	})
+	if errors.Is(err, errMemFSFull) {
+		log.Printf("statiq: memFSMaxSizeMB reached after loading %d bytes; remaining files are served from disk", total)
+
+		// Listings of partially loaded directories would be incomplete
+		for urlPath, e := range files {
+			if e.info.IsDir() {
+				delete(files, urlPath)
+			}
+		}
+		return &memFS{entries: files, fallback: h.root}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range files {
+		sort.Slice(e.children, func(i, j int) bool { return e.children[i].Name() < e.children[j].Name() })
+	}
+	return &memFS{entries: files}, nil
+}
+
+// Open returns the in-memory file at name
+func (m *memFS) Open(name string) (http.File, error) {
+	e, ok := m.entries[path.Clean("/"+name)]
+	if !ok {
+		if m.fallback != nil {
+			return m.fallback.Open(name)
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFile{Reader: bytes.NewReader(e.data), entry: e}, nil
+}
+
+// memFile is an open handle on a memEntry
+type memFile struct {
+	*bytes.Reader
+	entry  *memEntry
+	offset int
+}
+
+func (f *memFile) Close() error { return nil }
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.entry.info, nil }
+
+// Readdir lists the directory like os.File.Readdir
+func (f *memFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.entry.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.entry.info.name, Err: fs.ErrInvalid}
+	}
+
+	rest := f.entry.children[f.offset:]
+	if count <= 0 {
+		f.offset += len(rest)
+		return append([]fs.FileInfo(nil), rest...), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.offset += count
+	return append([]fs.FileInfo(nil), rest[:count]...), nil
+}
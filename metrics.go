@@ -0,0 +1,246 @@
+package statiq
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyWindow is how many recent request durations the latency quantiles
+// are computed from
+const latencyWindow = 1024
+
+// latencyQuantiles are the quantiles reported for request durations
+var latencyQuantiles = []float64{0.5, 0.9, 0.99}
+
+// durationBuckets are the upper bounds of the response duration histogram, in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// maxMetricExtensions bounds the distinct extension labels, so requests for
+// made-up extensions cannot grow the metrics without limit
+const maxMetricExtensions = 64
+
+// labelEscaper escapes label values as the Prometheus text format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// requestKey labels the request counter
+type requestKey struct {
+	status    int
+	extension string
+}
+
+// metrics holds the counters exposed at PrometheusPath and MetricsPath. Each
+// handler has its own, so several instances never share or collide on
+// metric state.
+type metrics struct {
+	mu            sync.Mutex
+	requests      map[requestKey]uint64
+	extensions    map[string]bool
+	bytes         uint64
+	durations     [latencyWindow]float64
+	next          int
+	durationSum   float64
+	durationCount uint64
+	buckets       []uint64
+	openFiles     atomic.Int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:   map[requestKey]uint64{},
+		extensions: map[string]bool{},
+		buckets:    make([]uint64, len(durationBuckets)),
+	}
+}
+
+// metricExtension is the extension label for urlPath. Extensions with
+// characters outside [a-z0-9.] are client-made and share the "other" label.
+func metricExtension(urlPath string) string {
+	extension := strings.ToLower(path.Ext(urlPath))
+	for i := 0; i < len(extension); i++ {
+		c := extension[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '.' {
+			return "other"
+		}
+	}
+	return extension
+}
+
+// observe records a finished request
+func (m *metrics) observe(urlPath string, status int, bytes int64, duration time.Duration) {
+	seconds := duration.Seconds()
+	extension := metricExtension(urlPath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.extensions[extension] {
+		if len(m.extensions) < maxMetricExtensions {
+			m.extensions[extension] = true
+		} else {
+			extension = "other"
+		}
+	}
+	m.requests[requestKey{status, extension}]++
+	m.bytes += uint64(bytes)
+	m.durations[m.next] = seconds
+	m.next = (m.next + 1) % latencyWindow
+	m.durationSum += seconds
+	m.durationCount++
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+}
+
+// countingFS tracks how many files of the root are open
+type countingFS struct {
+	http.FileSystem
+	open *atomic.Int64
+}
+
+func (fs countingFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fs.open.Add(1)
+	return &countingFile{File: f, open: fs.open}, nil
+}
+
+// countingFile gives its slot back on the first Close
+type countingFile struct {
+	http.File
+	open   *atomic.Int64
+	closed atomic.Bool
+}
+
+func (f *countingFile) Close() error {
+	if f.closed.CompareAndSwap(false, true) {
+		f.open.Add(-1)
+	}
+	return f.File.Close()
+}
+
+// wrapMetrics records the status, size and duration of every request served by next
+func (h *StatiqHandler) wrapMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &logRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		h.metrics.observe(r.URL.Path, recorder.status, recorder.bytes, time.Since(start))
+	})
+}
+
+// serveMetrics writes the metrics in the Prometheus text exposition format
+func (h *StatiqHandler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m := h.metrics
+	m.mu.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].status != keys[j].status {
+			return keys[i].status < keys[j].status
+		}
+		return keys[i].extension < keys[j].extension
+	})
+	requests := make([]uint64, len(keys))
+	for i, key := range keys {
+		requests[i] = m.requests[key]
+	}
+	buckets := append([]uint64(nil), m.buckets...)
+	bytes, sum, count := m.bytes, m.durationSum, m.durationCount
+	recent := m.durations[:]
+	if count < latencyWindow {
+		recent = m.durations[:count]
+	}
+	recent = append([]float64(nil), recent...)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	fmt.Fprintln(out, "# HELP statiq_requests_total Requests served, by status code and file extension.")
+	fmt.Fprintln(out, "# TYPE statiq_requests_total counter")
+	for i, key := range keys {
+		fmt.Fprintf(out, "statiq_requests_total{code=\"%d\",extension=\"%s\"} %d\n", key.status, labelEscaper.Replace(key.extension), requests[i])
+	}
+
+	fmt.Fprintln(out, "# HELP statiq_response_bytes_total Response body bytes served.")
+	fmt.Fprintln(out, "# TYPE statiq_response_bytes_total counter")
+	fmt.Fprintf(out, "statiq_response_bytes_total %d\n", bytes)
+
+	fmt.Fprintf(out, "# HELP statiq_request_duration_seconds Request durations, with quantiles over the last %d requests.\n", latencyWindow)
+	fmt.Fprintln(out, "# TYPE statiq_request_duration_seconds summary")
+	sort.Float64s(recent)
+	for _, q := range latencyQuantiles {
+		value := "NaN"
+		if len(recent) > 0 {
+			value = fmt.Sprintf("%g", recent[int(q*float64(len(recent)-1))])
+		}
+		fmt.Fprintf(out, "statiq_request_duration_seconds{quantile=\"%g\"} %s\n", q, value)
+	}
+	fmt.Fprintf(out, "statiq_request_duration_seconds_sum %g\n", sum)
+	fmt.Fprintf(out, "statiq_request_duration_seconds_count %d\n", count)
+
+	fmt.Fprintln(out, "# HELP statiq_response_duration_seconds Response durations.")
+	fmt.Fprintln(out, "# TYPE statiq_response_duration_seconds histogram")
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(out, "statiq_response_duration_seconds_bucket{le=\"%g\"} %d\n", bound, buckets[i])
+	}
+	fmt.Fprintf(out, "statiq_response_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(out, "statiq_response_duration_seconds_sum %g\n", sum)
+	fmt.Fprintf(out, "statiq_response_duration_seconds_count %d\n", count)
+
+	fmt.Fprintln(out, "# HELP statiq_open_files Files of the root currently open.")
+	fmt.Fprintln(out, "# TYPE statiq_open_files gauge")
+	fmt.Fprintf(out, "statiq_open_files %d\n", m.openFiles.Load())
+
+	if h.backgroundCompressOnBoot {
+		fmt.Fprintln(out, "# HELP statiq_precompressed_files_total Sidecars written by backgroundCompressOnBoot.")
+		fmt.Fprintln(out, "# TYPE statiq_precompressed_files_total counter")
+		fmt.Fprintf(out, "statiq_precompressed_files_total %d\n", h.precompressedFiles.Load())
+		done := 0
+		if h.precompressDone.Load() {
+			done = 1
+		}
+		fmt.Fprintln(out, "# HELP statiq_precompress_done Whether backgroundCompressOnBoot finished walking the root.")
+		fmt.Fprintln(out, "# TYPE statiq_precompress_done gauge")
+		fmt.Fprintf(out, "statiq_precompress_done %d\n", done)
+	}
+
+	hits, misses := h.transformCache.stats()
+	fmt.Fprintln(out, "# HELP statiq_transform_cache_hits_total Transform cache lookups that found an entry.")
+	fmt.Fprintln(out, "# TYPE statiq_transform_cache_hits_total counter")
+	fmt.Fprintf(out, "statiq_transform_cache_hits_total %d\n", hits)
+	fmt.Fprintln(out, "# HELP statiq_transform_cache_misses_total Transform cache lookups that found nothing.")
+	fmt.Fprintln(out, "# TYPE statiq_transform_cache_misses_total counter")
+	fmt.Fprintf(out, "statiq_transform_cache_misses_total %d\n", misses)
+	fmt.Fprintln(out, "# HELP statiq_transform_cache_hit_ratio Share of transform cache lookups that found an entry.")
+	fmt.Fprintln(out, "# TYPE statiq_transform_cache_hit_ratio gauge")
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	fmt.Fprintf(out, "statiq_transform_cache_hit_ratio %g\n", ratio)
+}
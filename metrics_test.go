@@ -0,0 +1,192 @@
+package statiq_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestPrometheusPath(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello"})
+	cfg.PrometheusPath = "/_statiq/metrics"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.txt", nil))
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.txt", nil))
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/missing.txt", nil))
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/_statiq/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("Expected the Prometheus exposition, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE statiq_requests_total counter",
+		`statiq_requests_total{code="200",extension=".txt"} 2`,
+		`statiq_requests_total{code="404",extension=".txt"} 1`,
+		"# TYPE statiq_request_duration_seconds summary",
+		`statiq_request_duration_seconds{quantile="0.99"} `,
+		"statiq_request_duration_seconds_count 3",
+		"statiq_transform_cache_hits_total 0",
+		"statiq_transform_cache_hit_ratio 0",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %q in the metrics, got:\n%s", line, body)
+		}
+	}
+	if !strings.Contains(body, "statiq_response_bytes_total ") || strings.Contains(body, "statiq_response_bytes_total 0\n") {
+		t.Errorf("Expected served bytes to be counted, got:\n%s", body)
+	}
+
+	// The metrics endpoint is not exposed unless configured
+	cfg.PrometheusPath = ""
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/_statiq/metrics", nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without prometheusPath, got %d", recorder.Code)
+	}
+}
+
+func TestMetricsPath(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello", "style.css": "body{}"})
+	cfg.MetricsPath = "/metrics"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.txt", nil))
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/style.CSS", nil))
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/style.css", nil))
+	// Made-up extensions share a single label once the limit is reached
+	for i := 0; i < 100; i++ {
+		serve(handler, newRequest(t, http.MethodGet, fmt.Sprintf("http://localhost/file.x%d", i), nil))
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the metrics at /metrics, got %d", recorder.Code)
+	}
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`statiq_requests_total{code="200",extension=".txt"} 1`,
+		`statiq_requests_total{code="200",extension=".css"} 1`,
+		`statiq_requests_total{code="404",extension=".css"} 1`,
+		`statiq_requests_total{code="404",extension="other"} `,
+		"# TYPE statiq_response_duration_seconds histogram",
+		`statiq_response_duration_seconds_bucket{le="0.005"} `,
+		`statiq_response_duration_seconds_bucket{le="+Inf"} 103`,
+		"statiq_response_duration_seconds_count 103",
+		"# TYPE statiq_open_files gauge",
+		"statiq_open_files 0\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %q in the metrics, got:\n%s", line, body)
+		}
+	}
+	if labels := strings.Count(body, "statiq_requests_total{"); labels > 70 {
+		t.Errorf("Expected the extension labels to be bounded, got %d series", labels)
+	}
+
+	// Extensions with characters outside [a-z0-9.] share the "other" label,
+	// which keeps the exposition parseable
+	metricsCfg := statiq.CreateConfig()
+	metricsCfg.Root = cfg.Root
+	metricsCfg.MetricsPath = "/metrics"
+	handler, err = statiq.New(context.Background(), next(t), metricsCfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/a.%01", nil))
+	serve(handler, newRequest(t, http.MethodGet, `http://localhost/b.%22%5C%0A`, nil))
+	body = serve(handler, newRequest(t, http.MethodGet, "http://localhost/metrics", nil)).Body.String()
+	if !strings.Contains(body, `statiq_requests_total{code="404",extension="other"} 2`) {
+		t.Errorf("Expected client-made extensions under the other label, got:\n%s", body)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "statiq_requests_total{") && !strings.Contains(line, `extension=".`) && !strings.Contains(line, `extension="other"`) {
+			t.Errorf("Unexpected extension label in %q", line)
+		}
+	}
+
+	// The metrics are subject to the same access rules as the files
+	cfg.IPAllowList = []string{"10.0.0.0/8"}
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/metrics", nil)); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for metrics outside ipAllowList, got %d", recorder.Code)
+	}
+	req := newRequest(t, http.MethodGet, "http://localhost/metrics", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	if recorder := serve(handler, req); recorder.Code != http.StatusOK {
+		t.Errorf("Expected metrics for an allowed client, got %d", recorder.Code)
+	}
+}
+
+func TestStatsdAddress(t *testing.T) {
+	t.Parallel()
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello"})
+	cfg.StatsdAddress = server.LocalAddr().String()
+	cfg.StatsdPrefix = "web.static"
+
+	handler, err := statiq.New(ctx, next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.txt", nil))
+
+	buf := make([]byte, 1500)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Expected a StatsD datagram: %v", err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	if len(lines) != 4 ||
+		lines[0] != "web.static.requests.total:1|c" ||
+		!strings.HasPrefix(lines[1], "web.static.response_time:") || !strings.HasSuffix(lines[1], "|ms") ||
+		lines[2] != "web.static.status.200:1|c" ||
+		lines[3] != "web.static.bytes:5|c" {
+		t.Errorf("Unexpected StatsD datagram %q", buf[:n])
+	}
+
+	// Nobody listening is not a request error
+	server.Close()
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.txt", nil)); recorder.Code != http.StatusOK {
+		t.Errorf("Expected requests to succeed without a StatsD server, got %d", recorder.Code)
+	}
+}
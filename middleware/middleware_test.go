@@ -0,0 +1,49 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hhftechnology/statiq/middleware"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	output := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(output)
+
+	handler := middleware.LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/teapot", nil))
+
+	if recorder.Code != http.StatusTeapot {
+		t.Errorf("Expected status to pass through, got %d", recorder.Code)
+	}
+	if line := buf.String(); !strings.Contains(line, "GET /teapot 418 15B") {
+		t.Errorf("Expected access line, got %q", line)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	t.Parallel()
+
+	handler := middleware.RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 after panic, got %d", recorder.Code)
+	}
+}
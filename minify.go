@@ -0,0 +1,488 @@
+package statiq
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultMinifyHTMLThreshold is the largest HTML file minified when MinifyHTMLThreshold is unset
+const defaultMinifyHTMLThreshold = 1 << 20
+
+// rawTextElements keep their contents out of whitespace collapsing
+var rawTextElements = []string{"pre", "textarea", "script", "style"}
+
+// blockElements do not render the whitespace around them, so it can be dropped entirely
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true, "blockquote": true, "body": true,
+	"br": true, "dd": true, "div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "head": true, "header": true, "hr": true, "html": true, "li": true,
+	"link": true, "main": true, "meta": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"script": true, "section": true, "style": true, "table": true, "tbody": true, "td": true,
+	"tfoot": true, "th": true, "thead": true, "title": true, "tr": true, "ul": true, "!doctype": true,
+	"noscript": true, "option": true, "select": true, "template": true, "textarea": true,
+}
+
+// minifiesHTML reports whether the HTML response for d is minified before it is served
+func (h *StatiqHandler) minifiesHTML(w http.ResponseWriter, d fs.FileInfo) bool {
+	return h.minifyHTML && d.Size() <= h.minifyHTMLThreshold && isHTMLResponse(w)
+}
+
+// minifiedContent returns the minified file contents as a new seeker
+func minifiedContent(content io.Reader, minify func([]byte) []byte) (io.ReadSeeker, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(minify(data)), nil
+}
+
+// minifyHTML removes comments and collapses insignificant whitespace. Whitespace
+// next to block-level tags is dropped, elsewhere runs collapse to a single
+// space so inline layout is preserved. Inline <style> and <script> blocks are
+// trimmed line by line; <pre> and <textarea> are copied verbatim.
+func minifyHTML(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	lower := bytes.ToLower(src)
+
+	// pendingSpace is collapsed whitespace not yet written, dropped before block tags
+	pendingSpace := false
+	// afterBlock is set right after a block-level tag, where leading whitespace is dropped
+	afterBlock := true
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == '<' && bytes.HasPrefix(src[i:], []byte("<!--")):
+			end := bytes.Index(src[i+4:], []byte("-->"))
+			if end < 0 {
+				return append(out, src[i:]...)
+			}
+			end += i + 4 + 3
+			// Conditional comments carry markup for legacy browsers
+			if bytes.HasPrefix(src[i:], []byte("<!--[if")) {
+				out = appendSpace(out, pendingSpace)
+				out = append(out, src[i:end]...)
+				pendingSpace = false
+			}
+			i = end
+
+		case c == '<' && i+1 < len(src) && isTagStart(src[i+1]):
+			end := tagEnd(src, i)
+			if end < 0 {
+				out = appendSpace(out, pendingSpace)
+				return append(out, src[i:]...)
+			}
+
+			start := i
+			name := tagName(lower[i+1 : end])
+			block := blockElements[name]
+			if !block {
+				out = appendSpace(out, pendingSpace)
+			}
+			pendingSpace = false
+			out = append(out, src[i:end]...)
+			afterBlock = block
+			i = end
+
+			// Copy raw text elements up to their closing tag
+			if raw := rawTextElement(src[start:end], name); raw != "" {
+				closeTag := []byte("</" + raw)
+				stop := bytes.Index(lower[i:], closeTag)
+				if stop < 0 {
+					stop = len(src) - i
+				}
+				body := src[i : i+stop]
+				if raw == "script" || raw == "style" {
+					body = trimLines(body, raw == "script")
+				}
+				out = append(out, body...)
+				i += stop
+			}
+
+		case isHTMLSpace(c):
+			for i < len(src) && isHTMLSpace(src[i]) {
+				i++
+			}
+			if !afterBlock {
+				pendingSpace = true
+			}
+
+		default:
+			out = appendSpace(out, pendingSpace)
+			pendingSpace = false
+			afterBlock = false
+			out = append(out, c)
+			i++
+		}
+	}
+
+	return appendSpace(out, pendingSpace)
+}
+
+// tagEnd returns the offset just past the tag starting at start, skipping '>'
+// inside quoted attribute values, or -1 when the tag is not terminated
+func tagEnd(src []byte, start int) int {
+	var quote byte
+	for i := start + 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// rawTextElement returns the element name when the opening tag just copied
+// starts a raw text element
+func rawTextElement(tag []byte, name string) string {
+	if bytes.HasPrefix(tag, []byte("</")) || bytes.HasSuffix(tag, []byte("/>")) {
+		return ""
+	}
+	for _, raw := range rawTextElements {
+		if name == raw {
+			return raw
+		}
+	}
+	return ""
+}
+
+// trimLines strips indentation and blank lines from a style or script block.
+// Scripts containing template literals are left untouched since their
+// whitespace may be significant.
+func trimLines(body []byte, isScript bool) []byte {
+	if isScript && bytes.IndexByte(body, '`') >= 0 {
+		return body
+	}
+
+	var out []byte
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if len(out) > 0 {
+			out = append(out, '\n')
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// tagName returns the lowercase element name of a tag such as "<div class=x>" or "</p>"
+func tagName(tag []byte) string {
+	tag = bytes.TrimPrefix(tag, []byte("/"))
+	end := 0
+	for end < len(tag) && !isHTMLSpace(tag[end]) && tag[end] != '>' && tag[end] != '/' {
+		end++
+	}
+	return string(tag[:end])
+}
+
+func isTagStart(c byte) bool {
+	return c == '/' || c == '!' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func appendSpace(out []byte, space bool) []byte {
+	if space {
+		return append(out, ' ')
+	}
+	return out
+}
+
+// cssTightChars never need surrounding whitespace in CSS. Whitespace after ':'
+// is dropped as well, but not before it since "a :hover" is a descendant selector.
+const cssTightChars = "{};,>"
+
+// cssMinifier removes comments and insignificant whitespace from a CSS stream.
+// It keeps its state between writes so a stylesheet can be minified in
+// arbitrarily sized chunks.
+type cssMinifier struct {
+	quote     byte    // open string delimiter, 0 outside strings
+	url       bool    // inside an unquoted url(...), copied verbatim
+	tail      [3]byte // last three bytes written, to recognise "url("
+	escaped   bool    // previous byte in the string was a backslash
+	comment   bool    // inside /* ... */
+	opening   bool    // the comment has just been opened
+	keep      bool    // the current comment is a /*! license comment
+	slash     bool    // held back '/' that may start a comment
+	star      bool    // '*' inside a comment that may end it
+	space     bool    // collapsed whitespace not yet written
+	semicolon bool    // held back ';' that is dropped before '}'
+	last      byte    // last byte written
+}
+
+// minify appends the minified form of p to out
+func (m *cssMinifier) minify(out, p []byte) []byte {
+	for _, c := range p {
+		switch {
+		case m.comment:
+			if m.opening {
+				m.opening = false
+				if c == '!' {
+					m.keep = true
+					out = append(m.emit(out, '/'), '*', '!')
+					continue
+				}
+			}
+			if m.keep {
+				out = append(out, c)
+			}
+			if m.star && c == '/' {
+				if m.keep {
+					// Nothing after a kept comment needs separating from it
+					m.last = 0
+				} else {
+					// A dropped comment still separates the tokens around it
+					m.space = true
+				}
+				m.comment, m.keep = false, false
+			}
+			m.star = c == '*'
+
+		case m.url:
+			out = append(out, c)
+			m.last = c
+			m.url = c != ')'
+
+		case m.quote != 0:
+			out = append(out, c)
+			switch {
+			case m.escaped:
+				m.escaped = false
+			case c == '\\':
+				m.escaped = true
+			case c == m.quote:
+				m.quote = 0
+			}
+			m.last = c
+
+		case m.slash:
+			m.slash = false
+			if c == '*' {
+				m.comment, m.opening, m.star = true, true, false
+				continue
+			}
+			out = m.emit(out, '/')
+			out = m.token(out, c)
+
+		default:
+			out = m.token(out, c)
+		}
+	}
+	return out
+}
+
+// token handles a byte outside strings and comments
+func (m *cssMinifier) token(out []byte, c byte) []byte {
+	switch {
+	case c == '/':
+		m.slash = true
+	case isHTMLSpace(c):
+		m.space = true
+	case c == ';':
+		m.space = false
+		m.semicolon = true
+	default:
+		isURL := c == '(' && strings.EqualFold(string(m.tail[:]), "url")
+		out = m.emit(out, c)
+		if c == '"' || c == '\'' {
+			m.quote = c
+		}
+		m.url = isURL
+	}
+	return out
+}
+
+// emit writes c, first flushing any held-back semicolon and space
+func (m *cssMinifier) emit(out []byte, c byte) []byte {
+	if m.semicolon {
+		m.semicolon = false
+		if c != '}' {
+			out = append(out, ';')
+			m.last = ';'
+		}
+	}
+	if m.space {
+		m.space = false
+		if m.last != 0 && m.last != ':' && !strings.ContainsRune(cssTightChars, rune(m.last)) && !strings.ContainsRune(cssTightChars, rune(c)) {
+			out = append(out, ' ')
+		}
+	}
+	m.last = c
+	m.tail = [3]byte{m.tail[1], m.tail[2], c}
+	return append(out, c)
+}
+
+// flush returns whatever is still held back at the end of the stream
+func (m *cssMinifier) flush(out []byte) []byte {
+	if m.slash {
+		m.slash = false
+		out = m.emit(out, '/')
+	}
+	if m.semicolon {
+		m.semicolon = false
+		out = append(out, ';')
+	}
+	return out
+}
+
+// minifyCSS minifies a complete stylesheet
+func minifyCSS(src []byte) []byte {
+	var m cssMinifier
+	return m.flush(m.minify(make([]byte, 0, len(src)), src))
+}
+
+// minifyWriter streams a response body through a minifier. The body length is
+// unknown in advance, so Content-Length is dropped and the response is chunked.
+type minifyWriter struct {
+	http.ResponseWriter
+	minifier    *cssMinifier
+	buf         []byte
+	active      bool
+	wroteHeader bool
+}
+
+func newCSSMinifyWriter(w http.ResponseWriter) *minifyWriter {
+	return &minifyWriter{ResponseWriter: w, minifier: &cssMinifier{}}
+}
+
+func (mw *minifyWriter) WriteHeader(code int) {
+	if mw.wroteHeader {
+		return
+	}
+	mw.wroteHeader = true
+	mw.active = code == http.StatusOK
+	if mw.active {
+		mw.Header().Del("Content-Length")
+	}
+	mw.ResponseWriter.WriteHeader(code)
+}
+
+func (mw *minifyWriter) Write(p []byte) (int, error) {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+	if !mw.active {
+		return mw.ResponseWriter.Write(p)
+	}
+
+	mw.buf = mw.minifier.minify(mw.buf[:0], p)
+	if _, err := mw.ResponseWriter.Write(mw.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the bytes the minifier held back at the end of the stream
+func (mw *minifyWriter) Close() error {
+	if !mw.active {
+		return nil
+	}
+	_, err := mw.ResponseWriter.Write(mw.minifier.flush(nil))
+	return err
+}
+
+// minifiesCSS reports whether the stylesheet at urlPath is streamed through the CSS minifier
+func (h *StatiqHandler) minifiesCSS(w http.ResponseWriter, urlPath string) bool {
+	return h.minifyCSS && strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") && !h.skipsMinify(urlPath)
+}
+
+// skipsMinify reports whether urlPath matches one of the MinifySkipPattern
+// globs. Patterns without a slash match the file name only ("*.min.css").
+func (h *StatiqHandler) skipsMinify(urlPath string) bool {
+	for _, pattern := range h.minifySkip {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(urlPath)); ok {
+				return true
+			}
+			continue
+		}
+		if matchPathPattern(pattern, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxMinifyJSSize bounds the scripts read into memory for minification
+const maxMinifyJSSize = 4 << 20
+
+// minifiesJS reports whether the script at urlPath is served minified
+func (h *StatiqHandler) minifiesJS(w http.ResponseWriter, urlPath string, d fs.FileInfo) bool {
+	if !h.minifyJS || d.Size() > maxMinifyJSSize || h.skipsMinify(urlPath) {
+		return false
+	}
+	contentType := w.Header().Get("Content-Type")
+	return strings.HasPrefix(contentType, "text/javascript") || strings.HasPrefix(contentType, "application/javascript")
+}
+
+// minifiedJS returns the minified script, reusing the transform cache entry
+// for the same path and modification time
+func (h *StatiqHandler) minifiedJS(r *http.Request, d fs.FileInfo, content io.Reader) (io.ReadSeeker, error) {
+	key := transformCacheKey("js", h.cacheKeyPath(r), d)
+	if minified, ok := h.transformCache.Get(key); ok {
+		return bytes.NewReader(minified), nil
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	minified := minifyJS(data)
+	h.transformCache.Add(key, minified)
+
+	return bytes.NewReader(minified), nil
+}
+
+// cacheKeyPath is the request path followed by the CacheKeyIncludeHeaders
+// values, so responses varying on them are cached separately
+func (h *StatiqHandler) cacheKeyPath(r *http.Request) string {
+	if len(h.cacheKeyHeaders) == 0 {
+		return r.URL.Path
+	}
+
+	var b strings.Builder
+	b.WriteString(r.URL.Path)
+	for i, name := range h.cacheKeyHeaders {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(name))
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(strings.Join(r.Header.Values(name), ",")))
+	}
+	return b.String()
+}
+
+// varyCacheKeyHeaders lists the CacheKeyIncludeHeaders in Vary, so shared
+// caches keep the variants apart as the transform cache does
+func (h *StatiqHandler) varyCacheKeyHeaders(w http.ResponseWriter) {
+	for _, name := range h.cacheKeyHeaders {
+		addVary(w.Header(), name)
+	}
+}
+
+// transformCacheKey identifies a transformed variant of a file version
+func transformCacheKey(kind, urlPath string, d fs.FileInfo) string {
+	return kind + ":" + urlPath + ":" + strconv.FormatInt(d.Size(), 10) + ":" + strconv.FormatInt(d.ModTime().UnixNano(), 10)
+}
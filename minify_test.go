@@ -0,0 +1,178 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	t.Parallel()
+
+	page := `<!DOCTYPE html>
+<html>
+  <head>
+    <!-- build 42 -->
+    <title>Home</title>
+    <style>
+      body { margin: 0; }
+    </style>
+  </head>
+  <body>
+    <p>Hello   <b>big</b>
+       <i>world</i></p>
+    <pre>  keep
+    this  </pre>
+    <a title="a > b" href="/">link</a>
+  </body>
+</html>
+`
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": page, "large.html": page})
+	cfg.MinifyHTML = true
+	cfg.MinifyHTMLThreshold = int64(len(page))
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<!DOCTYPE html><html><head><title>Home</title><style>body { margin: 0; }</style></head><body><p>Hello <b>big</b> <i>world</i></p><pre>  keep
+    this  </pre><a title="a > b" href="/">link</a></body></html>`
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil))
+	if recorder.Body.String() != expected {
+		t.Errorf("Unexpected minified HTML:\n%s", recorder.Body.String())
+	}
+	if recorder.Header().Get("Content-Length") != strconv.Itoa(len(expected)) {
+		t.Errorf("Expected Content-Length of the minified body, got %q", recorder.Header().Get("Content-Length"))
+	}
+
+	// Files over the threshold are served as-is
+	cfg.MinifyHTMLThreshold = int64(len(page)) - 1
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/large.html", nil))
+	if recorder.Body.String() != page {
+		t.Error("Expected HTML over the threshold to be served unchanged")
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	t.Parallel()
+
+	css := `/* theme */
+/*! license: MIT */
+body {
+    margin : 0;
+    font-family: "Open  Sans", sans-serif;
+}
+
+a:hover, a > b {
+    width: calc(100% - 2px);
+    background: url(/a/*b.png);
+}
+`
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"site.css": css, "vendor.min.css": css})
+	cfg.MinifyCSS = true
+	cfg.MinifySkipPattern = []string{"*.min.css"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/site.css", nil))
+	expected := `/*! license: MIT */body{margin :0;font-family:"Open  Sans",sans-serif}a:hover,a>b{width:calc(100% - 2px);background:url(/a/*b.png)}`
+	if recorder.Body.String() != expected {
+		t.Errorf("Unexpected minified CSS:\n%s", recorder.Body.String())
+	}
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Error("Expected no Content-Length on a minified stream")
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/vendor.min.css", nil))
+	if recorder.Body.String() != css {
+		t.Error("Expected files matching minifySkipPattern to be served unchanged")
+	}
+}
+
+func TestMinifyJS(t *testing.T) {
+	t.Parallel()
+
+	script := `// greeting helpers
+function greet(name) {
+    /* build the message */
+    var message = "Hello,  " + name + '!';
+    var n = a + +b - -c;
+    if (/\/\s+/.test(message)) { return message.replace(/ +/g, " "); }
+    return ` + "`line one\n    line two`" + `;
+}
+//# sourceMappingURL=app.js.map
+`
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"app.js": script})
+	cfg.MinifyJS = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "function greet(name){var message=\"Hello,  \"+name+'!';var n=a+ +b- -c;if(/\\/\\s+/.test(message)){return message.replace(/ +/g,\" \");}\n" +
+		"return`line one\n    line two`;}"
+
+	for i := 0; i < 2; i++ {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil))
+		if recorder.Body.String() != expected {
+			t.Errorf("Unexpected minified JS:\n%s", recorder.Body.String())
+		}
+		if recorder.Header().Get("Content-Length") != strconv.Itoa(len(expected)) {
+			t.Errorf("Expected Content-Length of the minified script, got %q", recorder.Header().Get("Content-Length"))
+		}
+	}
+}
+
+func TestCacheKeyIncludeHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"app.js": "var  answer = 42 ;\n"})
+	cfg.MinifyJS = true
+	cfg.CacheKeyIncludeHeaders = []string{"accept-language"}
+	cfg.PrometheusPath = "/_statiq/metrics"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, language := range []string{"en", "fr", "en", "fr", ""} {
+		req := newRequest(t, http.MethodGet, "http://localhost/app.js", nil)
+		if language != "" {
+			req.Header.Set("Accept-Language", language)
+		}
+		recorder := serve(handler, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %q, got %d", language, recorder.Code)
+		}
+		if vary := recorder.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ", "), "Accept-Language") {
+			t.Errorf("Expected Accept-Language in Vary for %q, got %v", language, vary)
+		}
+	}
+
+	// en, fr and no header each get an entry, the repeated languages hit theirs
+	body := serve(handler, newRequest(t, http.MethodGet, "http://localhost/_statiq/metrics", nil)).Body.String()
+	for _, line := range []string{"statiq_transform_cache_misses_total 3\n", "statiq_transform_cache_hits_total 2\n"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %q in the metrics, got:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package statiq
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// forwardedClientCertHeader carries client certificates forwarded by Traefik's passTLSClientCert middleware
+const forwardedClientCertHeader = "X-Forwarded-Tls-Client-Cert"
+
+// loadClientCAs reads the PEM bundle used to verify client certificates
+func loadClientCAs(caFile string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", caFile)
+	}
+
+	return pool, nil
+}
+
+// peerCertificates returns the client certificates of the request, taken from
+// the TLS connection or, when trusted, from the header set by Traefik's
+// passTLSClientCert middleware. The header carries no proof of key possession,
+// so it must only be trusted when that middleware runs in front of Statiq.
+func (h *StatiqHandler) peerCertificates(r *http.Request) []*x509.Certificate {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates
+	}
+	if !h.trustForwardedCert {
+		return nil
+	}
+
+	header := r.Header.Get(forwardedClientCertHeader)
+	if header == "" {
+		return nil
+	}
+
+	var certs []*x509.Certificate
+	for _, encoded := range strings.Split(header, ",") {
+		unescaped, err := url.QueryUnescape(strings.TrimSpace(encoded))
+		if err != nil {
+			continue
+		}
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(unescaped), ""))
+		if err != nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs
+}
+
+// hasValidClientCert reports whether at least one presented certificate chains to the client CA
+func (h *StatiqHandler) hasValidClientCert(r *http.Request) bool {
+	certs := h.peerCertificates(r)
+	if len(certs) == 0 {
+		return false
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	for _, cert := range certs {
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         h.clientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err == nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package statiq_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+// newTestCert issues a certificate for name, self-signed when parent is nil
+func newTestCert(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestTLSClientCert(t *testing.T) {
+	t.Parallel()
+
+	ca, caKey := newTestCert(t, "Test CA", true, nil, nil)
+	client, _ := newTestCert(t, "client", false, ca, caKey)
+	otherCA, otherKey := newTestCert(t, "Other CA", true, nil, nil)
+	stranger, _ := newTestCert(t, "stranger", false, otherCA, otherKey)
+
+	tempDir := newTestRoot(t, map[string]string{"secret.txt": "classified"})
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.TLSClientCert = true
+	cfg.TLSClientCACert = caFile
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No certificate at all
+	recorder := serve(handler, newRequest(t, http.MethodGet, "https://localhost/secret.txt", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without client certificate, got %d", recorder.Code)
+	}
+
+	// Certificate from an untrusted CA
+	req := newRequest(t, http.MethodGet, "https://localhost/secret.txt", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{stranger}}
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for untrusted certificate, got %d", recorder.Code)
+	}
+
+	// Certificate presented on the TLS connection
+	req = newRequest(t, http.MethodGet, "https://localhost/secret.txt", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "classified" {
+		t.Errorf("Expected 200 with valid certificate, got %d", recorder.Code)
+	}
+
+	// Forwarded certificates are ignored unless explicitly trusted
+	forwarded := url.QueryEscape(base64.StdEncoding.EncodeToString(client.Raw))
+	req = newRequest(t, http.MethodGet, "http://localhost/secret.txt", nil)
+	req.Header.Set("X-Forwarded-Tls-Client-Cert", forwarded)
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for untrusted forwarded certificate, got %d", recorder.Code)
+	}
+
+	// Certificate forwarded by Traefik's passTLSClientCert middleware
+	cfg.TrustForwardedClientCert = true
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = newRequest(t, http.MethodGet, "http://localhost/secret.txt", nil)
+	req.Header.Set("X-Forwarded-Tls-Client-Cert", forwarded)
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 with forwarded certificate, got %d", recorder.Code)
+	}
+
+	cfg.TLSClientCACert = ""
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected error for tlsClientCert without tlsClientCACert")
+	}
+}
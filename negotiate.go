@@ -0,0 +1,223 @@
+package statiq
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptsMediaType reports whether an Accept header explicitly lists the
+// media type with a non-zero quality value. Accept-Encoding values share the
+// syntax, so it also checks content codings.
+func acceptsMediaType(accept, mediaType string) bool {
+	return acceptQuality(accept, mediaType) > 0
+}
+
+// acceptQuality returns the quality value an Accept or Accept-Encoding header
+// gives to an explicitly listed media type or coding, and 0 when it is absent
+func acceptQuality(accept, mediaType string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mediaType) {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					return q
+				}
+			}
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// serveJSONVariant serves upath+".json" for extension-less requests that
+// accept application/json. It returns false when no variant applies.
+func (h *StatiqHandler) serveJSONVariant(w http.ResponseWriter, r *http.Request, upath string) bool {
+	if !h.negotiateJSON || strings.HasSuffix(upath, "/") || strings.Contains(upath[strings.LastIndex(upath, "/")+1:], ".") {
+		return false
+	}
+
+	// The response for this URL depends on the Accept header
+	w.Header().Add("Vary", "Accept")
+
+	if !acceptsMediaType(r.Header.Get("Accept"), "application/json") {
+		return false
+	}
+
+	variant := upath + ".json"
+	if !h.isRegularFile(variant) {
+		return false
+	}
+
+	h.serveRootFile(w, r, variant)
+	return true
+}
+
+// acceptedMediaTypes lists the media ranges of an Accept header from the most
+// to the least preferred: by quality, then exact types before type/* before
+// */*, then in header order. Ranges with a zero quality are left out and a
+// missing header accepts */*.
+func acceptedMediaTypes(accept string) []string {
+	if strings.TrimSpace(accept) == "" {
+		return []string{"*/*"}
+	}
+
+	type mediaRange struct {
+		name        string
+		quality     float64
+		specificity int
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		name := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+		if name == "" {
+			continue
+		}
+		q := acceptQuality(part, name)
+		if q <= 0 {
+			continue
+		}
+		specificity := 2
+		if name == "*/*" {
+			specificity = 0
+		} else if strings.HasSuffix(name, "/*") {
+			specificity = 1
+		}
+		ranges = append(ranges, mediaRange{name, q, specificity})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].quality != ranges[j].quality {
+			return ranges[i].quality > ranges[j].quality
+		}
+		return ranges[i].specificity > ranges[j].specificity
+	})
+
+	names := make([]string, len(ranges))
+	for i, r := range ranges {
+		names[i] = r.name
+	}
+	return names
+}
+
+// serveAlternateFile serves the first existing upath+extension variant for
+// the most preferred media range of the Accept header that AlternateExtensions
+// lists. Only extension-less paths get variants. It returns false when none
+// exists.
+func (h *StatiqHandler) serveAlternateFile(w http.ResponseWriter, r *http.Request, upath string) bool {
+	if h.alternateExtensions == nil || strings.HasSuffix(upath, "/") || strings.Contains(upath[strings.LastIndex(upath, "/")+1:], ".") {
+		return false
+	}
+
+	// The response for this URL depends on the Accept header
+	addVary(w.Header(), "Accept")
+
+	for _, mediaType := range acceptedMediaTypes(r.Header.Get("Accept")) {
+		for _, ext := range h.alternateExtensions[mediaType] {
+			variant := upath + ext
+			if !h.isRegularFile(variant) {
+				continue
+			}
+			w.Header().Set("Content-Location", variant)
+			h.serveRootFile(w, r, variant)
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedLanguages lists the language tags of an Accept-Language header,
+// lower-cased, from the most to the least preferred. A tag with a region is
+// followed by its primary language, so fr-CA falls back to fr. The * range,
+// tags with a zero quality and malformed tags are left out.
+func acceptedLanguages(acceptLanguage string) []string {
+	type languageRange struct {
+		tag     string
+		quality float64
+	}
+	var ranges []languageRange
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+		if !isLanguageTag(tag) {
+			continue
+		}
+		if q := acceptQuality(part, tag); q > 0 {
+			ranges = append(ranges, languageRange{tag, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	seen := map[string]bool{}
+	var tags []string
+	for _, r := range ranges {
+		primary, _, _ := strings.Cut(r.tag, "-")
+		for _, tag := range []string{r.tag, primary} {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// isLanguageTag reports whether tag is made of letters, digits and hyphens,
+// so it is safe to use in a file name
+func isLanguageTag(tag string) bool {
+	if tag == "" || tag[0] == '-' {
+		return false
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// serveLanguageVariant serves upath+"."+language+".html" for the most
+// preferred language of the Accept-Language header that has one, falling
+// back to upath+".html". Only extension-less paths get variants. It returns
+// false when none exists.
+func (h *StatiqHandler) serveLanguageVariant(w http.ResponseWriter, r *http.Request, upath string) bool {
+	if !h.negotiateLanguage || strings.HasSuffix(upath, "/") || strings.Contains(upath[strings.LastIndex(upath, "/")+1:], ".") {
+		return false
+	}
+
+	// The response for this URL depends on the Accept-Language header
+	addVary(w.Header(), "Accept-Language")
+
+	for _, tag := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		variant := upath + "." + tag + ".html"
+		if !h.isRegularFile(variant) {
+			continue
+		}
+		w.Header().Set("Content-Language", tag)
+		h.serveRootFile(w, r, variant)
+		return true
+	}
+
+	if variant := upath + ".html"; h.isRegularFile(variant) {
+		h.serveRootFile(w, r, variant)
+		return true
+	}
+	return false
+}
+
+// addVary adds name to the Vary header unless it is already listed
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
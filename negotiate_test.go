@@ -0,0 +1,174 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestContentNegotiationJSON(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"data.json":        `{"ok":true}`,
+		"data/index.html":  "<html>Data</html>",
+		"report/index.htm": "<html>Report</html>",
+	})
+	cfg.ContentNegotiationJSON = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/data", nil)
+	req.Header.Set("Accept", "application/json, text/plain;q=0.5")
+	recorder := serve(handler, req)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `{"ok":true}` {
+		t.Errorf("Expected JSON variant, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected Content-Type: application/json, got %s", contentType)
+	}
+	if vary := recorder.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("Expected Vary: Accept, got %s", vary)
+	}
+
+	// Browsers asking for HTML get the normal handling
+	req = newRequest(t, http.MethodGet, "http://localhost/data", nil)
+	req.Header.Set("Accept", "text/html")
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected directory redirect without JSON in Accept, got %d", recorder.Code)
+	}
+
+	// Explicitly refused JSON is not negotiated
+	req = newRequest(t, http.MethodGet, "http://localhost/data", nil)
+	req.Header.Set("Accept", "application/json;q=0")
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected directory redirect for q=0, got %d", recorder.Code)
+	}
+
+	// Missing variants fall through to normal handling
+	req = newRequest(t, http.MethodGet, "http://localhost/report", nil)
+	req.Header.Set("Accept", "application/json")
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected fall through without a JSON variant, got %d", recorder.Code)
+	}
+}
+
+func TestExpandAlternateFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"audio/track.ogg":  "ogg",
+		"audio/track.flac": "flac",
+		"audio/intro.mp3":  "mp3",
+	})
+	cfg.ExpandAlternateFiles = true
+	cfg.AlternateExtensions = map[string][]string{
+		"audio/mpeg": {".mp3"},
+		"audio/ogg":  {"ogg"},
+		"audio/flac": {".flac"},
+		"audio/*":    {".mp3", ".ogg", ".flac"},
+		"*/*":        {".mp3", ".ogg", ".flac"},
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		target, accept, expected string
+	}{
+		{"/audio/track", "", "ogg"},
+		{"/audio/track", "audio/flac", "flac"},
+		// The preferred type wins when it exists, lower ones are the fallback
+		{"/audio/track", "audio/mpeg, audio/flac;q=0.9, audio/ogg;q=0.5", "flac"},
+		{"/audio/track", "audio/*;q=0.8, audio/ogg", "ogg"},
+		{"/audio/track", "*/*, audio/flac", "flac"},
+		{"/audio/intro", "audio/ogg, audio/*;q=0.1", "mp3"},
+		{"/audio/intro", "audio/ogg", "404"},
+		{"/audio/track", "text/html, audio/flac;q=0", "404"},
+		{"/audio/track.ogg", "audio/flac", "ogg"},
+	} {
+		req := newRequest(t, http.MethodGet, "http://localhost"+test.target, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		recorder := serve(handler, req)
+		got := recorder.Body.String()
+		if recorder.Code != http.StatusOK {
+			got = strconv.Itoa(recorder.Code)
+		}
+		if got != test.expected {
+			t.Errorf("Expected %s for %s with Accept %q, got %s", test.expected, test.target, test.accept, got)
+		}
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/audio/track", nil))
+	if recorder.Header().Get("Content-Type") != "audio/ogg" || recorder.Header().Get("Content-Location") != "/audio/track.ogg" ||
+		recorder.Header().Get("Vary") != "Accept" {
+		t.Errorf("Unexpected headers for a variant %v", recorder.Header())
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"page.fr.html":  "fr",
+		"page.en.html":  "en",
+		"page.html":     "default",
+		"about.de.html": "de",
+	})
+	cfg.NegotiateLanguage = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		target, acceptLanguage, expected, language string
+	}{
+		{"/page", "fr, en;q=0.8", "fr", "fr"},
+		{"/page", "de, en;q=0.8, fr;q=0.5", "en", "en"},
+		{"/page", "fr-CA", "fr", "fr"},
+		{"/page", "FR;q=0.2, EN", "en", "en"},
+		{"/page", "ja", "default", ""},
+		{"/page", "", "default", ""},
+		{"/page", "../page, fr;q=0", "default", ""},
+		{"/about", "fr", "404", ""},
+		{"/about", "de", "de", "de"},
+		{"/page.html", "fr", "default", ""},
+	} {
+		req := newRequest(t, http.MethodGet, "http://localhost"+test.target, nil)
+		if test.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", test.acceptLanguage)
+		}
+		recorder := serve(handler, req)
+		got := recorder.Body.String()
+		if recorder.Code != http.StatusOK {
+			got = strconv.Itoa(recorder.Code)
+		}
+		if got != test.expected || recorder.Header().Get("Content-Language") != test.language {
+			t.Errorf("Expected %s in %q for %s with Accept-Language %q, got %s in %q",
+				test.expected, test.language, test.target, test.acceptLanguage, got, recorder.Header().Get("Content-Language"))
+		}
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/page", nil))
+	if recorder.Header().Get("Vary") != "Accept-Language" || recorder.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("Unexpected headers for a language variant %v", recorder.Header())
+	}
+}
@@ -0,0 +1,250 @@
+package statiq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultOTLPEndpoint is the OTLP/HTTP traces URL used when OTLPEndpoint is unset
+const defaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// spanQueueSize bounds the spans waiting for export; requests never wait
+// for the exporter, so spans are dropped once the queue is full
+const spanQueueSize = 4096
+
+// spanBatchSize is the largest number of spans sent in one export request
+const spanBatchSize = 512
+
+// spanExportInterval is how often queued spans are sent
+const spanExportInterval = 5 * time.Second
+
+// span records one ServeHTTP invocation
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	method   string
+	path     string
+	status   int
+	start    time.Time
+	end      time.Time
+	file     bool
+	size     int64
+	modTime  time.Time
+}
+
+// spanContextKey carries the request's span so the served file can be recorded
+type spanContextKey struct{}
+
+// spanExporter sends finished spans to an OTLP/HTTP collector as JSON
+// (https://opentelemetry.io/docs/specs/otlp/#otlphttp) from a background
+// goroutine
+type spanExporter struct {
+	endpoint string
+	client   *http.Client
+	queue    chan *span
+}
+
+func newSpanExporter(ctx context.Context, endpoint string) *spanExporter {
+	e := &spanExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *span, spanQueueSize),
+	}
+	go e.run(ctx)
+	return e
+}
+
+// run exports spans every spanExportInterval or whenever a batch fills up,
+// flushing what is left once ctx is done
+func (e *spanExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(spanExportInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < spanBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			e.export(batch)
+			return
+		}
+		e.export(batch)
+		batch = nil
+	}
+}
+
+// add queues a finished span without blocking
+func (e *spanExporter) add(s *span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+// otlpAttribute is a key-value pair in OTLP JSON encoding; 64-bit integers
+// are encoded as strings
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	} `json:"value"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.StringValue = &value
+	return a
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	v := strconv.FormatInt(value, 10)
+	a.Value.IntValue = &v
+	return a
+}
+
+// otlpSpan is a span in OTLP JSON encoding, with hex trace and span IDs
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+// otlpSpanKindServer and otlpStatusError are the OTLP enum values used here
+const (
+	otlpSpanKindServer = 2
+	otlpStatusError    = 2
+)
+
+func (s *span) otlp() otlpSpan {
+	attributes := []otlpAttribute{
+		stringAttribute("http.method", s.method),
+		stringAttribute("http.path", s.path),
+		intAttribute("http.status_code", int64(s.status)),
+	}
+	if s.file {
+		attributes = append(attributes,
+			intAttribute("file.size", s.size),
+			stringAttribute("file.mtime", s.modTime.UTC().Format(time.RFC3339)))
+	}
+
+	out := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.method,
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        attributes,
+	}
+	if s.status >= 500 {
+		out.Status = &otlpStatus{Code: otlpStatusError}
+	}
+	return out
+}
+
+// export sends a batch of spans, logging failures
+func (e *spanExporter) export(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = s.otlp()
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{stringAttribute("service.name", "statiq")},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "statiq", "version": Version},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("statiq: encoding %d spans failed: %v", len(batch), err)
+		return
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("statiq: exporting %d spans failed: %v", len(batch), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("statiq: exporting %d spans failed: %s", len(batch), resp.Status)
+	}
+}
+
+// wrapTracing records a span for every request served by next. The span
+// joins the trace of an incoming traceparent; requests without one start
+// a new trace, which is written back to the request so TraceHeaders and
+// the access log report the same trace ID.
+func (h *StatiqHandler) wrapTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &span{
+			spanID: randomHex(8),
+			method: r.Method,
+			path:   r.URL.Path,
+			start:  time.Now(),
+		}
+		traceparent := r.Header.Get("traceparent")
+		if s.traceID = parseTraceparent(traceparent); s.traceID != "" {
+			s.parentID = traceparent[36:52]
+		} else {
+			s.traceID = randomHex(16)
+			r.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", s.traceID, s.spanID))
+			r.Header.Del("tracestate")
+		}
+
+		recorder := &logRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), spanContextKey{}, s)))
+
+		s.status = recorder.status
+		if s.status == 0 {
+			s.status = http.StatusOK
+		}
+		s.end = time.Now()
+		h.spans.add(s)
+	})
+}
+
+// recordSpanFile adds the served file's size and modification time to the
+// request's span
+func recordSpanFile(r *http.Request, size int64, modTime time.Time) {
+	if s, ok := r.Context().Value(spanContextKey{}).(*span); ok {
+		s.file, s.size, s.modTime = true, size, modTime
+	}
+}
@@ -0,0 +1,105 @@
+package statiq_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestOpenTelemetry(t *testing.T) {
+	t.Parallel()
+
+	type attribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+			IntValue    string `json:"intValue"`
+		} `json:"value"`
+	}
+	type exportedSpan struct {
+		TraceID      string      `json:"traceId"`
+		ParentSpanID string      `json:"parentSpanId"`
+		Name         string      `json:"name"`
+		Attributes   []attribute `json:"attributes"`
+	}
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []exportedSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+
+	received := make(chan struct{})
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected export request to %s with %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Expected an OTLP JSON payload: %v", err)
+		}
+		close(received)
+	}))
+	defer collector.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello"})
+	cfg.OpenTelemetry = true
+	cfg.OTLPEndpoint = collector.URL + "/v1/traces"
+
+	handler, err := statiq.New(ctx, next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/page.txt", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve(handler, req)
+	serve(handler, newRequest(t, http.MethodGet, "http://localhost/missing.txt", nil))
+
+	// Cancelling the context flushes the queued spans
+	cancel()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected spans to be exported")
+	}
+
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected OTLP payload %+v", request)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected two spans, got %d", len(spans))
+	}
+
+	attributes := func(s exportedSpan) map[string]string {
+		values := map[string]string{}
+		for _, a := range s.Attributes {
+			values[a.Key] = a.Value.StringValue + a.Value.IntValue
+		}
+		return values
+	}
+
+	served := attributes(spans[0])
+	if spans[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spans[0].ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("Expected the span to join the incoming trace, got %s/%s", spans[0].TraceID, spans[0].ParentSpanID)
+	}
+	if served["http.method"] != "GET" || served["http.path"] != "/page.txt" || served["http.status_code"] != "200" || served["file.size"] != "5" || served["file.mtime"] == "" {
+		t.Errorf("Unexpected span attributes %v", served)
+	}
+
+	missing := attributes(spans[1])
+	if len(spans[1].TraceID) != 32 || spans[1].ParentSpanID != "" {
+		t.Errorf("Expected a new root span, got %s/%s", spans[1].TraceID, spans[1].ParentSpanID)
+	}
+	if missing["http.status_code"] != "404" || missing["file.size"] != "" {
+		t.Errorf("Unexpected span attributes %v", missing)
+	}
+}
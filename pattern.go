@@ -0,0 +1,46 @@
+package statiq
+
+import (
+	"path"
+	"strings"
+)
+
+// matchPathPattern matches a URL path against a glob or prefix pattern.
+// Patterns without glob characters match as plain prefixes ("/assets/").
+// Glob patterns are matched segment by segment with path.Match semantics,
+// where a "**" segment matches any number of segments ("/api/**").
+func matchPathPattern(pattern, urlPath string) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return strings.HasPrefix(urlPath, pattern)
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(urlPath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, expanding "**"
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Trailing ** matches everything that is left
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
@@ -0,0 +1,141 @@
+package statiq
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// preCompressedEncodings lists the sidecar extensions by Content-Encoding, most preferred first
+var preCompressedEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// servePreCompressed serves the .br or .gz sidecar of upath when the client
+// accepts its encoding. It returns false when no sidecar applies, leaving the
+// original file to be served.
+func (h *StatiqHandler) servePreCompressed(w http.ResponseWriter, r *http.Request, upath string, d fs.FileInfo) bool {
+	// The response for this URL depends on the Accept-Encoding header
+	addVary(w.Header(), "Accept-Encoding")
+
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	for _, candidate := range preCompressedEncodings {
+		if !acceptsMediaType(acceptEncoding, candidate.encoding) {
+			continue
+		}
+
+		f, err := h.root.Open(upath + candidate.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		sd, err := f.Stat()
+		if err != nil || !sd.Mode().IsRegular() {
+			continue
+		}
+
+		modTime := d.ModTime()
+		if h.disableLastModified {
+			modTime = time.Time{}
+		}
+
+		// Content-Type stays the original's; the sidecar is a different representation
+		w.Header().Set("Content-Encoding", candidate.encoding)
+		w.Header().Set("ETag", h.fileETag(upath+candidate.ext, sd))
+		http.ServeContent(w, r, d.Name(), modTime, f)
+		return true
+	}
+
+	return false
+}
+
+// precompressRoot writes the .br and .gz sidecars of every compressible file
+// of at least GzipMinSize below root, stopping early once ctx is done.
+// Existing sidecars are kept unless force is set.
+func (h *StatiqHandler) precompressRoot(ctx context.Context, root string, force bool) {
+	defer h.precompressDone.Store(true)
+
+	err := filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Unreadable entries are skipped, the rest of the tree still gets its sidecars
+			return nil
+		}
+		if entry.IsDir() {
+			if name != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := filepath.Ext(name)
+		if !entry.Type().IsRegular() || ext == ".br" || ext == ".gz" || !isCompressible(h.typeByExtension(ext)) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.Size() < h.gzipMinSize {
+			return nil
+		}
+
+		for _, candidate := range preCompressedEncodings {
+			sidecar := name + candidate.ext
+			if !force {
+				if _, err := os.Stat(sidecar); err == nil {
+					continue
+				}
+			}
+			if err := h.writeSidecar(name, sidecar, candidate.encoding); err != nil {
+				log.Printf("statiq: pre-compressing %s failed: %v", name, err)
+				continue
+			}
+			h.precompressedFiles.Add(1)
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("statiq: pre-compressing %s failed: %v", root, err)
+	}
+}
+
+// writeSidecar compresses name into sidecar. The stream goes to a hidden
+// temporary file first, so readers never see a partial sidecar.
+func (h *StatiqHandler) writeSidecar(name, sidecar, encoding string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".statiq-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder, err := h.newEncoder(encoding, tmp)
+	if err == nil {
+		if _, err = io.Copy(encoder, src); err == nil {
+			err = encoder.Close()
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sidecar)
+}
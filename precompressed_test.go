@@ -0,0 +1,148 @@
+package statiq_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestPreCompressed(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"app.js":       "raw",
+		"app.js.br":    "brotli",
+		"app.js.gz":    "gzip",
+		"style.css":    "raw",
+		"style.css.gz": "gzip",
+	})
+	cfg.PreCompressed = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, acceptEncoding, encoding, body string
+	}{
+		{"/app.js", "gzip, deflate, br", "br", "brotli"},
+		{"/app.js", "gzip", "gzip", "gzip"},
+		{"/app.js", "br;q=0, gzip", "gzip", "gzip"},
+		{"/app.js", "", "", "raw"},
+		{"/style.css", "br", "", "raw"},
+		{"/style.css", "br, gzip", "gzip", "gzip"},
+	}
+
+	for _, test := range tests {
+		req := newRequest(t, http.MethodGet, "http://localhost"+test.path, nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		recorder := serve(handler, req)
+
+		if got := recorder.Header().Get("Content-Encoding"); got != test.encoding || recorder.Body.String() != test.body {
+			t.Errorf("%s with %q: expected %q encoding and body %q, got %q and %q",
+				test.path, test.acceptEncoding, test.encoding, test.body, got, recorder.Body.String())
+		}
+		if recorder.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s with %q: expected Vary: Accept-Encoding, got %q", test.path, test.acceptEncoding, recorder.Header().Get("Vary"))
+		}
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if got := serve(handler, req).Header().Get("Content-Type"); got != "text/css; charset=utf-8" {
+		t.Errorf("Expected the original Content-Type for a sidecar, got %q", got)
+	}
+}
+
+func TestBackgroundCompressOnBoot(t *testing.T) {
+	t.Parallel()
+
+	css := strings.Repeat("body { color: red; }\n", 100)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"style.css":    css,
+		"app.js":       strings.Repeat("console.log(1);\n", 100),
+		"app.js.gz":    "stale",
+		"small.css":    "a{}",
+		"photo.png":    strings.Repeat("x", 2048),
+		".git/config":  strings.Repeat("x", 2048),
+		"docs/api.txt": strings.Repeat("text ", 500),
+	})
+	cfg.BackgroundCompressOnBoot = true
+	cfg.MetricsPath = "/metrics"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waitDone polls the metrics until the walk finished and returns them
+	waitDone := func(handler http.Handler) string {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			body := serve(handler, newRequest(t, http.MethodGet, "http://localhost/metrics", nil)).Body.String()
+			if strings.Contains(body, "statiq_precompress_done 1") {
+				return body
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected pre-compression to finish, got:\n%s", body)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if body := waitDone(handler); !strings.Contains(body, "statiq_precompressed_files_total 5\n") {
+		t.Errorf("Expected 5 sidecars to be written, got:\n%s", body)
+	}
+
+	f, err := os.Open(filepath.Join(cfg.Root, "style.css.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := io.ReadAll(zr); err != nil || string(decoded) != css {
+		t.Errorf("Expected style.css.gz to decode to the original, got %v", err)
+	}
+
+	for name, exists := range map[string]bool{
+		"style.css.br":    true,
+		"docs/api.txt.br": true,
+		"app.js.br":       true,
+		"small.css.gz":    false,
+		"photo.png.gz":    false,
+		".git/config.gz":  false,
+	} {
+		if _, err := os.Stat(filepath.Join(cfg.Root, filepath.FromSlash(name))); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got %v", name, exists, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(cfg.Root, "app.js.gz")); string(data) != "stale" {
+		t.Errorf("Expected the existing sidecar to be kept, got %q", data)
+	}
+
+	// Forcing replaces existing sidecars
+	cfg.ForceRecompress = true
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDone(handler)
+	if data, _ := os.ReadFile(filepath.Join(cfg.Root, "app.js.gz")); string(data) == "stale" {
+		t.Error("Expected forceRecompress to replace the existing sidecar")
+	}
+}
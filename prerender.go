@@ -0,0 +1,145 @@
+package statiq
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// prerenderTimeout bounds a single headless browser render
+	prerenderTimeout = 30 * time.Second
+
+	defaultPrerenderConcurrency  = 2
+	defaultPrerenderMaxSnapshots = 10000
+)
+
+// botUserAgents are lower-case User-Agent fragments of crawlers that get prerendered pages
+var botUserAgents = []string{
+	"googlebot", "bingbot", "yandex", "baiduspider", "duckduckbot", "slurp",
+	"applebot", "facebookexternalhit", "twitterbot", "linkedinbot", "slackbot",
+	"discordbot", "embedly", "crawler", "spider", "bot/", "bot;",
+}
+
+// isBot reports whether the request comes from a known crawler
+func isBot(r *http.Request) bool {
+	ua := strings.ToLower(r.Header.Get("User-Agent"))
+	if ua == "" || strings.Contains(ua, "headlesschrome") {
+		return false
+	}
+
+	for _, fragment := range botUserAgents {
+		if strings.Contains(ua, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// prerenderCacheFile is the snapshot location for a URL path
+func (h *StatiqHandler) prerenderCacheFile(urlPath string) string {
+	sum := sha1.Sum([]byte(urlPath))
+	return filepath.Join(h.prerenderCachePath, hex.EncodeToString(sum[:])+".html")
+}
+
+// servePrerendered serves the cached snapshot of an SPA route to crawlers. On a
+// cache miss it starts a background render and returns false so the current
+// request gets the regular SPA index.
+func (h *StatiqHandler) servePrerendered(w http.ResponseWriter, r *http.Request) bool {
+	if !h.spaPrerender || !isBot(r) {
+		return false
+	}
+
+	// The User-Agent is client controlled, so only configured routes are ever
+	// rendered and made-up paths cannot fill the snapshot cache
+	if !h.isPrerenderRoute(r.URL.Path) {
+		return false
+	}
+
+	cacheFile := h.prerenderCacheFile(r.URL.Path)
+	if _, err := os.Stat(cacheFile); err == nil {
+		h.serveFile(w, r, cacheFile)
+		return true
+	}
+
+	if h.prerenderSnapshots.Load() >= h.prerenderMaxSnapshots {
+		return false
+	}
+
+	// Renders beyond the concurrency limit are skipped rather than queued; the
+	// crawler gets the SPA index and a later visit renders the page
+	select {
+	case h.prerenderSlots <- struct{}{}:
+	default:
+		return false
+	}
+
+	// The page is always rendered from the configured origin and keyed by path
+	// alone, so request headers and query strings cannot steer the browser
+	pageURL := h.prerenderOrigin + r.URL.EscapedPath()
+	if _, rendering := h.prerendering.LoadOrStore(cacheFile, struct{}{}); rendering {
+		<-h.prerenderSlots
+		return false
+	}
+
+	go func() {
+		defer func() { <-h.prerenderSlots }()
+		defer h.prerendering.Delete(cacheFile)
+		if err := h.prerender(pageURL, cacheFile); err != nil {
+			log.Printf("statiq: prerendering %s failed: %v", pageURL, err)
+			return
+		}
+		h.prerenderSnapshots.Add(1)
+	}()
+
+	return false
+}
+
+// isPrerenderRoute reports whether urlPath matches one of the PrerenderRoutes
+func (h *StatiqHandler) isPrerenderRoute(urlPath string) bool {
+	for _, pattern := range h.prerenderRoutes {
+		if matchPathPattern(pattern, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// countPrerenderSnapshots counts the snapshots already stored in dir
+func countPrerenderSnapshots(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	var count int64
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".html" {
+			count++
+		}
+	}
+	return count
+}
+
+// prerender snapshots the rendered DOM of pageURL by running the browser
+// executable with --dump-dom and atomically stores it in cacheFile
+func (h *StatiqHandler) prerender(pageURL, cacheFile string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), prerenderTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.prerenderBrowser,
+		"--headless", "--disable-gpu", "--dump-dom", pageURL)
+	html, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	return h.writeCacheFile(cacheFile, html)
+}
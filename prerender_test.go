@@ -0,0 +1,152 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestSPAPrerender(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake browser is a shell script")
+	}
+
+	// A stand-in for headless Chromium that prints a rendered DOM
+	browser := filepath.Join(t.TempDir(), "fake-chromium")
+	script := "#!/bin/sh\nfor last; do :; done\necho \"<html><body>Rendered $last</body></html>\"\n"
+	if err := os.WriteFile(browser, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html><body><div id=app></div></body></html>"})
+	cfg.SPAMode = true
+	cfg.SPAPrerender = true
+	cfg.PrerenderCachePath = t.TempDir()
+	cfg.PrerenderBrowser = browser
+	cfg.PrerenderOrigin = "https://www.example.com"
+	cfg.PrerenderRoutes = []string{"/products/"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	botRequest := func() *http.Request {
+		req := newRequest(t, http.MethodGet, "http://localhost/products/42?ref=mail", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+		// Forwarded headers never reach the browser's URL
+		req.Header.Set("X-Forwarded-Proto", "file")
+		req.Host = "169.254.169.254"
+		return req
+	}
+
+	// The first crawler visit gets the SPA shell while the snapshot renders
+	recorder := serve(handler, botRequest())
+	if !strings.Contains(recorder.Body.String(), "<div id=app>") {
+		t.Fatalf("Expected SPA index on cache miss, got %s", recorder.Body.String())
+	}
+
+	// Later crawler visits get the cached snapshot
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder = serve(handler, botRequest())
+		if strings.Contains(recorder.Body.String(), "Rendered https://www.example.com/products/42</body>") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected prerendered snapshot, got %s", recorder.Body.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Regular browsers always get the SPA shell
+	req := newRequest(t, http.MethodGet, "http://localhost/products/42", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	recorder = serve(handler, req)
+	if !strings.Contains(recorder.Body.String(), "<div id=app>") {
+		t.Errorf("Expected SPA index for browsers, got %s", recorder.Body.String())
+	}
+
+	// Routes outside prerenderRoutes are never rendered, whatever the User-Agent
+	req = botRequest()
+	req.URL.Path = "/r4nd0m/42"
+	serve(handler, req)
+	time.Sleep(100 * time.Millisecond)
+	if snapshots, _ := os.ReadDir(cfg.PrerenderCachePath); len(snapshots) != 1 {
+		t.Errorf("Expected only the /products/42 snapshot, got %d files", len(snapshots))
+	}
+
+	for _, origin := range []string{"", "file:///etc", "localhost", "https://example.com/app", "http://user@example.com"} {
+		cfg.PrerenderOrigin = origin
+		if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+			t.Errorf("Expected error for prerenderOrigin %q", origin)
+		}
+	}
+
+	cfg.PrerenderOrigin = "https://www.example.com/"
+	cfg.PrerenderRoutes = nil
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected error for spaPrerender without prerenderRoutes")
+	}
+
+	cfg.PrerenderRoutes = []string{"/products/"}
+	cfg.PrerenderCachePath = ""
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected error for spaPrerender without prerenderCachePath")
+	}
+}
+
+func TestSPAPrerenderMaxSnapshots(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake browser is a shell script")
+	}
+
+	browser := filepath.Join(t.TempDir(), "fake-chromium")
+	if err := os.WriteFile(browser, []byte("#!/bin/sh\necho '<html><body>Rendered</body></html>'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot left by an earlier run counts against the limit
+	cacheDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cacheDir, "0123.html"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<div id=app></div>"})
+	cfg.SPAMode = true
+	cfg.SPAPrerender = true
+	cfg.PrerenderCachePath = cacheDir
+	cfg.PrerenderBrowser = browser
+	cfg.PrerenderOrigin = "https://www.example.com"
+	cfg.PrerenderRoutes = []string{"/products/"}
+	cfg.PrerenderMaxSnapshots = 1
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/products/42", nil)
+	req.Header.Set("User-Agent", "Googlebot/2.1")
+	if recorder := serve(handler, req); !strings.Contains(recorder.Body.String(), "<div id=app>") {
+		t.Fatalf("Expected SPA index, got %s", recorder.Body.String())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if snapshots, _ := os.ReadDir(cacheDir); len(snapshots) != 1 {
+		t.Errorf("Expected no render past prerenderMaxSnapshots, got %d files", len(snapshots))
+	}
+}
@@ -0,0 +1,123 @@
+package statiq
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// defaultHTTP2PushMaxFiles caps the pushes per page when HTTP2PushMaxFiles is unset
+const defaultHTTP2PushMaxFiles = 10
+
+// maxPushScanSize is how much of an HTML page is searched for assets to push
+const maxPushScanSize = 1 << 20
+
+// pushedRequestHeaders are copied from the page request to the pushed ones,
+// so pushes are cached, negotiated and authorized like the page itself
+var pushedRequestHeaders = []string{"Accept-Encoding", "Accept-Language", "Authorization", "Cache-Control", "Cookie", "User-Agent"}
+
+// pushAssets pushes the stylesheets, preloads, scripts and images an HTML
+// page links to when the connection supports HTTP/2 server push. The page is
+// read from content and rewound afterwards.
+func (h *StatiqHandler) pushAssets(w http.ResponseWriter, r *http.Request, content io.ReadSeeker) {
+	pusher, ok := w.(http.Pusher)
+	if !ok || r.Method != http.MethodGet || !isHTMLResponse(w) {
+		return
+	}
+
+	page, err := io.ReadAll(io.LimitReader(content, maxPushScanSize))
+	if _, seekErr := content.Seek(0, io.SeekStart); err != nil || seekErr != nil {
+		return
+	}
+
+	// Links are relative to the URL the client asked for
+	base := &url.URL{Path: h.stripPrefix + r.URL.Path}
+	opts := &http.PushOptions{Header: http.Header{}}
+	for _, name := range pushedRequestHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			opts.Header[name] = values
+		}
+	}
+
+	pushed := map[string]bool{}
+	for _, link := range pushableLinks(page) {
+		if len(pushed) >= h.http2PushMaxFiles {
+			return
+		}
+		ref, err := url.Parse(link)
+		if err != nil || ref.Scheme != "" || ref.Host != "" {
+			// Other origins cannot be pushed
+			continue
+		}
+		target := base.ResolveReference(ref)
+		target.Fragment = ""
+		if pushed[target.RequestURI()] || target.RequestURI() == base.RequestURI() {
+			continue
+		}
+		pushed[target.RequestURI()] = true
+
+		if err := pusher.Push(target.RequestURI(), opts); err != nil {
+			// The client turned push off or the stream is gone, the rest would fail alike
+			if !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("statiq: pushing %s failed: %v", target.RequestURI(), err)
+			}
+			return
+		}
+	}
+}
+
+// pushableLinks returns the href of <link rel="stylesheet"> and
+// <link rel="preload"> tags and the src of <script> and <img> tags, in
+// document order. The HTML tokenizer skips comments and treats the contents
+// of scripts and styles as text.
+func pushableLinks(page []byte) []string {
+	var links []string
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if !hasAttr {
+				continue
+			}
+			attrs := tagAttributes(z)
+			switch string(name) {
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					if (rel == "stylesheet" || rel == "preload") && attrs["href"] != "" {
+						links = append(links, attrs["href"])
+						break
+					}
+				}
+			case "script", "img":
+				if attrs["src"] != "" {
+					links = append(links, attrs["src"])
+				}
+			}
+		}
+	}
+}
+
+// tagAttributes collects the attributes of the tokenizer's current tag into a
+// map keyed by lowercase name. The first occurrence of an attribute wins, as
+// in browsers.
+func tagAttributes(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		key, value, more := z.TagAttr()
+		if _, ok := attrs[string(key)]; !ok {
+			attrs[string(key)] = string(value)
+		}
+		if !more {
+			return attrs
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+// pushRecorder stands in for an HTTP/2 connection, recording the pushes
+type pushRecorder struct {
+	mu      sync.Mutex
+	targets []string
+	headers []http.Header
+}
+
+// pushWriter is a response writer that supports server push
+type pushWriter struct {
+	http.ResponseWriter
+	recorder *pushRecorder
+}
+
+func (w pushWriter) Push(target string, opts *http.PushOptions) error {
+	w.recorder.mu.Lock()
+	defer w.recorder.mu.Unlock()
+	w.recorder.targets = append(w.recorder.targets, target)
+	w.recorder.headers = append(w.recorder.headers, opts.Header)
+	return nil
+}
+
+const pushPage = `<!DOCTYPE html>
+<html><head>
+<link rel="stylesheet" href="css/site.css">
+<link rel=preload href="/fonts/a.woff2" as=font>
+<link rel="icon" href="/favicon.ico">
+<link rel="stylesheet" href="https://cdn.example.com/lib.css">
+<!-- <script src="/commented.js"></script> -->
+<script src='../js/app.js?v=2'></script>
+<script>document.write('<img src="/written.png">')</script>
+</head><body>
+<img alt="logo" src="/img/logo.png#top">
+<img src="data:image/png;base64,AAAA">
+<img src="/img/logo.png">
+</body></html>`
+
+func TestHTTP2Push(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		push     bool
+		maxFiles int
+		expected []string
+	}{
+		{"disabled", false, 0, nil},
+		{"enabled", true, 0, []string{"/docs/css/site.css", "/fonts/a.woff2", "/js/app.js?v=2", "/img/logo.png"}},
+		{"capped", true, 2, []string{"/docs/css/site.css", "/fonts/a.woff2"}},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := statiq.CreateConfig()
+			cfg.Root = newTestRoot(t, map[string]string{"docs/page.html": pushPage})
+			cfg.HTTP2Push = test.push
+			if test.maxFiles > 0 {
+				cfg.HTTP2PushMaxFiles = test.maxFiles
+			}
+			handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &pushRecorder{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler.ServeHTTP(pushWriter{ResponseWriter: w, recorder: recorder}, r)
+			}))
+			defer server.Close()
+
+			req := newRequest(t, http.MethodGet, server.URL+"/docs/page.html", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("Accept-Language", "fr")
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected the page, got %d", resp.StatusCode)
+			}
+
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			if !reflect.DeepEqual(recorder.targets, test.expected) {
+				t.Errorf("Expected pushes %v, got %v", test.expected, recorder.targets)
+			}
+			for _, header := range recorder.headers {
+				if header.Get("Authorization") != "Bearer token" || header.Get("Accept-Language") != "fr" {
+					t.Errorf("Expected pushes to carry the page's credentials, got %v", header)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package statiq
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// globalRateWindow is the window GlobalRateLimit counts requests in
+const globalRateWindow = time.Minute
+
+// globalRateLimiter counts the requests of all clients in fixed windows,
+// reset by a ticker rather than on the request path
+type globalRateLimiter struct {
+	limit   int64
+	count   atomic.Int64
+	resetAt atomic.Int64
+}
+
+func newGlobalRateLimiter(ctx context.Context, limit int) *globalRateLimiter {
+	l := &globalRateLimiter{limit: int64(limit)}
+	l.resetAt.Store(time.Now().Add(globalRateWindow).UnixNano())
+	go l.run(ctx)
+	return l
+}
+
+// run starts a new window every globalRateWindow until ctx is done
+func (l *globalRateLimiter) run(ctx context.Context) {
+	ticker := time.NewTicker(globalRateWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.resetAt.Store(now.Add(globalRateWindow).UnixNano())
+			l.count.Store(0)
+		}
+	}
+}
+
+// allow counts a request and reports whether it fits in the current window
+func (l *globalRateLimiter) allow() bool {
+	return l.count.Add(1) <= l.limit
+}
+
+// retryAfter is the number of seconds until the window resets
+func (l *globalRateLimiter) retryAfter() string {
+	seconds := int64(time.Until(time.Unix(0, l.resetAt.Load())).Seconds()) + 1
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
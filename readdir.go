@@ -0,0 +1,67 @@
+package statiq
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+)
+
+// readDirEntry is a cached, sorted directory listing
+type readDirEntry struct {
+	entries  []fs.FileInfo
+	dirMod   time.Time
+	loadedAt time.Time
+}
+
+// readDir returns the entries of the directory f, directories first and then
+// by name. With ReadDirCacheTTL set, listings are reused until they are stale
+// or the directory's own modification time changes, which happens whenever an
+// entry is added, removed or renamed. Cached slices must not be modified.
+func (h *StatiqHandler) readDir(dir string, f http.File, d fs.FileInfo) ([]fs.FileInfo, error) {
+	dir = path.Clean("/" + dir)
+	now := time.Now()
+
+	if h.readDirCacheTTL > 0 {
+		if cached, ok := h.readDirCache.Load(dir); ok {
+			entry := cached.(readDirEntry)
+			if now.Sub(entry.loadedAt) < h.readDirCacheTTL && entry.dirMod.Equal(d.ModTime()) {
+				return entry.entries, nil
+			}
+		}
+	}
+
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hidden dot-files are left out of listings
+	if h.hideDotFiles {
+		visible := entries[:0]
+		for _, entry := range entries {
+			if !h.isDotName(entry.Name()) {
+				visible = append(visible, entry)
+			}
+		}
+		entries = visible
+	}
+
+	// Sort directories first, then by name
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() && !entries[j].IsDir() {
+			return true
+		}
+		if !entries[i].IsDir() && entries[j].IsDir() {
+			return false
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	if h.readDirCacheTTL > 0 {
+		h.readDirCache.Store(dir, readDirEntry{entries: entries, dirMod: d.ModTime(), loadedAt: now})
+	}
+
+	return entries, nil
+}
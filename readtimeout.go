@@ -0,0 +1,74 @@
+package statiq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// errReadTimeout ends a response whose file stopped delivering data
+var errReadTimeout = errors.New("file read timed out")
+
+// timeoutReader gives up on reads of the file that take longer than timeout
+// or outlive the request. The read itself cannot be interrupted, so it runs
+// on its own goroutine and is abandoned; the serving goroutine moves on and
+// every later call fails.
+type timeoutReader struct {
+	io.ReadSeeker
+	ctx     context.Context
+	name    string
+	timeout time.Duration
+	buf     []byte
+	err     error
+}
+
+// readResult is the outcome of a read running on its own goroutine
+type readResult struct {
+	n   int
+	err error
+}
+
+func newTimeoutReader(ctx context.Context, content io.ReadSeeker, name string, timeout time.Duration) *timeoutReader {
+	return &timeoutReader{ReadSeeker: content, ctx: ctx, name: name, timeout: timeout}
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	// The abandoned goroutine may still write to buf, so it is never reused after a timeout
+	if cap(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := r.ReadSeeker.Read(buf)
+		done <- readResult{n, err}
+	}()
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return copy(p, buf[:res.n]), res.err
+	case <-timer.C:
+		r.err = fmt.Errorf("%s: %w after %s", r.name, errReadTimeout, r.timeout)
+		log.Printf("statiq: %v", r.err)
+	case <-r.ctx.Done():
+		r.err = r.ctx.Err()
+	}
+	return 0, r.err
+}
+
+func (r *timeoutReader) Seek(offset int64, whence int) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.ReadSeeker.Seek(offset, whence)
+}
@@ -0,0 +1,81 @@
+package statiq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowFS delays every read of its files, like a stalled network mount
+type slowFS struct {
+	http.FileSystem
+	delay time.Duration
+}
+
+func (fs slowFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return slowFile{File: f, delay: fs.delay}, nil
+}
+
+type slowFile struct {
+	http.File
+	delay time.Duration
+}
+
+func (f slowFile) Read(p []byte) (int, error) {
+	time.Sleep(f.delay)
+	return f.File.Read(p)
+}
+
+func TestReadTimeout(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	content := strings.Repeat("x", 1024)
+	if err := os.WriteFile(filepath.Join(root, "data.bin"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		delay    time.Duration
+		complete bool
+	}{
+		{name: "fast reads", delay: 0, complete: true},
+		{name: "stalled reads", delay: 2 * time.Second, complete: false},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := CreateConfig()
+			cfg.Root = root
+			cfg.ReadTimeout = 50 * time.Millisecond
+			h, err := newHandler(context.Background(), http.NotFoundHandler(), cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.root = slowFS{FileSystem: h.root, delay: test.delay}
+
+			start := time.Now()
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost/data.bin", nil))
+			elapsed := time.Since(start)
+
+			if complete := recorder.Body.String() == content; complete != test.complete {
+				t.Errorf("Expected a complete body: %v, got %d bytes", test.complete, recorder.Body.Len())
+			}
+			if !test.complete && elapsed >= test.delay {
+				t.Errorf("Expected the response to give up after the read timeout, took %s", elapsed)
+			}
+		})
+	}
+}
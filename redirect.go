@@ -0,0 +1,77 @@
+package statiq
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultMaxRedirects bounds redirect chain resolution when MaxRedirects is unset
+const defaultMaxRedirects = 10
+
+// redirectRule is a single source path → target mapping of the redirect engine
+type redirectRule struct {
+	Target     string
+	StatusCode int
+}
+
+// resolveRedirect walks the redirect rules starting at p. Chains of local
+// rules (A → B → C) are collapsed into a single redirect to the final target.
+// The returned status is 0 when no rule matches, and http.StatusLoopDetected
+// when the chain exceeds the configured number of evaluations.
+func (h *StatiqHandler) resolveRedirect(p string) (string, int) {
+	rule, ok := h.redirectRuleFor(p)
+	if !ok {
+		return "", 0
+	}
+
+	evaluations := 1
+	for {
+		// Only local targets can match another rule
+		if !strings.HasPrefix(rule.Target, "/") {
+			break
+		}
+		nextRule, ok := h.redirectRuleFor(rule.Target)
+		if !ok {
+			break
+		}
+
+		evaluations++
+		if evaluations > h.maxRedirects {
+			return "", http.StatusLoopDetected
+		}
+		rule = nextRule
+	}
+
+	return rule.Target, rule.StatusCode
+}
+
+// redirectRuleFor returns the rule for a path, preferring Redirects over the RedirectMap file
+func (h *StatiqHandler) redirectRuleFor(p string) (redirectRule, bool) {
+	if rule, ok := h.redirects[p]; ok {
+		return rule, true
+	}
+	if h.redirectMap != nil {
+		return h.redirectMap.lookup(p)
+	}
+	return redirectRule{}, false
+}
+
+// serveRedirect answers the request from the redirect rules. It returns
+// false when no rule matches the request path.
+func (h *StatiqHandler) serveRedirect(w http.ResponseWriter, r *http.Request) bool {
+	if len(h.redirects) == 0 && h.redirectMap == nil {
+		return false
+	}
+
+	target, status := h.resolveRedirect(r.URL.Path)
+	switch status {
+	case 0:
+		return false
+	case http.StatusLoopDetected:
+		http.Error(w, "Loop Detected", http.StatusLoopDetected)
+	default:
+		http.Redirect(w, r, target, status)
+	}
+
+	return true
+}
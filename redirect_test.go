@@ -0,0 +1,154 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestRedirects(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"new.html": "<html>New</html>"})
+	cfg.Redirects = map[string]string{
+		"/old.html":    "/older.html",
+		"/older.html":  "/new.html",
+		"/external":    "https://example.com/",
+		"/loop-a.html": "/loop-b.html",
+		"/loop-b.html": "/loop-a.html",
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chains are collapsed into a single redirect to the final target
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/old.html", nil))
+	if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != "/new.html" {
+		t.Errorf("Expected 301 to /new.html, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/external", nil))
+	if recorder.Header().Get("Location") != "https://example.com/" {
+		t.Errorf("Expected redirect to https://example.com/, got %s", recorder.Header().Get("Location"))
+	}
+
+	// Cyclic rules are detected instead of looping forever
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/loop-a.html", nil))
+	if recorder.Code != http.StatusLoopDetected {
+		t.Errorf("Expected 508 Loop Detected for A -> B -> A, got %d", recorder.Code)
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/new.html", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK for unmatched path, got %d", recorder.Code)
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, nil)
+	cfg.MaxRedirects = 2
+	cfg.Redirects = map[string]string{
+		"/a": "/b",
+		"/b": "/c",
+		"/c": "/d",
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/b", nil))
+	if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != "/d" {
+		t.Errorf("Expected 301 to /d within the limit, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/a", nil))
+	if recorder.Code != http.StatusLoopDetected {
+		t.Errorf("Expected 508 once the chain exceeds maxRedirects, got %d", recorder.Code)
+	}
+}
+
+func TestRedirectMap(t *testing.T) {
+	t.Parallel()
+
+	mapFile := filepath.Join(t.TempDir(), "redirects.json")
+	writeMap := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(mapFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(mapFile, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeMap(`{
+		"/legacy/about.html": "/about/",
+		"/promo": {"target": "https://example.com/sale", "statusCode": 302},
+		"/docs/old": "new"
+	}`, time.Now().Add(-time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"about/index.html": "about"})
+	cfg.RedirectMap = mapFile
+
+	handler, err := statiq.New(ctx, next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]struct {
+		code     int
+		location string
+	}{
+		"/legacy/about.html": {http.StatusMovedPermanently, "/about/"},
+		"/promo":             {http.StatusFound, "https://example.com/sale"},
+		// Relative targets resolve against the request path
+		"/docs/old": {http.StatusMovedPermanently, "/docs/new"},
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if recorder.Code != expected.code || recorder.Header().Get("Location") != expected.location {
+			t.Errorf("Expected %d to %s for %s, got %d %s", expected.code, expected.location, target, recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+
+	// A changed file is picked up without restarting
+	writeMap(`{"/promo": {"target": "/about/", "statusCode": 307}}`, time.Now())
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/promo", nil))
+		if recorder.Code == http.StatusTemporaryRedirect && recorder.Header().Get("Location") == "/about/" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the reloaded rule, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/legacy/about.html", nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected removed rules to stop matching, got %d", recorder.Code)
+	}
+
+	cfg.RedirectMap = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := statiq.New(ctx, next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a missing redirect map")
+	}
+	writeMap(`{"/a": {"target": "/b", "statusCode": 200}}`, time.Now())
+	cfg.RedirectMap = mapFile
+	if _, err := statiq.New(ctx, next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a non-redirect status code")
+	}
+}
@@ -0,0 +1,132 @@
+package statiq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// redirectMapPollInterval is how often the redirect map file is checked for changes
+const redirectMapPollInterval = 2 * time.Second
+
+// redirectMap holds the rules of the RedirectMap file, re-read whenever the
+// file's modification time or size changes
+type redirectMap struct {
+	path    string
+	mu      sync.RWMutex
+	rules   map[string]redirectRule
+	modTime time.Time
+	size    int64
+}
+
+// loadRedirectMap reads the redirect map file and keeps it up to date until ctx is done
+func loadRedirectMap(ctx context.Context, path string) (*redirectMap, error) {
+	rm := &redirectMap{path: path}
+	if err := rm.reload(); err != nil {
+		return nil, err
+	}
+	go rm.watch(ctx)
+	return rm, nil
+}
+
+// watch polls the file, logging and keeping the previous rules when a new
+// version cannot be parsed
+func (rm *redirectMap) watch(ctx context.Context) {
+	ticker := time.NewTicker(redirectMapPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := rm.reload(); err != nil {
+				log.Printf("statiq: reloading redirectMap failed, keeping the previous rules: %v", err)
+			}
+		}
+	}
+}
+
+// reload parses the file if it changed since the last read
+func (rm *redirectMap) reload() error {
+	info, err := os.Stat(rm.path)
+	if err != nil {
+		return err
+	}
+
+	rm.mu.RLock()
+	unchanged := rm.rules != nil && info.ModTime().Equal(rm.modTime) && info.Size() == rm.size
+	rm.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(rm.path)
+	if err != nil {
+		return err
+	}
+	rules, err := parseRedirectMap(data)
+	if err != nil {
+		// The same broken version is not parsed again
+		rm.mu.Lock()
+		rm.modTime, rm.size = info.ModTime(), info.Size()
+		rm.mu.Unlock()
+		return fmt.Errorf("%s: %w", rm.path, err)
+	}
+
+	rm.mu.Lock()
+	rm.rules, rm.modTime, rm.size = rules, info.ModTime(), info.Size()
+	rm.mu.Unlock()
+	return nil
+}
+
+// parseRedirectMap decodes a JSON object mapping request paths to either a
+// target string, redirected with 301, or a {"target", "statusCode"} object
+func parseRedirectMap(data []byte) (map[string]redirectRule, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	rules := make(map[string]redirectRule, len(raw))
+	for from, value := range raw {
+		rule := redirectRule{StatusCode: http.StatusMovedPermanently}
+		if err := json.Unmarshal(value, &rule.Target); err != nil {
+			var entry struct {
+				Target     string `json:"target"`
+				StatusCode int    `json:"statusCode"`
+			}
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return nil, fmt.Errorf("redirect for %q must be a string or an object: %w", from, err)
+			}
+			rule.Target = entry.Target
+			if entry.StatusCode != 0 {
+				rule.StatusCode = entry.StatusCode
+			}
+		}
+
+		if rule.Target == "" {
+			return nil, fmt.Errorf("redirect for %q has no target", from)
+		}
+		switch rule.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("redirect for %q has unsupported status code %d", from, rule.StatusCode)
+		}
+		rules[from] = rule
+	}
+	return rules, nil
+}
+
+// lookup returns the rule for a request path
+func (rm *redirectMap) lookup(p string) (redirectRule, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	rule, ok := rm.rules[p]
+	return rule, ok
+}
@@ -0,0 +1,82 @@
+package statiq
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take once the server is stopped
+const shutdownTimeout = 10 * time.Second
+
+// tlsVersions maps TLSMinVersion values to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion resolves a TLSMinVersion value, defaulting to TLS 1.2
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("tlsMinVersion must be one of 1.0, 1.1, 1.2 or 1.3, got %q", version)
+	}
+	return v, nil
+}
+
+// ListenAndServeTLS runs Statiq as a standalone HTTPS server on addr until ctx
+// is cancelled. Requests that Statiq does not answer get a 404.
+func ListenAndServeTLS(ctx context.Context, addr, certFile, keyFile string, config *Config) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ServeTLS(ctx, ln, certFile, keyFile, config)
+}
+
+// ServeTLS is like ListenAndServeTLS but accepts connections on an existing listener
+func ServeTLS(ctx context.Context, ln net.Listener, certFile, keyFile string, config *Config) error {
+	minVersion, err := parseTLSVersion(config.TLSMinVersion)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	handler, err := newHandler(ctx, http.NotFoundHandler(), config, nil)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           handler.wrap(config),
+		TLSConfig:         &tls.Config{MinVersion: minVersion},
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		close(drained)
+	}()
+
+	if err := srv.ServeTLS(ln, certFile, keyFile); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	// Cache files are flushed only once no request can write to them anymore
+	<-drained
+	return handler.Close()
+}
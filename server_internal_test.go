@@ -0,0 +1,109 @@
+package statiq
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Not parallel: it swaps the package-level syncFile
+func TestSyncOnShutdown(t *testing.T) {
+	var mu sync.Mutex
+	synced := map[string]bool{}
+	original := syncFile
+	syncFile = func(name string) error {
+		mu.Lock()
+		synced[name] = true
+		mu.Unlock()
+		return original(name)
+	}
+	t.Cleanup(func() { syncFile = original })
+
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	root, cacheDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := CreateConfig()
+	cfg.Root = root
+	cfg.ImageResize = true
+	cfg.ImageCachePath = cacheDir
+	cfg.SyncOnShutdown = true
+	// The sync must not depend on reaching the unwrapped handler
+	cfg.AccessLog = true
+	cfg.AccessLogWriter = io.Discard
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := New(ctx, http.NotFoundHandler(), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := handler.(*StatiqHandler); ok {
+		t.Fatal("Expected the access log to wrap the handler")
+	}
+
+	for _, width := range []string{"10", "20"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/a.png?w="+width, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected the resized image, got %d", recorder.Code)
+		}
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected two cached variants, got %d (%v)", len(entries), err)
+	}
+	// Cache files removed in the meantime are skipped
+	removed, kept := filepath.Join(cacheDir, entries[0].Name()), filepath.Join(cacheDir, entries[1].Name())
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	if len(synced) != 0 {
+		t.Errorf("Expected nothing synced before shutdown, got %v", synced)
+	}
+	mu.Unlock()
+
+	// Ending the plugin context syncs the cache files written since startup
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := synced[kept] && synced[cacheDir]
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s and its directory to be synced, got %v", kept, synced)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if synced[removed] {
+		t.Errorf("Expected the removed cache file to be skipped")
+	}
+}
@@ -0,0 +1,78 @@
+package statiq_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestTLSMinVersion(t *testing.T) {
+	t.Parallel()
+
+	cert, key := newTestCert(t, "localhost", false, nil, nil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+	cfg.TLSMinVersion = "1.3"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- statiq.ServeTLS(ctx, ln, certFile, keyFile, cfg) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	}()
+
+	dial := func(maxVersion uint16) error {
+		dialer := &net.Dialer{Timeout: 5 * time.Second}
+		conn, err := tls.DialWithDialer(dialer, "tcp", ln.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec // self-signed test certificate
+			MaxVersion:         maxVersion,
+		})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	if err := dial(tls.VersionTLS12); err == nil {
+		t.Error("Expected a TLS 1.2 handshake to be rejected with tlsMinVersion 1.3")
+	}
+	if err := dial(tls.VersionTLS13); err != nil {
+		t.Errorf("Expected a TLS 1.3 handshake to succeed, got %v", err)
+	}
+
+	// Unknown versions are rejected up front
+	cfg.TLSMinVersion = "1.4"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an unknown tlsMinVersion")
+	}
+}
@@ -0,0 +1,136 @@
+package statiq
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sidecarFileName is the per-directory configuration file read when sidecars are enabled
+const sidecarFileName = ".statiq"
+
+// sidecarTTL is how long a parsed (or missing) sidecar is reused before the disk is checked again
+const sidecarTTL = 5 * time.Second
+
+// sidecarConfig holds the settings a .statiq file may override for its subtree
+type sidecarConfig struct {
+	IndexFiles   []string          `json:"indexFiles,omitempty"`
+	CacheControl map[string]string `json:"cacheControl,omitempty"`
+	ErrorPage404 string            `json:"errorPage404,omitempty"`
+}
+
+// sidecarEntry is a cached lookup result; cfg is nil when the directory has no sidecar
+type sidecarEntry struct {
+	cfg      *sidecarConfig
+	loadedAt time.Time
+}
+
+// sidecarCache caches sidecar lookups per directory
+type sidecarCache struct {
+	mu      sync.Mutex
+	entries map[string]sidecarEntry
+}
+
+// loadSidecar returns the sidecar of dir, reading it from the root when the cached entry is stale
+func (h *StatiqHandler) loadSidecar(dir string) *sidecarConfig {
+	now := time.Now()
+
+	h.sidecars.mu.Lock()
+	entry, ok := h.sidecars.entries[dir]
+	h.sidecars.mu.Unlock()
+	if ok && now.Sub(entry.loadedAt) < sidecarTTL {
+		return entry.cfg
+	}
+
+	entry = sidecarEntry{loadedAt: now}
+	if f, err := h.root.Open(path.Join(dir, sidecarFileName)); err == nil {
+		var cfg sidecarConfig
+		if json.NewDecoder(f).Decode(&cfg) == nil {
+			// Error pages are relative to the directory holding the sidecar
+			if cfg.ErrorPage404 != "" && !strings.HasPrefix(cfg.ErrorPage404, "/") {
+				cfg.ErrorPage404 = path.Join(dir, cfg.ErrorPage404)
+			}
+			entry.cfg = &cfg
+		}
+		f.Close()
+	} else if !h.isDir(dir) {
+		// Only directories that exist are cached, so clients requesting
+		// made-up paths cannot grow the cache
+		return nil
+	}
+
+	h.sidecars.mu.Lock()
+	h.sidecars.entries[dir] = entry
+	h.sidecars.mu.Unlock()
+
+	return entry.cfg
+}
+
+// sidecarsFor returns the sidecars that apply to the request, nearest directory first
+func (h *StatiqHandler) sidecarsFor(r *http.Request) []*sidecarConfig {
+	if !h.readSidecars {
+		return nil
+	}
+
+	dir := r.URL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	dir = path.Clean("/" + dir)
+
+	var configs []*sidecarConfig
+	for {
+		if cfg := h.loadSidecar(dir); cfg != nil {
+			configs = append(configs, cfg)
+		}
+		if dir == "/" {
+			return configs
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// indexFilesFor returns the index files in effect for the request
+func (h *StatiqHandler) indexFilesFor(r *http.Request) []string {
+	for _, cfg := range h.sidecarsFor(r) {
+		if len(cfg.IndexFiles) > 0 {
+			return cfg.IndexFiles
+		}
+	}
+	return h.indexFiles
+}
+
+// cacheControlFor returns the extension → Cache-Control map in effect for the request
+func (h *StatiqHandler) cacheControlFor(r *http.Request) map[string]string {
+	for _, cfg := range h.sidecarsFor(r) {
+		if len(cfg.CacheControl) > 0 {
+			return cfg.CacheControl
+		}
+	}
+	return h.cacheControl
+}
+
+// errorPage404For returns the custom 404 page in effect for the request
+func (h *StatiqHandler) errorPage404For(r *http.Request) string {
+	for _, cfg := range h.sidecarsFor(r) {
+		if cfg.ErrorPage404 != "" {
+			return cfg.ErrorPage404
+		}
+	}
+	return h.errorPage404
+}
+
+// isDir reports whether name is a directory under the root
+func (h *StatiqHandler) isDir(name string) bool {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,35 @@
+package statiq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSidecarCacheOnlyExistingDirs(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "blog"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "blog", sidecarFileName), []byte(`{"indexFiles": ["home.html"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &StatiqHandler{root: http.Dir(root), readSidecars: true, sidecars: sidecarCache{entries: map[string]sidecarEntry{}}}
+
+	for _, target := range []string{"/blog/post.html", "/r4nd0m/x", "/blog/missing/deeper/page.html"} {
+		h.sidecarsFor(httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	// Made-up directories are looked up but never remembered
+	if len(h.sidecars.entries) != 2 {
+		t.Errorf("Expected only / and /blog to be cached, got %v", h.sidecars.entries)
+	}
+	if cfg := h.loadSidecar("/blog"); cfg == nil || cfg.IndexFiles[0] != "home.html" {
+		t.Errorf("Expected the /blog sidecar, got %+v", cfg)
+	}
+}
@@ -0,0 +1,61 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestSidecarConfig(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"index.html":          "<html>Root</html>",
+		"style.css":           "body {}",
+		"blog/.statiq":        `{"indexFiles": ["home.html"], "cacheControl": {".css": "no-cache"}, "errorPage404": "missing.html"}`,
+		"blog/home.html":      "<html>Blog</html>",
+		"blog/missing.html":   "<html>Blog post not found</html>",
+		"blog/style.css":      "body {}",
+		"blog/2024/style.css": "body {}",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.ReadSidecarConfig = true
+	cfg.CacheControl = map[string]string{".css": "max-age=86400"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/blog/", nil))
+	if location := recorder.Header().Get("Location"); location != "/blog/home.html" {
+		t.Errorf("Expected sidecar index file redirect, got %s", location)
+	}
+
+	// Overrides apply to the whole subtree, not just the sidecar's directory
+	for target, expected := range map[string]string{
+		"/style.css":           "max-age=86400",
+		"/blog/style.css":      "no-cache",
+		"/blog/2024/style.css": "no-cache",
+	} {
+		recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Cache-Control"); got != expected {
+			t.Errorf("Expected Cache-Control: %s for %s, got %s", expected, target, got)
+		}
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/blog/2024/nope.html", nil))
+	if !strings.Contains(recorder.Body.String(), "Blog post not found") {
+		t.Errorf("Expected sidecar 404 page, got %s", recorder.Body.String())
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/blog/.statiq", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected sidecar file to be hidden, got %d", recorder.Code)
+	}
+}
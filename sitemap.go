@@ -0,0 +1,157 @@
+package statiq
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sitemapPath is where the generated sitemap is served
+const sitemapPath = "/sitemap.xml"
+
+// sitemapMaxURLs is the most URLs a single sitemap may list (https://www.sitemaps.org/protocol.html)
+const sitemapMaxURLs = 50000
+
+// sitemapCacheTTL is how long a generated sitemap is reused before the root is walked again
+const sitemapCacheTTL = 5 * time.Minute
+
+// Entries for files without a SitemapChangeFreq or SitemapPriority mapping
+const (
+	defaultSitemapChangeFreq = "weekly"
+	defaultSitemapPriority   = 0.5
+)
+
+// sitemapChangeFreqs are the <changefreq> values the protocol defines
+var sitemapChangeFreqs = map[string]bool{
+	"always": true, "hourly": true, "daily": true, "weekly": true, "monthly": true, "yearly": true, "never": true,
+}
+
+// sitemapEntry is one <url> of the sitemap
+type sitemapEntry struct {
+	loc        string
+	modTime    time.Time
+	changeFreq string
+	priority   float64
+}
+
+// sitemapCache holds the entries of the last walk of the root
+type sitemapCache struct {
+	mu      sync.Mutex
+	entries []sitemapEntry
+	expires time.Time
+}
+
+// inSitemap reports whether files with the extension are listed: HTML pages
+// always, other files when SitemapChangeFreq or SitemapPriority maps them
+func (h *StatiqHandler) inSitemap(ext string) bool {
+	if ext == ".html" || ext == ".htm" {
+		return true
+	}
+	_, freq := h.sitemapChangeFreq[ext]
+	_, priority := h.sitemapPriority[ext]
+	return freq || priority
+}
+
+// sitemapEntries walks the root, skipping hidden paths. Index pages are
+// listed under their directory's URL.
+func (h *StatiqHandler) sitemapEntries() []sitemapEntry {
+	var entries []sitemapEntry
+	dirs := []string{"/"}
+	for len(dirs) > 0 && len(entries) < sitemapMaxURLs {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		f, err := h.root.Open(dir)
+		if err != nil {
+			continue
+		}
+		infos, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+		for _, info := range infos {
+			name := path.Join(dir, info.Name())
+			if h.hidesDotPath(name) || h.readSidecars && info.Name() == sidecarFileName {
+				continue
+			}
+			if info.IsDir() {
+				dirs = append(dirs, name)
+				continue
+			}
+
+			ext := strings.ToLower(path.Ext(name))
+			if !h.inSitemap(ext) {
+				continue
+			}
+			loc := name
+			if strings.TrimSuffix(info.Name(), ext) == "index" {
+				loc = strings.TrimSuffix(name, info.Name())
+			}
+
+			entry := sitemapEntry{loc: loc, modTime: info.ModTime(), changeFreq: defaultSitemapChangeFreq, priority: defaultSitemapPriority}
+			if freq, ok := h.sitemapChangeFreq[ext]; ok {
+				entry.changeFreq = freq
+			}
+			if priority, ok := h.sitemapPriority[ext]; ok {
+				entry.priority = priority
+			}
+			entries = append(entries, entry)
+			if len(entries) == sitemapMaxURLs {
+				break
+			}
+		}
+	}
+	return entries
+}
+
+// cachedSitemapEntries returns the sitemap entries, walking the root at most
+// once per sitemapCacheTTL. Concurrent requests wait for a single walk.
+func (h *StatiqHandler) cachedSitemapEntries() []sitemapEntry {
+	c := &h.sitemapCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := time.Now(); now.After(c.expires) {
+		c.entries = h.sitemapEntries()
+		c.expires = now.Add(sitemapCacheTTL)
+	}
+	return c.entries
+}
+
+// serveSitemap writes the sitemap with absolute URLs on SitemapBaseURL. The
+// request's Host and forwarded headers are never used, so clients cannot
+// make it point elsewhere.
+func (h *StatiqHandler) serveSitemap(w http.ResponseWriter, r *http.Request) {
+	entries := h.cachedSitemapEntries()
+	base := h.sitemapBaseURL + h.stripPrefix
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	out.WriteString(xml.Header)
+	out.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, e := range entries {
+		out.WriteString("  <url>\n    <loc>")
+		xml.EscapeText(out, []byte(base+(&url.URL{Path: e.loc}).EscapedPath()))
+		fmt.Fprintf(out, "</loc>\n    <lastmod>%s</lastmod>\n", e.modTime.UTC().Format(time.RFC3339))
+		fmt.Fprintf(out, "    <changefreq>%s</changefreq>\n", e.changeFreq)
+		fmt.Fprintf(out, "    <priority>%s</priority>\n  </url>\n", strconv.FormatFloat(e.priority, 'f', -1, 64))
+	}
+	out.WriteString("</urlset>\n")
+}
@@ -1,235 +1,2388 @@
 package statiq
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"hash"
 	"html/template"
+	"io"
 	"io/fs"
+	"log"
+	"math/big"
 	"mime"
+	"net"
 	"net/http"
-	"io"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Config the plugin configuration.
-type Config struct {
-	// Root directory to serve files from
-	Root string `json:"root,omitempty"`
+// Config the plugin configuration.
+type Config struct {
+	// Root directory to serve files from
+	Root string `json:"root,omitempty"`
+
+	// EnableDirectoryListing enables directory listing
+	EnableDirectoryListing bool `json:"enableDirectoryListing,omitempty"`
+
+	// IndexFiles is a list of filenames to try when a directory is requested
+	IndexFiles []string `json:"indexFiles,omitempty"`
+
+	// SPAMode redirects all not-found requests to a single page
+	SPAMode bool `json:"spaMode,omitempty"`
+
+	// SPAIndex is the file to serve in SPA mode
+	SPAIndex string `json:"spaIndex,omitempty"`
+
+	// ErrorPage404 is the path to a custom 404 error page
+	ErrorPage404 string `json:"errorPage404,omitempty"`
+
+	// CacheControl sets cache control headers for static files
+	//
+	// Deprecated: use CachePolicies, which take precedence for the same extension.
+	CacheControl map[string]string `json:"cacheControl,omitempty"`
+
+	// FileSizeHumanReadable formats directory listing sizes as "1.2 MB" instead of raw byte counts
+	FileSizeHumanReadable bool `json:"fileSizeHumanReadable,omitempty"`
+
+	// IndexForExtensions lists request extensions ("" for extension-less paths) for which a
+	// directory's index file is served at the original URL instead of redirecting to the slash form
+	IndexForExtensions []string `json:"indexForExtensions,omitempty"`
+
+	// NoCacheExtensions lists file extensions that are always served with Cache-Control: no-store
+	NoCacheExtensions []string `json:"noCacheExtensions,omitempty"`
+
+	// FeedbackPage is the URL of a feedback service linked from a widget injected into HTML pages
+	FeedbackPage string `json:"feedbackPage,omitempty"`
+
+	// ServiceWorkerScript is the path (relative to root) of a service worker registered from HTML pages
+	ServiceWorkerScript string `json:"serviceWorkerScript,omitempty"`
+
+	// LegacyBrowserRedirect is the URL old browsers are redirected to
+	LegacyBrowserRedirect string `json:"legacyBrowserRedirect,omitempty"`
+
+	// LegacyBrowserUA lists User-Agent substrings that identify legacy browsers
+	LegacyBrowserUA []string `json:"legacyBrowserUA,omitempty"`
+
+	// SecurityHeaders configures security-related response headers
+	SecurityHeaders SecurityHeaders `json:"securityHeaders,omitempty"`
+
+	// HSTSPreload appends the preload directive to the Strict-Transport-Security header
+	HSTSPreload bool `json:"hstsPreload,omitempty"`
+
+	// Redirects maps request paths to redirect targets; chained local rules are resolved in one hop
+	Redirects map[string]string `json:"redirects,omitempty"`
+
+	// MaxRedirects caps how many redirect rules are evaluated for one request before answering 508
+	MaxRedirects int `json:"maxRedirects,omitempty"`
+
+	// IgnoreQueryOnRedirect drops query parameters from canonicalisation redirects (e.g. /about/ → /about/index.html)
+	IgnoreQueryOnRedirect bool `json:"ignoreQueryOnRedirect,omitempty"`
+
+	// IgnoreTrailingSlashForFiles serves /file.txt/ the same as /file.txt when the path names a regular file.
+	// It defaults to true; set it to false to answer such paths with a 404.
+	IgnoreTrailingSlashForFiles bool `json:"ignoreTrailingSlashForFiles,omitempty"`
+
+	// TrustXRealIP takes the client IP from X-Real-IP (then X-Forwarded-For) instead of the connection address
+	TrustXRealIP bool `json:"trustXRealIP,omitempty"`
+
+	// RequestBodyLimit caps the size of incoming request bodies in bytes (0 disables the limit)
+	RequestBodyLimit int64 `json:"requestBodyLimit,omitempty"`
+
+	// ReadSidecarConfig applies per-directory overrides from .statiq JSON files to their subtree
+	ReadSidecarConfig bool `json:"readSidecarConfig,omitempty"`
+
+	// RandomDefaultFile serves a random file from a directory that has no index when listing is disabled
+	RandomDefaultFile bool `json:"randomDefaultFile,omitempty"`
+
+	// ContentLengthThreshold omits Content-Length for files larger than this many bytes (0 always sets it)
+	ContentLengthThreshold int64 `json:"contentLengthThreshold,omitempty"`
+
+	// DisableLastModified suppresses the Last-Modified header so deployments cannot be fingerprinted
+	DisableLastModified bool `json:"disableLastModified,omitempty"`
+
+	// AutoExpireOldVersions caches assets under version path components older than CurrentVersion forever
+	AutoExpireOldVersions bool `json:"autoExpireOldVersions,omitempty"`
+
+	// CurrentVersion is the live asset version, e.g. "v3" or "v2.1"
+	CurrentVersion string `json:"currentVersion,omitempty"`
+
+	// HeadlessMode serves only the inner HTML of <body> for HTML files, for fragments fetched by a parent SPA
+	HeadlessMode bool `json:"headlessMode,omitempty"`
+
+	// AccessControlExposeHeaders lists response headers that cross-origin JavaScript may read
+	AccessControlExposeHeaders []string `json:"accessControlExposeHeaders,omitempty"`
+
+	// OptionsResponse is the body returned for OPTIONS requests (empty answers 204 No Content)
+	OptionsResponse string `json:"optionsResponse,omitempty"`
+
+	// TLSClientCert requires a client certificate signed by TLSClientCACert for every request
+	TLSClientCert bool `json:"tlsClientCert,omitempty"`
+
+	// TLSClientCACert is the path to the PEM bundle of CAs trusted to sign client certificates
+	TLSClientCACert string `json:"tlsClientCACert,omitempty"`
+
+	// TrustForwardedClientCert accepts client certificates from the header set by Traefik's passTLSClientCert middleware
+	TrustForwardedClientCert bool `json:"trustForwardedClientCert,omitempty"`
+
+	// PerPathCacheControl sets Cache-Control by URL pattern; the first matching rule wins over extension rules
+	PerPathCacheControl []PathCacheRule `json:"perPathCacheControl,omitempty"`
+
+	// ETagForDirs sets an ETag on directory listings and answers 304 when the directory is unchanged
+	ETagForDirs bool `json:"etagForDirs,omitempty"`
+
+	// ContentNegotiationJSON serves /data.json for extension-less requests to /data that accept application/json
+	ContentNegotiationJSON bool `json:"contentNegotiationJSON,omitempty"`
+
+	// MiddlewareChain wraps the handler with additional middlewares, the first element outermost
+	MiddlewareChain []Middleware `json:"-"`
+
+	// SPAPrerender serves headless-browser snapshots of SPA routes to crawlers
+	SPAPrerender bool `json:"spaPrerender,omitempty"`
+
+	// PrerenderCachePath is the directory where prerendered snapshots are stored
+	PrerenderCachePath string `json:"prerenderCachePath,omitempty"`
+
+	// PrerenderOrigin is the scheme and host (e.g. https://example.com) the browser renders SPA routes from
+	PrerenderOrigin string `json:"prerenderOrigin,omitempty"`
+
+	// PrerenderBrowser is the headless Chromium executable used to render snapshots
+	PrerenderBrowser string `json:"prerenderBrowser,omitempty"`
+
+	// PrerenderRoutes are the URL prefixes or globs of the SPA routes that are rendered for crawlers
+	PrerenderRoutes []string `json:"prerenderRoutes,omitempty"`
+
+	// PrerenderConcurrency is the maximum number of browsers rendering at once
+	PrerenderConcurrency int `json:"prerenderConcurrency,omitempty"`
+
+	// PrerenderMaxSnapshots is the maximum number of snapshots stored in PrerenderCachePath
+	PrerenderMaxSnapshots int `json:"prerenderMaxSnapshots,omitempty"`
+
+	// DetachLargeFileSend streams files larger than DetachThreshold from a hijacked connection in its own goroutine
+	DetachLargeFileSend bool `json:"detachLargeFileSend,omitempty"`
+	// DetachThreshold is the file size in bytes above which sends are detached
+	DetachThreshold int64 `json:"detachThreshold,omitempty"`
+
+	// HealthCheckFile is a file on disk whose contents are served as the JSON health document
+	HealthCheckFile string `json:"healthCheckFile,omitempty"`
+	// HealthCheckPath is the URL path of the health endpoint
+	HealthCheckPath string `json:"healthCheckPath,omitempty"`
+
+	// AccessLog writes one line per request to AccessLogPath or AccessLogWriter
+	AccessLog bool `json:"accessLog,omitempty"`
+	// AccessLogWriter receives the access log when used as a Go library, defaulting to stdout
+	AccessLogWriter io.Writer `json:"-"`
+	// AccessLogPath is a file the access log is appended to, or "stdout" or "stderr"; writes are buffered and flushed every second
+	AccessLogPath string `json:"accessLogPath,omitempty"`
+	// AccessLogFormat is "json" (the default), "clf", "combined" or a text/template executed for each entry
+	AccessLogFormat string `json:"accessLogFormat,omitempty"`
+	// LogRequestHeaders lists request headers to include in access log entries; Authorization is always redacted
+	LogRequestHeaders []string `json:"logRequestHeaders,omitempty"`
+
+	// HotReplaceFiles serves a replacement file (relative to Root) for the mapped request paths
+	HotReplaceFiles map[string]string `json:"hotReplaceFiles,omitempty"`
+	// HotReplacer lets Go users swap the HotReplaceFiles map at runtime; it is seeded with HotReplaceFiles when both are set
+	HotReplacer *HotReplacer `json:"-"`
+
+	// VersionFile is a file name (e.g. "version.json") answered with generated build information when it does not exist on disk
+	VersionFile string `json:"versionFile,omitempty"`
+
+	// TLSMinVersion is the minimum TLS version ("1.2", "1.3") accepted by ListenAndServeTLS, defaulting to 1.2
+	TLSMinVersion string `json:"tlsMinVersion,omitempty"`
+
+	// ReadDirCacheTTL caches directory listings for the given duration; zero disables the cache
+	ReadDirCacheTTL time.Duration `json:"readDirCacheTTL,omitempty"`
+
+	// DirectoryListingMimeIcons shows an icon per MIME category in directory listings
+	DirectoryListingMimeIcons bool `json:"directoryListingMimeIcons,omitempty"`
+	// DirectoryListingMimeIconMap overrides or extends the icons, keyed by MIME type, "type/*" or "*"
+	DirectoryListingMimeIconMap map[string]string `json:"directoryListingMimeIconMap,omitempty"`
+
+	// ProxyHeaders sets X-Served-By (and X-Plugin-Version when built with a version) on every response
+	ProxyHeaders bool `json:"proxyHeaders,omitempty"`
+
+	// MinifyHTML strips comments and insignificant whitespace from HTML responses
+	MinifyHTML bool `json:"minifyHTML,omitempty"`
+	// MinifyHTMLThreshold is the largest HTML file in bytes that is minified
+	MinifyHTMLThreshold int64 `json:"minifyHTMLThreshold,omitempty"`
+
+	// MinifyCSS streams stylesheets through a CSS minifier
+	MinifyCSS bool `json:"minifyCSS,omitempty"`
+	// MinifySkipPattern lists globs of files that are never minified; patterns without a slash match the file name
+	MinifySkipPattern []string `json:"minifySkipPattern,omitempty"`
+
+	// MinifyJS serves JavaScript files minified
+	MinifyJS bool `json:"minifyJS,omitempty"`
+	// TransformCacheSize bounds the in-memory LRU of transformed responses in bytes; zero disables it
+	TransformCacheSize int64 `json:"transformCacheSize,omitempty"`
+
+	// ImageOptimize re-encodes JPEG and PNG images when that makes them smaller
+	ImageOptimize bool `json:"imageOptimize,omitempty"`
+	// ImageQuality is the JPEG quality used when re-encoding images
+	ImageQuality int `json:"imageQuality,omitempty"`
+
+	// WebPConvert serves JPEG and PNG images as lossless WebP to clients that accept it, when that is smaller
+	WebPConvert bool `json:"webpConvert,omitempty"`
+
+	// ImageResize serves images scaled down to fit the ?w= and ?h= query parameters
+	ImageResize bool `json:"imageResize,omitempty"`
+	// ImageMaxWidth caps the width that can be requested from ImageResize
+	ImageMaxWidth int `json:"imageMaxWidth,omitempty"`
+	// ImageMaxHeight caps the height that can be requested from ImageResize
+	ImageMaxHeight int `json:"imageMaxHeight,omitempty"`
+	// ImageCachePath is the directory where resized images are cached
+	ImageCachePath string `json:"imageCachePath,omitempty"`
+
+	// GrayscaleImages serves grayscale variants of JPEG and PNG images requested with ?gray=1
+	GrayscaleImages bool `json:"grayscaleImages,omitempty"`
+
+	// ThumbnailDir enables image thumbnails in directory listings, cached in this directory
+	ThumbnailDir string `json:"thumbnailDir,omitempty"`
+
+	// ETagAlgorithm hashes file size and modification time into ETags: "crc32" (default), "sha1" or "md5"
+	ETagAlgorithm string `json:"etagAlgorithm,omitempty"`
+
+	// RequestCountLimit caps the number of requests served concurrently; excess requests get 503 (0 means unlimited)
+	RequestCountLimit int `json:"requestCountLimit,omitempty"`
+
+	// RejectLargeURI answers 414 to requests whose URI is longer than MaxURILength
+	RejectLargeURI bool `json:"rejectLargeURI,omitempty"`
+	// MaxURILength is the longest request URI accepted with RejectLargeURI, in bytes
+	MaxURILength int `json:"maxURILength,omitempty"`
+
+	// PreCompressed serves file.br or file.gz instead of file to clients accepting that encoding
+	PreCompressed bool `json:"preCompressed,omitempty"`
+
+	// BlockedUserAgents are regular expressions; requests whose User-Agent matches one get 403
+	BlockedUserAgents []string `json:"blockedUserAgents,omitempty"`
+
+	// Gzip compresses text-like responses on the fly for clients accepting gzip
+	Gzip bool `json:"gzip,omitempty"`
+	// GzipMinSize is the smallest file compressed with Gzip or Brotli, in bytes
+	GzipMinSize int64 `json:"gzipMinSize,omitempty"`
+	// GzipLevel is the gzip compression level, from 1 (fastest) to 9 (smallest)
+	GzipLevel int `json:"gzipLevel,omitempty"`
+
+	// SlowloadSimulation delays every response by the given duration to mimic a slow
+	// network during development. It must never be set in production.
+	SlowloadSimulation time.Duration `json:"slowloadSimulation,omitempty"`
+
+	// Brotli compresses text-like responses on the fly for clients accepting br, preferred over Gzip
+	Brotli bool `json:"brotli,omitempty"`
+	// BrotliQuality trades CPU for compression ratio, from 0 (fastest) to 11 (smallest)
+	BrotliQuality int `json:"brotliQuality,omitempty"`
+
+	// MimeTypes maps file extensions to content types for this instance, shadowing the system MIME database
+	MimeTypes map[string]string `json:"mimeTypes,omitempty"`
+
+	// ServeFromMemory loads the whole root into memory at startup and serves it from there;
+	// later changes on disk are not picked up until the plugin restarts
+	ServeFromMemory bool `json:"serveFromMemory,omitempty"`
+
+	// MemFSMaxSizeMB caps the files loaded by ServeFromMemory; files beyond it are served from disk (0 means unlimited)
+	MemFSMaxSizeMB int `json:"memFSMaxSizeMB,omitempty"`
+
+	// ErrorPage403 is the path to a custom 403 error page, served with status 403
+	ErrorPage403 string `json:"errorPage403,omitempty"`
+	// ErrorPage500 is the path to a custom 500 error page, served with status 500
+	ErrorPage500 string `json:"errorPage500,omitempty"`
+
+	// Headers sets response headers by URL pattern and file extension; extension rules win over path-only rules
+	Headers []HeaderRule `json:"headers,omitempty"`
+
+	// OnNotFound answers requests for missing files when used as a Go library, replacing the 404 response and errorPage404; SPA mode takes precedence
+	OnNotFound func(w http.ResponseWriter, r *http.Request) `json:"-"`
+
+	// OnError answers failed requests when used as a Go library, receiving the error and the intended status code instead of the default error response
+	OnError func(w http.ResponseWriter, r *http.Request, err error, status int) `json:"-"`
+
+	// HideDotFiles answers 404 for paths with a segment starting with a dot and leaves dot-files out of listings
+	HideDotFiles bool `json:"hideDotFiles,omitempty"`
+
+	// AllowDotFiles lists dot-file and dot-directory names served despite HideDotFiles, such as .well-known
+	AllowDotFiles []string `json:"allowDotFiles,omitempty"`
+
+	// SyncOnShutdown fsyncs the image, thumbnail and prerender cache files written since startup once the plugin context ends
+	SyncOnShutdown bool `json:"syncOnShutdown,omitempty"`
+
+	// TraceHeaders echoes W3C traceparent and tracestate headers on responses, starting a trace when none is present, and logs the trace ID
+	TraceHeaders bool `json:"traceHeaders,omitempty"`
+
+	// CachePolicies sets Cache-Control by extension ("*" for all files) from structured directives, overriding CacheControl
+	CachePolicies map[string]CachePolicy `json:"cachePolicies,omitempty"`
+
+	// OpenTelemetry exports a span per request to an OTLP/HTTP collector, with method, path, status and file attributes
+	OpenTelemetry bool `json:"openTelemetry,omitempty"`
+
+	// OTLPEndpoint is the collector's OTLP/HTTP traces URL, defaulting to http://localhost:4318/v1/traces
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+
+	// SPARoutes serve a different SPA index for missing paths below each prefix, longest prefix first, before falling back to SPAIndex in SPA mode
+	SPARoutes []SPARoute `json:"spaRoutes,omitempty"`
+
+	// PrometheusPath serves request, byte, latency and transform cache metrics in the Prometheus text format at this path
+	PrometheusPath string `json:"prometheusPath,omitempty"`
+
+	// RedirectMap is a JSON file mapping request paths to a target string (301) or a {target, statusCode} object, reloaded when it changes
+	RedirectMap string `json:"redirectMap,omitempty"`
+
+	// StatsdAddress is a host:port StatsD server that receives request count, status, bytes and response time metrics over UDP
+	StatsdAddress string `json:"statsdAddress,omitempty"`
+
+	// StatsdPrefix is prepended to StatsD metric names, defaulting to "statiq"
+	StatsdPrefix string `json:"statsdPrefix,omitempty"`
+
+	// CORSAllowPrivateNetwork answers preflights carrying Access-Control-Request-Private-Network: true with Access-Control-Allow-Private-Network: true
+	CORSAllowPrivateNetwork bool `json:"corsAllowPrivateNetwork,omitempty"`
+
+	// ServiceMeshHeaders continues incoming Zipkin B3 traces (x-b3-traceid, x-b3-sampled) on responses with a new x-b3-spanid
+	ServiceMeshHeaders bool `json:"serviceMeshHeaders,omitempty"`
+
+	// MetricsPath serves the same Prometheus metrics as PrometheusPath at a second path, such as /metrics
+	MetricsPath string `json:"metricsPath,omitempty"`
+
+	// HEADOnlyMode answers GET requests with headers only, as for HEAD, and refuses every other method with 405
+	HEADOnlyMode bool `json:"headOnlyMode,omitempty"`
+
+	// CSP is the Content-Security-Policy sent with every text/html response
+	CSP string `json:"csp,omitempty"`
+
+	// CSPReportOnly sends CSP as Content-Security-Policy-Report-Only so violations are reported but not blocked
+	CSPReportOnly bool `json:"cspReportOnly,omitempty"`
+
+	// CORS allows the listed origins to read responses cross-origin and answers their preflights
+	CORS CORS `json:"cors,omitempty"`
+
+	// RequiredQueryParam names a query parameter every request must carry with RequiredQueryValue, such as key for ?key=secret
+	RequiredQueryParam string `json:"requiredQueryParam,omitempty"`
+
+	// RequiredQueryValue is the value RequiredQueryParam must have; other requests get 403
+	RequiredQueryValue string `json:"requiredQueryValue,omitempty"`
+
+	// ExplicitCacheHeaders drops the max-age=86400 fallback, so only files matching a cache rule get Cache-Control
+	ExplicitCacheHeaders bool `json:"explicitCacheHeaders,omitempty"`
+
+	// BasicAuth requires HTTP basic authentication with the users of an htpasswd file
+	BasicAuth BasicAuth `json:"basicAuth,omitempty"`
+
+	// IPAllowList restricts access to client IPs in these CIDR ranges (IPv4 or IPv6); others get 403
+	IPAllowList []string `json:"ipAllowList,omitempty"`
+
+	// IPDenyList refuses client IPs in these CIDR ranges with 403, even when they are also allowed
+	IPDenyList []string `json:"ipDenyList,omitempty"`
+
+	// TrustedProxies lists the CIDR ranges of proxies whose X-Forwarded-For hops are believed when finding the client IP
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+
+	// GlobalRateLimit caps the requests served per minute across all clients; the rest of the minute gets 503 (0 means unlimited)
+	GlobalRateLimit int `json:"globalRateLimit,omitempty"`
+
+	// CacheKeyIncludeHeaders adds these request header values to the transform cache key and to Vary, so for example each Accept-Language gets its own entry
+	CacheKeyIncludeHeaders []string `json:"cacheKeyIncludeHeaders,omitempty"`
+
+	// FollowSymlinks is none (default) to refuse paths through symbolic links with 403, same-root to follow links whose target stays inside Root, or any
+	FollowSymlinks string `json:"followSymlinks,omitempty"`
+
+	// TrailingSlash is redirect (default) to add the slash to directory paths, none to serve directory indexes without redirecting, or strip to redirect other paths ending in / to the path without it
+	TrailingSlash string `json:"trailingSlash,omitempty"`
+
+	// ExpandAlternateFiles serves extension-less paths such as /audio/track from the variant AlternateExtensions picks for the Accept header
+	ExpandAlternateFiles bool `json:"expandAlternateFiles,omitempty"`
+
+	// AlternateExtensions lists, per Accept media range ("audio/ogg", "audio/*", "*/*"), the extensions tried in order
+	AlternateExtensions map[string][]string `json:"alternateExtensions,omitempty"`
+
+	// Sitemap generates /sitemap.xml from the HTML pages of the root, unless the root has its own
+	Sitemap bool `json:"sitemap,omitempty"`
+
+	// SitemapChangeFreq sets <changefreq> by extension (default weekly); mapped extensions other than HTML are listed too
+	SitemapChangeFreq map[string]string `json:"sitemapChangeFreq,omitempty"`
+
+	// SitemapPriority sets <priority> by extension (default 0.5); mapped extensions other than HTML are listed too
+	SitemapPriority map[string]float64 `json:"sitemapPriority,omitempty"`
+
+	// SitemapBaseURL is the scheme and host (e.g. https://example.com) the sitemap URLs are built on
+	SitemapBaseURL string `json:"sitemapBaseURL,omitempty"`
+
+	// StripPrefix is removed from request paths before they are resolved below Root, so /static/app.js serves Root/app.js
+	StripPrefix string `json:"stripPrefix,omitempty"`
+
+	// PassThrough hands requests outside StripPrefix to the next handler instead of answering 404
+	PassThrough bool `json:"passThrough,omitempty"`
+
+	// SPAIndexHeaders are set on responses the SPA fallback serves the index for, overriding the cache and rule headers
+	SPAIndexHeaders map[string]string `json:"spaIndexHeaders,omitempty"`
+
+	// Aliases map request paths to files or directories below Root served in their place, e.g. /favicon.ico to /assets/images/favicon.ico
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	// LazyAliasCheck skips checking at startup that every alias target exists
+	LazyAliasCheck bool `json:"lazyAliasCheck,omitempty"`
+
+	// BackgroundCompressOnBoot writes .br and .gz sidecars for compressible files of at least GzipMinSize in the background at startup
+	BackgroundCompressOnBoot bool `json:"backgroundCompressOnBoot,omitempty"`
+
+	// ForceRecompress makes BackgroundCompressOnBoot replace existing sidecars
+	ForceRecompress bool `json:"forceRecompress,omitempty"`
+
+	// MemCache keeps recently served small files in memory, re-reading them once they change on disk
+	MemCache bool `json:"memCache,omitempty"`
+
+	// MemCacheMaxSize bounds the total size of the files in MemCache, in bytes
+	MemCacheMaxSize int `json:"memCacheMaxSize,omitempty"`
+
+	// MemCacheMaxFile is the size in bytes from which files are always read from disk
+	MemCacheMaxFile int `json:"memCacheMaxFile,omitempty"`
+
+	// ReadTimeout bounds every read of a file being served; responses whose file takes longer are aborted. Zero disables it
+	ReadTimeout time.Duration `json:"readTimeout,omitempty"`
+
+	// ETagSeed, such as a deployment timestamp, keys ETags with HMAC-SHA256 over URL path, modification time and size, so instances sharing it agree on them; it replaces ETagAlgorithm
+	ETagSeed string `json:"etagSeed,omitempty"`
+
+	// NegotiateLanguage serves /page.fr.html, then /page.html, for extension-less requests like /page by Accept-Language
+	NegotiateLanguage bool `json:"negotiateLanguage,omitempty"`
+
+	// PerExtensionMaxAge sets Cache-Control: public, max-age=<seconds> by extension ("*" for all files); CacheControl and CachePolicies take precedence for the same extension
+	PerExtensionMaxAge map[string]int `json:"perExtensionMaxAge,omitempty"`
+
+	// ArchiveRoot is a .zip, .tar.gz or .tgz file unpacked into memory at startup and served instead of Root
+	ArchiveRoot string `json:"archiveRoot,omitempty"`
+
+	// SymlinkChainLimit is the most symbolic links a request path may go through, so circular links are refused with 403
+	SymlinkChainLimit int `json:"symlinkChainLimit,omitempty"`
+
+	// HTTP2Push pushes the stylesheets, preloads, scripts and images HTML pages link to over HTTP/2
+	HTTP2Push bool `json:"http2Push,omitempty"`
+
+	// HTTP2PushMaxFiles caps the assets pushed per page
+	HTTP2PushMaxFiles int `json:"http2PushMaxFiles,omitempty"`
+
+	// CaseInsensitive serves files whose names differ from the request path in letter case only, logging a warning for each such match
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+}
+
+// SecurityHeaders configures security-related response headers.
+type SecurityHeaders struct {
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header in seconds (0 disables HSTS)
+	HSTSMaxAge int `json:"hstsMaxAge,omitempty"`
+
+	// HSTSIncludeSubdomains adds the includeSubDomains directive to the HSTS header
+	HSTSIncludeSubdomains bool `json:"hstsIncludeSubdomains,omitempty"`
+}
+
+// CORS configures cross-origin resource sharing for allowed origins.
+type CORS struct {
+	// AllowOrigins lists the origins allowed to read responses, such as
+	// "https://app.example.com", "https://*.example.com" or "*" for any
+	AllowOrigins []string `json:"allowOrigins,omitempty"`
+
+	// AllowMethods is sent in preflight responses (default GET, HEAD, OPTIONS)
+	AllowMethods []string `json:"allowMethods,omitempty"`
+
+	// AllowHeaders lists the request headers allowed in cross-origin requests
+	AllowHeaders []string `json:"allowHeaders,omitempty"`
+
+	// ExposeHeaders lists the response headers allowed origins may read,
+	// replacing AccessControlExposeHeaders for them
+	ExposeHeaders []string `json:"exposeHeaders,omitempty"`
+
+	// AllowCredentials lets allowed origins send cookies and authorization headers
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+
+	// MaxAge is how long in seconds browsers may cache a preflight response
+	MaxAge int `json:"maxAge,omitempty"`
+}
+
+// BasicAuth protects files with HTTP basic authentication.
+type BasicAuth struct {
+	// Realm is announced in the WWW-Authenticate challenge (default Restricted)
+	Realm string `json:"realm,omitempty"`
+
+	// HtpasswdFile lists the users as user:hash lines, hashed with bcrypt
+	// (htpasswd -B) or SHA1 (htpasswd -s)
+	HtpasswdFile string `json:"htpasswdFile,omitempty"`
+
+	// Exclude lists URL path prefixes served without authentication, such as /.well-known/
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Middleware wraps an http.Handler with additional behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// PathCacheRule sets the Cache-Control value for URL paths matching Pattern.
+type PathCacheRule struct {
+	// Pattern is a path prefix ("/assets/") or glob ("/api/**", "/*.json")
+	Pattern string `json:"pattern,omitempty"`
+
+	// Value is the Cache-Control header value
+	Value string `json:"value,omitempty"`
+}
+
+// SPARoute serves a single-page application's index for missing paths below Prefix.
+type SPARoute struct {
+	// Prefix is the URL path the application is mounted at, such as "/admin"
+	Prefix string `json:"prefix,omitempty"`
+
+	// Index is the application's index file, relative to the root
+	Index string `json:"index,omitempty"`
+}
+
+// HeaderRule sets response headers for files matching Pattern and Extension.
+type HeaderRule struct {
+	// Pattern is a path prefix ("/assets/") or glob ("/api/*"); empty matches every path
+	Pattern string `json:"pattern,omitempty"`
+
+	// Extension limits the rule to files with this extension (".html"); empty matches every file
+	Extension string `json:"extension,omitempty"`
+
+	// Values maps header names to the values to set
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// CachePolicy describes a Cache-Control header by its directives.
+type CachePolicy struct {
+	// MaxAge is the max-age in seconds
+	MaxAge int `json:"maxAge,omitempty"`
+
+	// Immutable tells clients the file never changes while fresh
+	Immutable bool `json:"immutable,omitempty"`
+
+	// NoStore forbids caching altogether and overrides every other field
+	NoStore bool `json:"noStore,omitempty"`
+
+	// NoCache requires revalidation before a cached copy is used
+	NoCache bool `json:"noCache,omitempty"`
+
+	// Public allows shared caches to store the response
+	Public bool `json:"public,omitempty"`
+}
+
+// String composes the Cache-Control header value, such as
+// "public, max-age=31536000, immutable"
+func (p CachePolicy) String() string {
+	if p.NoStore {
+		return "no-store"
+	}
+
+	var directives []string
+	if p.Public {
+		directives = append(directives, "public")
+	}
+	if p.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	if p.MaxAge > 0 || len(directives) == 0 {
+		directives = append(directives, fmt.Sprintf("max-age=%d", p.MaxAge))
+	}
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// hstsPreloadMinAge is the minimum max-age accepted by the HSTS preload list
+const hstsPreloadMinAge = 31536000
+
+// defaultMaxURILength is the URI length limit used when MaxURILength is unset
+const defaultMaxURILength = 4096
+
+// TrailingSlash behaviours
+const (
+	trailingSlashRedirect = "redirect"
+	trailingSlashNone     = "none"
+	trailingSlashStrip    = "strip"
+)
+
+// CreateConfig creates the default plugin configuration.
+func CreateConfig() *Config {
+	return &Config{
+		Root:                        ".",
+		EnableDirectoryListing:      false,
+		IndexFiles:                  []string{"index.html", "index.htm"},
+		SPAMode:                     false,
+		SPAIndex:                    "index.html",
+		ErrorPage404:                "",
+		CacheControl:                map[string]string{},
+		RequestBodyLimit:            1 << 20,
+		MaxRedirects:                defaultMaxRedirects,
+		PrerenderBrowser:            "chromium",
+		PrerenderConcurrency:        defaultPrerenderConcurrency,
+		PrerenderMaxSnapshots:       defaultPrerenderMaxSnapshots,
+		MinifyHTMLThreshold:         defaultMinifyHTMLThreshold,
+		TransformCacheSize:          32 << 20,
+		ImageQuality:                defaultImageQuality,
+		ImageMaxWidth:               defaultImageMaxDimension,
+		ImageMaxHeight:              defaultImageMaxDimension,
+		MaxURILength:                defaultMaxURILength,
+		GzipMinSize:                 defaultGzipMinSize,
+		BrotliQuality:               defaultBrotliQuality,
+		HideDotFiles:                true,
+		IgnoreTrailingSlashForFiles: true,
+		FollowSymlinks:              followSymlinksNone,
+		TrailingSlash:               trailingSlashRedirect,
+		MemCacheMaxSize:             defaultMemCacheMaxSize,
+		MemCacheMaxFile:             defaultMemCacheMaxFile,
+		SymlinkChainLimit:           defaultSymlinkChainLimit,
+		HTTP2PushMaxFiles:           defaultHTTP2PushMaxFiles,
+	}
+}
+
+// dirEntry represents a file or directory for the directory listing template
+type dirEntry struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+	// HumanSize is the formatted size, only set when human-readable sizes are enabled
+	HumanSize string
+	// Icon is the MIME category icon, only set when MIME icons are enabled
+	Icon string
+	// Thumbnail is the preview image URL, only set for images when thumbnails are enabled
+	Thumbnail string
+}
+
+// Initialize MIME types
+func init() {
+	// Register Go files as text/x-go to match standard behavior
+	mime.AddExtensionType(".go", "text/x-go")
+}
+
+// fileContentType returns the content type of a file, reusing the one cached
+// with in-memory files
+func (h *StatiqHandler) fileContentType(d fs.FileInfo) string {
+	if m, ok := d.(*memFileInfo); ok && m.contentType != "" {
+		return m.contentType
+	}
+	return h.typeByExtension(filepath.Ext(d.Name()))
+}
+
+// typeByExtension returns the content type for a file extension, preferring
+// the instance's MimeTypes over the global mime registry
+func (h *StatiqHandler) typeByExtension(ext string) string {
+	if contentType, ok := h.mimeTypes[strings.ToLower(ext)]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// StatiqHandler is a custom file server handler
+type StatiqHandler struct {
+	// root stays an http.FileSystem: the symlink, memory, archive and
+	// metrics layers wrap it, and an fs.FS from NewFromFS is adapted with http.FS
+	root                     http.FileSystem
+	rootPath                 string
+	enableDirListing         bool
+	indexFiles               []string
+	spaMode                  bool
+	spaIndex                 string
+	errorPage404             string
+	cacheControl             map[string]string
+	notFoundResponseCode     int
+	requestBodyLimit         int64
+	htmlInjections           []htmlInjection
+	serviceWorkerPath        string
+	noCacheExtensions        map[string]bool
+	legacyRedirect           string
+	legacyUA                 []string
+	indexForExtensions       map[string]bool
+	hsts                     string
+	humanFileSizes           bool
+	redirects                map[string]redirectRule
+	maxRedirects             int
+	ignoreRedirectQuery      bool
+	trustXRealIP             bool
+	ignoreFileSlash          bool
+	readSidecars             bool
+	sidecars                 sidecarCache
+	randomDefaultFile        bool
+	contentLengthThreshold   int64
+	disableLastModified      bool
+	currentVersion           []int
+	headlessMode             bool
+	exposeHeaders            string
+	optionsResponse          string
+	clientCAs                *x509.CertPool
+	trustForwardedCert       bool
+	pathCacheRules           []PathCacheRule
+	etagForDirs              bool
+	negotiateJSON            bool
+	spaPrerender             bool
+	prerenderCachePath       string
+	prerenderBrowser         string
+	prerenderOrigin          string
+	prerendering             sync.Map
+	prerenderRoutes          []string
+	prerenderSlots           chan struct{}
+	prerenderMaxSnapshots    int64
+	prerenderSnapshots       atomic.Int64
+	detachLargeFiles         bool
+	detachThreshold          int64
+	health                   *healthFile
+	healthPath               string
+	accessLog                *accessLogger
+	hotReplace               *HotReplacer
+	versionPath              string
+	readDirCacheTTL          time.Duration
+	readDirCache             sync.Map
+	mimeIcons                map[string]string
+	proxyHeaders             bool
+	minifyHTML               bool
+	minifyHTMLThreshold      int64
+	minifyCSS                bool
+	minifySkip               []string
+	minifyJS                 bool
+	transformCache           *lruCache
+	imageOptimize            bool
+	imageQuality             int
+	webpConvert              bool
+	imageResize              bool
+	imageMaxWidth            int
+	imageMaxHeight           int
+	imageCachePath           string
+	grayscaleImages          bool
+	thumbnailDir             string
+	etagHash                 func() hash.Hash
+	requestCountLimit        int64
+	inFlight                 atomic.Int64
+	maxURILength             int
+	preCompressed            bool
+	blockedUserAgents        []*regexp.Regexp
+	gzip                     bool
+	gzipMinSize              int64
+	gzipLevel                int
+	slowloadSimulation       time.Duration
+	brotli                   bool
+	brotliQuality            int
+	mimeTypes                map[string]string
+	errorPages               map[int]string
+	headerRules              []HeaderRule
+	onNotFound               func(w http.ResponseWriter, r *http.Request)
+	onError                  func(w http.ResponseWriter, r *http.Request, err error, status int)
+	hideDotFiles             bool
+	allowDotFiles            map[string]bool
+	syncOnShutdown           bool
+	cacheSince               time.Time
+	cacheWrites              atomic.Int64
+	cacheSynced              atomic.Bool
+	traceHeaders             bool
+	spans                    *spanExporter
+	spaRoutes                []SPARoute
+	prometheusPath           string
+	metrics                  *metrics
+	redirectMap              *redirectMap
+	statsd                   *statsdClient
+	corsAllowPrivateNetwork  bool
+	serviceMeshHeaders       bool
+	metricsPath              string
+	headOnlyMode             bool
+	csp                      string
+	cspReportOnly            bool
+	cors                     *corsPolicy
+	requiredQueryParam       string
+	requiredQueryValue       string
+	explicitCacheHeaders     bool
+	basicAuth                *basicAuth
+	ipAllowList              []*net.IPNet
+	ipDenyList               []*net.IPNet
+	trustedProxies           []*net.IPNet
+	globalRateLimit          *globalRateLimiter
+	cacheKeyHeaders          []string
+	followSymlinks           string
+	symlinks                 *symlinkPolicy
+	trailingSlash            string
+	alternateExtensions      map[string][]string
+	sitemap                  bool
+	sitemapChangeFreq        map[string]string
+	sitemapPriority          map[string]float64
+	sitemapBaseURL           string
+	sitemapCache             sitemapCache
+	next                     http.Handler
+	stripPrefix              string
+	passThrough              bool
+	spaIndexHeaders          map[string]string
+	aliases                  map[string]string
+	backgroundCompressOnBoot bool
+	precompressedFiles       atomic.Int64
+	precompressDone          atomic.Bool
+	readTimeout              time.Duration
+	etagSeeded               bool
+	negotiateLanguage        bool
+	http2Push                bool
+	http2PushMaxFiles        int
+	caseInsensitive          bool
+}
+
+// New creates a new Statiq plugin.
+// New creates a new Statiq plugin.
+func New(ctx context.Context, next http.Handler, config *Config, _ string) (http.Handler, error) {
+	handler, err := newHandler(ctx, next, config, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SyncOnShutdown {
+		go handler.syncOnDone(ctx)
+	}
+
+	// Return our custom handler
+	return handler.wrap(config), nil
+}
+
+// NewFromFS creates a new Statiq plugin serving fsys, such as an embed.FS,
+// instead of config.Root. The handler keeps serving an http.FileSystem
+// internally and reaches fsys through http.FS, the same layer http.Dir fills
+// for config.Root. Options that read or write the root directory
+// itself, serveFromMemory and backgroundCompressOnBoot, are refused. An
+// embed.FS has no modification times, so set ETagSeed to a build stamp to
+// get new ETags with every build.
+func NewFromFS(ctx context.Context, next http.Handler, config *Config, _ string, fsys fs.FS) (http.Handler, error) {
+	if fsys == nil {
+		return nil, fmt.Errorf("NewFromFS needs a file system")
+	}
+	handler, err := newHandler(ctx, next, config, fsys)
+	if err != nil {
+		return nil, err
+	}
+	if config.SyncOnShutdown {
+		go handler.syncOnDone(ctx)
+	}
+	return handler.wrap(config), nil
+}
+
+// newHandler builds the handler without the access log and middleware
+// wrappers, for callers that need its methods. It serves fsys when set and
+// config.Root otherwise.
+func newHandler(ctx context.Context, next http.Handler, config *Config, fsys fs.FS) (*StatiqHandler, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	var root string
+	var rootFS http.FileSystem
+	var err error
+	if fsys != nil || config.ArchiveRoot != "" {
+		if config.ServeFromMemory || config.BackgroundCompressOnBoot {
+			return nil, fmt.Errorf("serveFromMemory and backgroundCompressOnBoot need a root directory and cannot be used with NewFromFS or archiveRoot")
+		}
+		switch {
+		case fsys != nil && config.ArchiveRoot != "":
+			return nil, fmt.Errorf("archiveRoot cannot be used with NewFromFS")
+		case fsys != nil:
+			rootFS = newFSRoot(fsys)
+		default:
+			files, err := loadArchive(config.ArchiveRoot)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load archiveRoot: %w", err)
+			}
+			rootFS = files
+		}
+	} else {
+		// Ensure the root path is absolute
+		root, err = filepath.Abs(config.Root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root path: %w", err)
+		}
+		// Ensure the directory exists
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			if err := os.MkdirAll(root, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create root directory: %w", err)
+			}
+		}
+		rootFS = http.Dir(root)
+	}
+	// Custom 403 and 500 pages must exist up front since errors cannot wait for a fix
+	errorPages := map[int]string{}
+	for code, page := range map[int]string{http.StatusForbidden: config.ErrorPage403, http.StatusInternalServerError: config.ErrorPage500} {
+		if page == "" {
+			continue
+		}
+		if !isRegularFileIn(rootFS, path.Join("/", page)) {
+			return nil, fmt.Errorf("error page for %d not found: %s", code, page)
+		}
+		errorPages[code] = page
+	}
+
+	// Check if custom 404 page exists - also make this check optional
+	notFoundResponseCode := http.StatusNotFound
+	if config.ErrorPage404 != "" {
+		// We'll validate the error page at runtime instead of initialization time
+		notFoundResponseCode = http.StatusOK // We'll serve the error page with 200 OK
+	}
+
+	// The algorithm was checked by validateConfig
+	etagHash, _ := parseETagAlgorithm(config.ETagAlgorithm)
+	if config.ETagSeed != "" {
+		etagHash = seededETagHash(config.ETagSeed)
+	}
+
+	// Create a custom handler
+	handler := &StatiqHandler{
+		root:                     rootFS,
+		rootPath:                 root,
+		enableDirListing:         config.EnableDirectoryListing,
+		indexFiles:               config.IndexFiles,
+		spaMode:                  config.SPAMode,
+		spaIndex:                 config.SPAIndex,
+		errorPage404:             config.ErrorPage404,
+		cacheControl:             config.CacheControl,
+		notFoundResponseCode:     notFoundResponseCode,
+		requestBodyLimit:         config.RequestBodyLimit,
+		legacyRedirect:           config.LegacyBrowserRedirect,
+		legacyUA:                 config.LegacyBrowserUA,
+		humanFileSizes:           config.FileSizeHumanReadable,
+		maxRedirects:             config.MaxRedirects,
+		ignoreRedirectQuery:      config.IgnoreQueryOnRedirect,
+		trustXRealIP:             config.TrustXRealIP,
+		ignoreFileSlash:          config.IgnoreTrailingSlashForFiles,
+		readSidecars:             config.ReadSidecarConfig,
+		sidecars:                 sidecarCache{entries: map[string]sidecarEntry{}},
+		randomDefaultFile:        config.RandomDefaultFile,
+		contentLengthThreshold:   config.ContentLengthThreshold,
+		disableLastModified:      config.DisableLastModified,
+		headlessMode:             config.HeadlessMode,
+		exposeHeaders:            strings.Join(config.AccessControlExposeHeaders, ", "),
+		optionsResponse:          config.OptionsResponse,
+		trustForwardedCert:       config.TrustForwardedClientCert,
+		pathCacheRules:           config.PerPathCacheControl,
+		etagForDirs:              config.ETagForDirs,
+		negotiateJSON:            config.ContentNegotiationJSON,
+		spaPrerender:             config.SPAPrerender,
+		prerenderCachePath:       config.PrerenderCachePath,
+		prerenderBrowser:         config.PrerenderBrowser,
+		prerenderOrigin:          strings.TrimSuffix(config.PrerenderOrigin, "/"),
+		prerenderRoutes:          config.PrerenderRoutes,
+		prerenderMaxSnapshots:    int64(config.PrerenderMaxSnapshots),
+		detachLargeFiles:         config.DetachLargeFileSend,
+		detachThreshold:          config.DetachThreshold,
+		healthPath:               config.HealthCheckPath,
+		versionPath:              versionFilePath(config.VersionFile),
+		readDirCacheTTL:          config.ReadDirCacheTTL,
+		proxyHeaders:             config.ProxyHeaders,
+		minifyHTML:               config.MinifyHTML,
+		minifyHTMLThreshold:      config.MinifyHTMLThreshold,
+		minifyCSS:                config.MinifyCSS,
+		minifySkip:               config.MinifySkipPattern,
+		minifyJS:                 config.MinifyJS,
+		imageOptimize:            config.ImageOptimize,
+		imageQuality:             config.ImageQuality,
+		webpConvert:              config.WebPConvert,
+		imageResize:              config.ImageResize,
+		imageMaxWidth:            config.ImageMaxWidth,
+		imageMaxHeight:           config.ImageMaxHeight,
+		imageCachePath:           config.ImageCachePath,
+		grayscaleImages:          config.GrayscaleImages,
+		thumbnailDir:             config.ThumbnailDir,
+		etagHash:                 etagHash,
+		requestCountLimit:        int64(config.RequestCountLimit),
+		preCompressed:            config.PreCompressed,
+		gzip:                     config.Gzip,
+		gzipMinSize:              config.GzipMinSize,
+		gzipLevel:                config.GzipLevel,
+		slowloadSimulation:       config.SlowloadSimulation,
+		brotli:                   config.Brotli,
+		brotliQuality:            config.BrotliQuality,
+		errorPages:               errorPages,
+		headerRules:              config.Headers,
+		onNotFound:               config.OnNotFound,
+		onError:                  config.OnError,
+		hideDotFiles:             config.HideDotFiles,
+		syncOnShutdown:           config.SyncOnShutdown,
+		cacheSince:               time.Now().Truncate(time.Second),
+		traceHeaders:             config.TraceHeaders,
+		prometheusPath:           config.PrometheusPath,
+		corsAllowPrivateNetwork:  config.CORSAllowPrivateNetwork,
+		serviceMeshHeaders:       config.ServiceMeshHeaders,
+		metricsPath:              config.MetricsPath,
+		headOnlyMode:             config.HEADOnlyMode,
+		csp:                      config.CSP,
+		cspReportOnly:            config.CSPReportOnly,
+		requiredQueryParam:       config.RequiredQueryParam,
+		requiredQueryValue:       config.RequiredQueryValue,
+		explicitCacheHeaders:     config.ExplicitCacheHeaders,
+		followSymlinks:           config.FollowSymlinks,
+		trailingSlash:            config.TrailingSlash,
+		sitemap:                  config.Sitemap,
+		sitemapBaseURL:           strings.TrimSuffix(config.SitemapBaseURL, "/"),
+		next:                     next,
+		stripPrefix:              strings.TrimSuffix(config.StripPrefix, "/"),
+		passThrough:              config.PassThrough,
+		spaIndexHeaders:          config.SPAIndexHeaders,
+		backgroundCompressOnBoot: config.BackgroundCompressOnBoot,
+		readTimeout:              config.ReadTimeout,
+		etagSeeded:               config.ETagSeed != "",
+		negotiateLanguage:        config.NegotiateLanguage,
+		http2Push:                config.HTTP2Push,
+		http2PushMaxFiles:        config.HTTP2PushMaxFiles,
+		caseInsensitive:          config.CaseInsensitive,
+	}
+
+	if config.TLSClientCert {
+		if handler.clientCAs, err = loadClientCAs(config.TLSClientCACert); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.AutoExpireOldVersions {
+		handler.currentVersion, _ = parseVersion(config.CurrentVersion)
+	}
+
+	if config.AccessLog || config.AccessLogPath != "" {
+		accessLog, err := newAccessLogger(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		handler.accessLog = accessLog
+	}
+
+	handler.hotReplace = config.HotReplacer
+	if handler.hotReplace == nil {
+		handler.hotReplace = &HotReplacer{}
+	}
+	if len(config.HotReplaceFiles) > 0 {
+		handler.hotReplace.Set(config.HotReplaceFiles)
+	}
+
+	if config.DirectoryListingMimeIcons {
+		handler.mimeIcons = newMimeIcons(config.DirectoryListingMimeIconMap)
+	}
+
+	if config.HealthCheckFile != "" {
+		handler.health = newHealthFile(ctx, config.HealthCheckFile)
+		if handler.healthPath == "" {
+			handler.healthPath = defaultHealthCheckPath
+		}
+	}
+
+	if config.StatsdAddress != "" {
+		prefix := config.StatsdPrefix
+		if prefix == "" {
+			prefix = defaultStatsdPrefix
+		}
+		statsd, err := newStatsdClient(ctx, config.StatsdAddress, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid statsdAddress: %w", err)
+		}
+		handler.statsd = statsd
+	}
+
+	if config.OpenTelemetry {
+		endpoint := config.OTLPEndpoint
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+		handler.spans = newSpanExporter(ctx, endpoint)
+	}
+
+	if config.TransformCacheSize > 0 {
+		handler.transformCache = newLRUCache(config.TransformCacheSize)
+	}
+
+	if handler.imageQuality <= 0 {
+		handler.imageQuality = defaultImageQuality
+	}
+
+	if handler.imageMaxWidth <= 0 {
+		handler.imageMaxWidth = defaultImageMaxDimension
+	}
+
+	if handler.imageMaxHeight <= 0 {
+		handler.imageMaxHeight = defaultImageMaxDimension
+	}
+
+	if handler.minifyHTMLThreshold <= 0 {
+		handler.minifyHTMLThreshold = defaultMinifyHTMLThreshold
+	}
+
+	if handler.prerenderBrowser == "" {
+		handler.prerenderBrowser = "chromium"
+	}
+
+	if handler.spaPrerender {
+		concurrency := config.PrerenderConcurrency
+		if concurrency <= 0 {
+			concurrency = defaultPrerenderConcurrency
+		}
+		handler.prerenderSlots = make(chan struct{}, concurrency)
+
+		if handler.prerenderMaxSnapshots <= 0 {
+			handler.prerenderMaxSnapshots = defaultPrerenderMaxSnapshots
+		}
+		handler.prerenderSnapshots.Store(countPrerenderSnapshots(handler.prerenderCachePath))
+	}
+
+	if handler.gzipMinSize <= 0 {
+		handler.gzipMinSize = defaultGzipMinSize
+	}
+
+	if handler.http2PushMaxFiles <= 0 {
+		handler.http2PushMaxFiles = defaultHTTP2PushMaxFiles
+	}
+
+	if handler.gzipLevel == 0 {
+		handler.gzipLevel = gzip.DefaultCompression
+	}
+
+	if handler.maxRedirects <= 0 {
+		handler.maxRedirects = defaultMaxRedirects
+	}
+
+	if config.RejectLargeURI {
+		handler.maxURILength = config.MaxURILength
+		if handler.maxURILength <= 0 {
+			handler.maxURILength = defaultMaxURILength
+		}
+	}
+
+	if len(config.Redirects) > 0 {
+		handler.redirects = make(map[string]redirectRule, len(config.Redirects))
+		for from, to := range config.Redirects {
+			handler.redirects[from] = redirectRule{Target: to, StatusCode: http.StatusMovedPermanently}
+		}
+	}
+
+	if config.RedirectMap != "" {
+		redirects, err := loadRedirectMap(ctx, config.RedirectMap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redirect map: %w", err)
+		}
+		handler.redirectMap = redirects
+	}
+
+	if config.SecurityHeaders.HSTSMaxAge > 0 {
+		handler.hsts = fmt.Sprintf("max-age=%d", config.SecurityHeaders.HSTSMaxAge)
+		if config.SecurityHeaders.HSTSIncludeSubdomains {
+			handler.hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			handler.hsts += "; preload"
+		}
+	}
+
+	if len(config.IndexForExtensions) > 0 {
+		handler.indexForExtensions = make(map[string]bool, len(config.IndexForExtensions))
+		for _, ext := range config.IndexForExtensions {
+			handler.indexForExtensions[ext] = true
+		}
+	}
+
+	if len(config.CachePolicies) > 0 || len(config.PerExtensionMaxAge) > 0 {
+		handler.cacheControl = make(map[string]string, len(config.PerExtensionMaxAge)+len(config.CacheControl)+len(config.CachePolicies))
+		for ext, seconds := range config.PerExtensionMaxAge {
+			handler.cacheControl[ext] = fmt.Sprintf("public, max-age=%d", seconds)
+		}
+		for ext, value := range config.CacheControl {
+			handler.cacheControl[ext] = value
+		}
+		for ext, policy := range config.CachePolicies {
+			handler.cacheControl[ext] = policy.String()
+		}
+	}
+
+	if len(config.SPARoutes) > 0 {
+		// Longer prefixes are more specific and are tried first
+		handler.spaRoutes = append([]SPARoute(nil), config.SPARoutes...)
+		sort.SliceStable(handler.spaRoutes, func(i, j int) bool {
+			return len(strings.TrimSuffix(handler.spaRoutes[i].Prefix, "/")) > len(strings.TrimSuffix(handler.spaRoutes[j].Prefix, "/"))
+		})
+	}
+
+	if len(config.AllowDotFiles) > 0 {
+		handler.allowDotFiles = make(map[string]bool, len(config.AllowDotFiles))
+		for _, name := range config.AllowDotFiles {
+			handler.allowDotFiles[name] = true
+		}
+	}
+
+	if len(config.NoCacheExtensions) > 0 {
+		handler.noCacheExtensions = make(map[string]bool, len(config.NoCacheExtensions))
+		for _, ext := range config.NoCacheExtensions {
+			handler.noCacheExtensions[ext] = true
+		}
+	}
+
+	for _, list := range []struct {
+		name   string
+		values []string
+		nets   *[]*net.IPNet
+	}{
+		{"ipAllowList", config.IPAllowList, &handler.ipAllowList},
+		{"ipDenyList", config.IPDenyList, &handler.ipDenyList},
+		{"trustedProxies", config.TrustedProxies, &handler.trustedProxies},
+	} {
+		if len(list.values) == 0 {
+			continue
+		}
+		nets, err := parseCIDRs(list.values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", list.name, err)
+		}
+		*list.nets = nets
+	}
+
+	if config.Sitemap {
+		// Extensions are matched lower-cased and with their dot
+		extension := func(ext string) string {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			return strings.ToLower(ext)
+		}
+		handler.sitemapChangeFreq = make(map[string]string, len(config.SitemapChangeFreq))
+		for ext, freq := range config.SitemapChangeFreq {
+			handler.sitemapChangeFreq[extension(ext)] = freq
+		}
+		handler.sitemapPriority = make(map[string]float64, len(config.SitemapPriority))
+		for ext, priority := range config.SitemapPriority {
+			handler.sitemapPriority[extension(ext)] = priority
+		}
+	}
+
+	if config.ExpandAlternateFiles {
+		handler.alternateExtensions = make(map[string][]string, len(config.AlternateExtensions))
+		for mediaType, extensions := range config.AlternateExtensions {
+			normalized := make([]string, len(extensions))
+			for i, ext := range extensions {
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+				normalized[i] = ext
+			}
+			handler.alternateExtensions[strings.ToLower(mediaType)] = normalized
+		}
+	}
+
+	if len(config.CacheKeyIncludeHeaders) > 0 {
+		handler.cacheKeyHeaders = make([]string, len(config.CacheKeyIncludeHeaders))
+		for i, name := range config.CacheKeyIncludeHeaders {
+			handler.cacheKeyHeaders[i] = http.CanonicalHeaderKey(name)
+		}
+		sort.Strings(handler.cacheKeyHeaders)
+	}
+
+	if config.GlobalRateLimit > 0 {
+		handler.globalRateLimit = newGlobalRateLimiter(ctx, config.GlobalRateLimit)
+	}
+
+	if config.BasicAuth.HtpasswdFile != "" {
+		auth, err := loadBasicAuth(config.BasicAuth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load basicAuth.htpasswdFile: %w", err)
+		}
+		handler.basicAuth = auth
+	}
+
+	if len(config.CORS.AllowOrigins) > 0 {
+		handler.cors = newCORSPolicy(config.CORS)
+	}
+
+	if strings.ContainsAny(config.CSP, "\r\n<>") {
+		log.Printf("statiq: csp contains line breaks or angle brackets, check it was not copied from an HTML tag: %q", config.CSP)
+	}
+
+	if config.SlowloadSimulation > 0 {
+		log.Printf("statiq: slowloadSimulation delays every response by %s; do not use it in production", config.SlowloadSimulation)
+	}
+
+	if len(config.MimeTypes) > 0 {
+		handler.mimeTypes = make(map[string]string, len(config.MimeTypes))
+		for ext, contentType := range config.MimeTypes {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			handler.mimeTypes[ext] = contentType
+		}
+	}
+
+	// Only a root directory has links to follow
+	if root != "" {
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root path: %w", err)
+		}
+		handler.symlinks = &symlinkPolicy{
+			root:       resolved,
+			sameRoot:   handler.followSymlinks == followSymlinksSameRoot,
+			any:        handler.followSymlinks == followSymlinksAny,
+			chainLimit: config.SymlinkChainLimit,
+		}
+		if handler.symlinks.chainLimit <= 0 {
+			handler.symlinks.chainLimit = defaultSymlinkChainLimit
+		}
+		handler.root = symlinkFS{FileSystem: handler.root, policy: handler.symlinks}
+	}
+
+	if config.ServeFromMemory {
+		files, err := handler.loadMemFS(root, int64(config.MemFSMaxSizeMB)<<20)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load root into memory: %w", err)
+		}
+		handler.root = files
+	}
+
+	if config.MemCache {
+		handler.root = memCacheFS{
+			FileSystem: handler.root,
+			root:       root,
+			maxFile:    int64(config.MemCacheMaxFile),
+			cache:      newLRUCache(int64(config.MemCacheMaxSize)),
+			h:          handler,
+		}
+	}
+
+	if handler.prometheusPath != "" || handler.metricsPath != "" {
+		handler.metrics = newMetrics()
+		handler.root = countingFS{FileSystem: handler.root, open: &handler.metrics.openFiles}
+	}
+
+	if len(config.Aliases) > 0 {
+		handler.aliases = make(map[string]string, len(config.Aliases))
+		for from, target := range config.Aliases {
+			target = path.Clean("/" + target)
+			// Fail fast on typos unless the targets appear after startup
+			if !config.LazyAliasCheck {
+				f, err := handler.root.Open(target)
+				if err != nil {
+					return nil, fmt.Errorf("alias target for %q not found: %s", from, target)
+				}
+				f.Close()
+			}
+			handler.aliases[from] = target
+		}
+	}
+
+	for _, pattern := range config.BlockedUserAgents {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blockedUserAgents pattern %q: %w", pattern, err)
+		}
+		handler.blockedUserAgents = append(handler.blockedUserAgents, re)
+	}
+
+	if config.BackgroundCompressOnBoot {
+		go handler.precompressRoot(ctx, root, config.ForceRecompress)
+	}
+
+	if config.FeedbackPage != "" {
+		handler.htmlInjections = append(handler.htmlInjections, feedbackInjection(config.FeedbackPage))
+	}
+
+	if config.ServiceWorkerScript != "" {
+		handler.htmlInjections = append(handler.htmlInjections, serviceWorkerInjection(config.ServiceWorkerScript))
+		handler.serviceWorkerPath = path.Join("/", config.ServiceWorkerScript)
+	}
+
+	return handler, nil
+}
+
+// wrap adds the access log and middlewares, so the first middleware runs outermost
+func (h *StatiqHandler) wrap(config *Config) http.Handler {
+	var wrapped http.Handler = h
+	if h.accessLog != nil {
+		wrapped = h.wrapAccessLog(wrapped)
+	}
+	if h.metrics != nil {
+		wrapped = h.wrapMetrics(wrapped)
+	}
+	if h.statsd != nil {
+		wrapped = h.wrapStatsd(wrapped)
+	}
+	if h.spans != nil {
+		wrapped = h.wrapTracing(wrapped)
+	}
+	for i := len(config.MiddlewareChain) - 1; i >= 0; i-- {
+		wrapped = config.MiddlewareChain[i](wrapped)
+	}
+	return wrapped
+}
+
+// validateConfig rejects inconsistent option combinations before the handler is built
+func validateConfig(config *Config) error {
+	if config.HSTSPreload {
+		if config.SecurityHeaders.HSTSMaxAge < hstsPreloadMinAge {
+			return fmt.Errorf("hstsPreload requires securityHeaders.hstsMaxAge of at least %d, got %d",
+				hstsPreloadMinAge, config.SecurityHeaders.HSTSMaxAge)
+		}
+		if !config.SecurityHeaders.HSTSIncludeSubdomains {
+			return fmt.Errorf("hstsPreload requires securityHeaders.hstsIncludeSubdomains")
+		}
+	}
+
+	for _, origin := range config.CORS.AllowOrigins {
+		if _, err := path.Match(origin, ""); err != nil {
+			return fmt.Errorf("invalid cors.allowOrigins pattern %q: %w", origin, err)
+		}
+		// Credentials for any origin would let every site read responses on the user's behalf
+		if origin == "*" && config.CORS.AllowCredentials {
+			return fmt.Errorf("cors.allowCredentials cannot be combined with the cors.allowOrigins wildcard \"*\"")
+		}
+	}
+
+	if config.CORS.MaxAge < 0 {
+		return fmt.Errorf("cors.maxAge must not be negative, got %d", config.CORS.MaxAge)
+	}
+
+	if config.ExpandAlternateFiles && len(config.AlternateExtensions) == 0 {
+		return fmt.Errorf("expandAlternateFiles requires alternateExtensions")
+	}
+	for mediaType := range config.AlternateExtensions {
+		if !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("alternateExtensions key %q must be a media type such as audio/ogg or audio/*", mediaType)
+		}
+	}
+
+	for ext, freq := range config.SitemapChangeFreq {
+		if !sitemapChangeFreqs[freq] {
+			return fmt.Errorf("sitemapChangeFreq for %q must be always, hourly, daily, weekly, monthly, yearly or never, got %q", ext, freq)
+		}
+	}
+	for ext, priority := range config.SitemapPriority {
+		if priority < 0 || priority > 1 {
+			return fmt.Errorf("sitemapPriority for %q must be between 0 and 1, got %g", ext, priority)
+		}
+	}
+
+	if config.Sitemap && !isHTTPOrigin(config.SitemapBaseURL) {
+		return fmt.Errorf("sitemap requires sitemapBaseURL to be an http or https origin, got %q", config.SitemapBaseURL)
+	}
+
+	if config.StripPrefix != "" && (!strings.HasPrefix(config.StripPrefix, "/") || config.StripPrefix == "/") {
+		return fmt.Errorf("stripPrefix must start with / and name a path, got %q", config.StripPrefix)
+	}
+
+	for from, target := range config.Aliases {
+		if !strings.HasPrefix(from, "/") || target == "" {
+			return fmt.Errorf("alias %q must start with / and have a target", from)
+		}
+	}
+
+	for ext, seconds := range config.PerExtensionMaxAge {
+		if seconds < 0 {
+			return fmt.Errorf("perExtensionMaxAge for %q must not be negative, got %d", ext, seconds)
+		}
+	}
+
+	if config.ArchiveRoot != "" {
+		if config.Root != "" && config.Root != "." {
+			return fmt.Errorf("root and archiveRoot are mutually exclusive, leave root unset")
+		}
+		if !isArchiveName(config.ArchiveRoot) {
+			return fmt.Errorf("archiveRoot must be a .zip, .tar.gz or .tgz file, got %q", config.ArchiveRoot)
+		}
+	}
+
+	if config.ReadTimeout < 0 {
+		return fmt.Errorf("readTimeout must not be negative, got %s", config.ReadTimeout)
+	}
+
+	if config.MemCache {
+		if config.ServeFromMemory {
+			return fmt.Errorf("memCache cannot be combined with serveFromMemory, which already serves every file from memory")
+		}
+		if config.MemCacheMaxSize <= 0 || config.MemCacheMaxFile <= 0 {
+			return fmt.Errorf("memCacheMaxSize and memCacheMaxFile must be positive, got %d and %d", config.MemCacheMaxSize, config.MemCacheMaxFile)
+		}
+	}
+
+	switch config.TrailingSlash {
+	case "", trailingSlashRedirect, trailingSlashNone:
+	case trailingSlashStrip:
+		// A missing /about/ would be redirected by one and served the index by the other
+		if config.SPAMode || len(config.SPARoutes) > 0 {
+			return fmt.Errorf("trailingSlash strip cannot be combined with spaMode or spaRoutes, use none")
+		}
+	default:
+		return fmt.Errorf("trailingSlash must be redirect, none or strip, got %q", config.TrailingSlash)
+	}
+
+	switch config.FollowSymlinks {
+	case "", followSymlinksNone, followSymlinksSameRoot, followSymlinksAny:
+	default:
+		return fmt.Errorf("followSymlinks must be none, same-root or any, got %q", config.FollowSymlinks)
+	}
+
+	if config.GlobalRateLimit < 0 {
+		return fmt.Errorf("globalRateLimit must not be negative, got %d", config.GlobalRateLimit)
+	}
+
+	if config.BasicAuth.HtpasswdFile == "" && (config.BasicAuth.Realm != "" || len(config.BasicAuth.Exclude) > 0) {
+		return fmt.Errorf("basicAuth requires htpasswdFile")
+	}
+
+	if (config.RequiredQueryParam == "") != (config.RequiredQueryValue == "") {
+		return fmt.Errorf("requiredQueryParam and requiredQueryValue must be set together")
+	}
+
+	if config.CSPReportOnly && config.CSP == "" {
+		return fmt.Errorf("cspReportOnly requires csp")
+	}
+
+	if config.TLSClientCert && config.TLSClientCACert == "" {
+		return fmt.Errorf("tlsClientCert requires tlsClientCACert")
+	}
+
+	if config.SPAPrerender && config.PrerenderCachePath == "" {
+		return fmt.Errorf("spaPrerender requires prerenderCachePath")
+	}
+
+	if config.SPAPrerender {
+		if !isHTTPOrigin(config.PrerenderOrigin) {
+			return fmt.Errorf("spaPrerender requires prerenderOrigin to be an http or https origin, got %q", config.PrerenderOrigin)
+		}
+
+		if len(config.PrerenderRoutes) == 0 {
+			return fmt.Errorf("spaPrerender requires prerenderRoutes")
+		}
+	}
+
+	if config.ImageResize && config.ImageCachePath == "" {
+		return fmt.Errorf("imageResize requires imageCachePath")
+	}
+
+	if _, err := parseTLSVersion(config.TLSMinVersion); err != nil {
+		return err
+	}
+
+	if _, err := parseETagAlgorithm(config.ETagAlgorithm); err != nil {
+		return err
+	}
+
+	if config.OTLPEndpoint != "" {
+		if u, err := url.Parse(config.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("otlpEndpoint must be an http or https URL, got %q", config.OTLPEndpoint)
+		}
+	}
+
+	for ext, policy := range config.CachePolicies {
+		if policy.MaxAge < 0 {
+			return fmt.Errorf("cachePolicies entry for %q has a negative maxAge: %d", ext, policy.MaxAge)
+		}
+	}
+
+	if config.PrometheusPath != "" && !strings.HasPrefix(config.PrometheusPath, "/") {
+		return fmt.Errorf("prometheusPath must start with /, got %q", config.PrometheusPath)
+	}
+
+	if config.MetricsPath != "" && !strings.HasPrefix(config.MetricsPath, "/") {
+		return fmt.Errorf("metricsPath must start with /, got %q", config.MetricsPath)
+	}
+
+	for i, route := range config.SPARoutes {
+		if !strings.HasPrefix(route.Prefix, "/") || route.Index == "" {
+			return fmt.Errorf("spaRoutes entry %d needs a prefix starting with / and an index", i)
+		}
+	}
+
+	for i, rule := range config.Headers {
+		if rule.Pattern == "" && rule.Extension == "" {
+			return fmt.Errorf("headers rule %d needs a pattern or an extension", i)
+		}
+	}
+
+	for ext, contentType := range config.MimeTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("invalid mimeTypes entry for %q: %w", ext, err)
+		}
+	}
+
+	if config.BrotliQuality < 0 || config.BrotliQuality > 11 {
+		return fmt.Errorf("brotliQuality must be between 0 and 11, got %d", config.BrotliQuality)
+	}
+
+	if config.GzipLevel != 0 && (config.GzipLevel < gzip.BestSpeed || config.GzipLevel > gzip.BestCompression) {
+		return fmt.Errorf("gzipLevel must be between %d and %d, got %d", gzip.BestSpeed, gzip.BestCompression, config.GzipLevel)
+	}
+
+	if config.AutoExpireOldVersions {
+		if _, ok := parseVersion(config.CurrentVersion); !ok {
+			return fmt.Errorf("autoExpireOldVersions requires a currentVersion such as \"v2\", got %q", config.CurrentVersion)
+		}
+	}
+
+	return nil
+}
+
+// isHTTPOrigin reports whether rawURL is a bare http or https origin such as
+// https://example.com, without credentials, path, query or fragment
+func isHTTPOrigin(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		strings.TrimSuffix(u.Path, "/") == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// ServeHTTP serves HTTP requests with static files
+func (h *StatiqHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The policy depends on the resolved content type, known only once the headers are written
+	if h.csp != "" {
+		w = newHeaderHookWriter(w, h.setCSPHeader)
+	}
+
+	// Paths are resolved below StripPrefix; other requests are not ours
+	if h.stripPrefix != "" {
+		stripped, ok := h.stripURLPrefix(r)
+		if !ok {
+			if h.passThrough {
+				h.next.ServeHTTP(w, r)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		r = stripped
+	}
+
+	h.setProxyHeaders(w)
+	h.setTraceHeaders(w, r)
+	h.setServiceMeshHeaders(w, r)
+
+	// Overlong URIs are refused before any of them is parsed or copied
+	if h.maxURILength > 0 && len(r.RequestURI) > h.maxURILength {
+		http.Error(w, "URI Too Long", http.StatusRequestURITooLong)
+		return
+	}
+
+	// Shed load once the concurrent request cap is reached
+	if h.requestCountLimit > 0 {
+		if h.inFlight.Add(1) > h.requestCountLimit {
+			h.inFlight.Add(-1)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		// A detached send takes the slot over and releases it when done
+		slot := &inFlightSlot{}
+		r = r.WithContext(context.WithValue(r.Context(), inFlightContextKey{}, slot))
+		defer func() {
+			if !slot.detached {
+				h.inFlight.Add(-1)
+			}
+		}()
+	}
+
+	// Refuse everything once all clients together used up the window's requests
+	if h.globalRateLimit != nil && !h.globalRateLimit.allow() {
+		w.Header().Set("Retry-After", h.globalRateLimit.retryAfter())
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Delay every response when simulating a slow network in development
+	if h.slowloadSimulation > 0 {
+		select {
+		case <-time.After(h.slowloadSimulation):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	// Enforce the request body limit and release the body before touching the disk
+	if !h.discardBody(w, r) {
+		return
+	}
+
+	// The health endpoint answers before any redirect or access rule applies
+	if h.health != nil && r.URL.Path == h.healthPath {
+		h.health.ServeHTTP(w, r)
+		return
+	}
+
+	// In HEAD-only mode GET is answered like HEAD and everything else is refused
+	if h.headOnlyMode {
+		switch r.Method {
+		case http.MethodHead:
+		case http.MethodGet:
+			head := *r
+			head.Method = http.MethodHead
+			r = &head
+		default:
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	// Refuse clients outside the allowed networks
+	if h.isBlockedIP(r) {
+		h.serveError(w, r, nil, http.StatusForbidden)
+		return
+	}
+
+	// Turn away clients identifying as blocked scrapers
+	if h.isBlockedUserAgent(r) {
+		h.serveError(w, r, nil, http.StatusForbidden)
+		return
+	}
+
+	// Send legacy browsers to the compatibility page
+	if h.isLegacyBrowser(r) {
+		http.Redirect(w, r, h.legacyRedirect, http.StatusFound)
+		return
+	}
+
+	h.setSecurityHeaders(w)
+	h.setCORSHeaders(w, r)
+
+	// OPTIONS requests are answered directly without touching the file system
+	if r.Method == http.MethodOptions {
+		if h.cors != nil && isPreflight(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.serveOptions(w)
+		return
+	}
+
+	// Gate access on a verified client certificate
+	if h.clientCAs != nil && !h.hasValidClientCert(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Gate access on a shared secret in the query string
+	if h.requiredQueryParam != "" && !h.hasRequiredQueryValue(r) {
+		h.serveError(w, r, nil, http.StatusForbidden)
+		return
+	}
+
+	// Require credentials from the htpasswd file outside the excluded paths
+	if h.basicAuth != nil && !h.basicAuth.excludes(r.URL.Path) && !h.basicAuth.authenticate(r) {
+		h.basicAuth.serveUnauthorized(w)
+		return
+	}
+
+	// Metrics expose traffic details, so they are only served past the access gates
+	if h.metrics != nil && (r.URL.Path == h.prometheusPath || r.URL.Path == h.metricsPath) {
+		h.serveMetrics(w, r)
+		return
+	}
+
+	// A sitemap.xml in the root takes precedence over the generated one
+	if h.sitemap && r.URL.Path == sitemapPath && !h.isRegularFile(sitemapPath) {
+		h.serveSitemap(w, r)
+		return
+	}
+
+	// Redirect rules take precedence over the file system
+	if h.serveRedirect(w, r) {
+		return
+	}
+
+	// Clean the path
+	upath := r.URL.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+
+	// Paths climbing out of their directory are refused before they reach the file system
+	if isUnsafePath(upath) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Aliased paths serve their target instead of their own file
+	if target, ok := h.aliases[upath]; ok {
+		h.serveAlias(w, r, target)
+		return
+	}
+
+	// Dot-files such as .git or .env are kept private unless allowed
+	if h.hidesDotPath(upath) {
+		h.serveNotFound(w, r)
+		return
+	}
+
+	// Sidecar files configure the server and are never served themselves
+	if h.readSidecars && path.Base(upath) == sidecarFileName {
+		h.serveNotFound(w, r)
+		return
+	}
+
+	// Listing thumbnails are generated from the image path below the prefix
+	if h.isThumbnailPath(upath) {
+		h.serveThumbnail(w, r, path.Clean("/"+strings.TrimPrefix(upath, thumbnailPrefix)))
+		return
+	}
+
+	// Hot replacements take precedence over the file on disk
+	if h.serveHotReplacement(w, r, upath) {
+		return
+	}
+
+	// Prefer the JSON variant of extension-less paths when the client asks for it
+	if h.serveJSONVariant(w, r, upath) {
+		return
+	}
+
+	// Pick the best format of extension-less media such as /audio/track
+	if h.serveAlternateFile(w, r, upath) {
+		return
+	}
+
+	// Pick the page translated into the client's preferred language
+	if h.serveLanguageVariant(w, r, upath) {
+		return
+	}
+
+	// Try to open the file
+	f, err := h.root.Open(upath)
+	// Links written on case-insensitive file systems may differ in case only
+	if os.IsNotExist(err) && h.caseInsensitive {
+		if match, ok := h.caseFoldedPath(upath); ok {
+			log.Printf("statiq: %q served as %q by caseInsensitive; fix the link to match the file's case", upath, match)
+			upath = match
+			f, err = h.root.Open(upath)
+		}
+	}
+	if err != nil {
+		// Handle not found
+		if os.IsNotExist(err) {
+			if h.trailingSlash == trailingSlashStrip && len(upath) > 1 && strings.HasSuffix(upath, "/") {
+				h.stripTrailingSlash(w, r)
+				return
+			}
+			if h.serveVersion(w, r) {
+				return
+			}
+			h.serveNotFound(w, r)
+			return
+		}
+		h.serveError(w, r, err, http.StatusForbidden)
+		return
+	}
+	defer f.Close()
+
+	// Get file info
+	d, err := f.Stat()
+	if err != nil {
+		h.serveError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Handle directory
+	if d.IsDir() {
+		// Redirect if the directory name doesn't end in a slash
+		url := r.URL.Path
+		if len(url) == 0 || url[len(url)-1] != '/' {
+			// Serve the directory's index in place for configured extensions
+			if (h.trailingSlash == trailingSlashNone || h.indexForExtensions[path.Ext(url)]) && h.serveIndexInPlace(w, r, upath) {
+				return
+			}
+			// Listings always get the slash, their links are relative to it
+			h.localRedirect(w, r, url+"/")
+			return
+		}
+
+		if h.trailingSlash == trailingSlashNone && h.serveIndexInPlace(w, r, upath) {
+			return
+		}
+
+		// Try to serve an index file
+		for _, index := range h.indexFilesFor(r) {
+			indexPath := path.Join(upath, index) // Use path.Join for URL paths
+			indexFile, err := h.root.Open(indexPath)
+			if err == nil {
+				indexFile.Close()
+				h.localRedirect(w, r, indexPath)
+				return
+			}
+		}
+
+		// If directory listing is disabled, return 404
+		if !h.enableDirListing {
+			if h.randomDefaultFile && h.serveRandomFile(w, r, upath, f) {
+				return
+			}
+			h.serveErrorPage404(w, r)
+			return
+		}
+
+		// Serve directory listing
+		h.serveDirectoryListing(w, r, f, d)
+		return
+	}
+
+	// A trailing slash after a regular file only resolves when explicitly allowed
+	if len(upath) > 1 && strings.HasSuffix(upath, "/") {
+		if h.trailingSlash == trailingSlashStrip {
+			h.stripTrailingSlash(w, r)
+			return
+		}
+		if !h.ignoreFileSlash {
+			h.serveNotFound(w, r)
+			return
+		}
+	}
+
+	// Set cache control headers if configured
+	h.setCacheHeaders(w, r, d)
+	h.setRuleHeaders(w, r, d)
+	h.setServiceWorkerHeader(w, r)
+
+	// Get content type based on file extension
+	contentType := h.fileContentType(d)
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	// Prefer a pre-compressed sidecar the client can decode
+	if h.preCompressed && h.servePreCompressed(w, r, upath, d) {
+		return
+	}
+
+	// Hand large files off to their own goroutine when configured
+	if h.canDetach(r, d) && !h.transformsContent(w, r, d) && h.serveDetached(w, r, upath, d) {
+		return
+	}
+
+	// Serve the file
+	h.serveContent(w, r, d, f)
+}
+
+// serveContent writes the file body, routing HTML through the injection path when configured
+func (h *StatiqHandler) serveContent(w http.ResponseWriter, r *http.Request, d fs.FileInfo, content io.ReadSeeker) {
+	// Unchanged files are confirmed before any transform does its work
+	if notModified(w, r) {
+		return
+	}
+
+	// Push the page's assets before the client parses it and asks for them
+	if h.http2Push {
+		h.pushAssets(w, r, content)
+	}
+
+	// A file that stops delivering data must not hold the goroutine
+	if h.readTimeout > 0 {
+		content = newTimeoutReader(r.Context(), content, d.Name(), h.readTimeout)
+	}
+
+	if h.spans != nil {
+		recordSpanFile(r, d.Size(), d.ModTime())
+	}
+
+	name, modTime := d.Name(), d.ModTime()
+	if h.disableLastModified {
+		// A zero time keeps http.ServeContent from setting Last-Modified
+		modTime = time.Time{}
+	}
+
+	// Very large files are streamed with chunked encoding instead of a fixed length
+	if h.contentLengthThreshold > 0 && d.Size() > h.contentLengthThreshold {
+		w = newHeaderHookWriter(w, func(header http.Header, _ int) {
+			header.Del("Content-Length")
+		})
+	}
+
+	if encoding := h.responseEncoding(w, r, d); encoding != "" {
+		// The compressed stream no longer matches the file's byte offsets
+		r = r.Clone(r.Context())
+		r.Header.Del("Range")
+
+		cw := h.newCompressWriter(w, encoding)
+		defer cw.Close()
+		w = cw
+	}
+
+	if h.minifiesHTML(w, d) {
+		minified, err := minifiedContent(content, minifyHTML)
+		if err != nil {
+			h.serveError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		content = minified
+	}
+
+	if h.webpConvert && imageFormat(w.Header().Get("Content-Type")) != "" {
+		// The body depends on whether the client accepts WebP
+		w.Header().Add("Vary", "Accept")
+	}
+
+	if opts, ok := h.imageOptionsFor(w, r, d); ok {
+		img, err := h.serveImage(w, r, d, content, opts)
+		if err != nil {
+			h.serveError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		content = img
+	}
+
+	if h.minifiesJS(w, r.URL.Path, d) {
+		minified, err := h.minifiedJS(r, d, content)
+		if err != nil {
+			h.serveError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		h.varyCacheKeyHeaders(w)
+		content = minified
+	}
+
+	if h.minifiesCSS(w, r.URL.Path) {
+		// The minified stream no longer matches the file's byte offsets
+		r = r.Clone(r.Context())
+		r.Header.Del("Range")
+
+		mw := newCSSMinifyWriter(w)
+		http.ServeContent(mw, r, name, modTime, content)
+		mw.Close()
+		return
+	}
+
+	if !h.transformsHTML() || r.Method == http.MethodHead || !isHTMLResponse(w) {
+		http.ServeContent(w, r, name, modTime, content)
+		return
+	}
+
+	// The transformed body no longer matches the file's byte offsets, so ranges are not honoured
+	r = r.Clone(r.Context())
+	r.Header.Del("Range")
+
+	tw, finish := h.wrapHTMLTransforms(w, r)
+	http.ServeContent(tw, r, name, modTime, content)
+	finish()
+}
+
+// setSecurityHeaders sets the configured security headers on every response
+func (h *StatiqHandler) setSecurityHeaders(w http.ResponseWriter) {
+	if h.hsts != "" {
+		w.Header().Set("Strict-Transport-Security", h.hsts)
+	}
+}
 
-	// EnableDirectoryListing enables directory listing
-	EnableDirectoryListing bool `json:"enableDirectoryListing,omitempty"`
+// hasRequiredQueryValue reports whether the request carries the required
+// query parameter value, comparing in constant time
+func (h *StatiqHandler) hasRequiredQueryValue(r *http.Request) bool {
+	value := r.URL.Query().Get(h.requiredQueryParam)
+	return subtle.ConstantTimeCompare([]byte(value), []byte(h.requiredQueryValue)) == 1
+}
 
-	// IndexFiles is a list of filenames to try when a directory is requested
-	IndexFiles []string `json:"indexFiles,omitempty"`
+// setCSPHeader sets the Content-Security-Policy on HTML responses
+func (h *StatiqHandler) setCSPHeader(header http.Header, _ int) {
+	if !strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+		return
+	}
+	if h.cspReportOnly {
+		header.Set("Content-Security-Policy-Report-Only", h.csp)
+	} else {
+		header.Set("Content-Security-Policy", h.csp)
+	}
+}
 
-	// SPAMode redirects all not-found requests to a single page
-	SPAMode bool `json:"spaMode,omitempty"`
+// setProxyHeaders identifies Statiq as the origin of the response for
+// observability tooling. The version is only sent when it was set at build time.
+func (h *StatiqHandler) setProxyHeaders(w http.ResponseWriter) {
+	if !h.proxyHeaders {
+		return
+	}
+	w.Header().Set("X-Served-By", "statiq")
+	if Version != "dev" {
+		w.Header().Set("X-Plugin-Version", Version)
+	}
+}
 
-	// SPAIndex is the file to serve in SPA mode
-	SPAIndex string `json:"spaIndex,omitempty"`
+// isLegacyBrowser reports whether the request comes from a browser matching
+// one of the legacy User-Agent substrings. Requests for the compatibility page
+// itself are never matched so the redirect cannot loop.
+func (h *StatiqHandler) isLegacyBrowser(r *http.Request) bool {
+	if h.legacyRedirect == "" || r.URL.Path == h.legacyRedirect {
+		return false
+	}
 
-	// ErrorPage404 is the path to a custom 404 error page
-	ErrorPage404 string `json:"errorPage404,omitempty"`
+	ua := r.Header.Get("User-Agent")
+	if ua == "" {
+		return false
+	}
 
-	// CacheControl sets cache control headers for static files
-	CacheControl map[string]string `json:"cacheControl,omitempty"`
+	for _, sub := range h.legacyUA {
+		if sub != "" && strings.Contains(ua, sub) {
+			return true
+		}
+	}
+
+	return false
 }
 
-// CreateConfig creates the default plugin configuration.
-func CreateConfig() *Config {
-	return &Config{
-		Root:                  ".",
-		EnableDirectoryListing: false,
-		IndexFiles:            []string{"index.html", "index.htm"},
-		SPAMode:               false,
-		SPAIndex:              "index.html",
-		ErrorPage404:          "",
-		CacheControl:          map[string]string{},
+// isBlockedUserAgent reports whether the User-Agent matches a BlockedUserAgents pattern
+func (h *StatiqHandler) isBlockedUserAgent(r *http.Request) bool {
+	if len(h.blockedUserAgents) == 0 {
+		return false
+	}
+
+	ua := r.Header.Get("User-Agent")
+	for _, re := range h.blockedUserAgents {
+		if re.MatchString(ua) {
+			return true
+		}
 	}
+
+	return false
 }
 
-// dirEntry represents a file or directory for the directory listing template
-type dirEntry struct {
-	Name    string
-	Size    int64
-	Mode    os.FileMode
-	ModTime time.Time
-	IsDir   bool
+// serveNotFound answers a request for a path that does not exist, using an
+// SPA index, the OnNotFound hook or the custom 404 page when configured
+func (h *StatiqHandler) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	if index := h.spaIndexFor(r.URL.Path); index != "" {
+		// Crawlers get a prerendered snapshot when one is cached
+		if h.servePrerendered(w, r) {
+			return
+		}
+
+		// The index's own headers win over the cache and rule headers
+		if len(h.spaIndexHeaders) > 0 {
+			w = newHeaderHookWriter(w, h.setSPAIndexHeaders)
+		}
+
+		// In SPA mode, serve the SPA index file
+		h.serveRootFile(w, r, path.Join("/", index))
+		return
+	}
+
+	if h.onNotFound != nil {
+		h.onNotFound(w, r)
+		return
+	}
+
+	h.serveErrorPage404(w, r)
 }
 
-// Initialize MIME types
-func init() {
-	// Register Go files as text/x-go to match standard behavior
-	mime.AddExtensionType(".go", "text/x-go")
+// setSPAIndexHeaders applies SPAIndexHeaders to an SPA fallback response
+func (h *StatiqHandler) setSPAIndexHeaders(header http.Header, _ int) {
+	for name, value := range h.spaIndexHeaders {
+		header.Set(name, value)
+	}
 }
 
-// StatiqHandler is a custom file server handler
-type StatiqHandler struct {
-	root                 http.FileSystem
-	rootPath             string
-	enableDirListing     bool
-	indexFiles           []string
-	spaMode              bool
-	spaIndex             string
-	errorPage404         string
-	cacheControl         map[string]string
-	notFoundResponseCode int
+// spaIndexFor returns the SPA index answering a missing path: that of the
+// longest matching SPARoutes prefix, else SPAIndex in SPA mode, else ""
+func (h *StatiqHandler) spaIndexFor(urlPath string) string {
+	for _, route := range h.spaRoutes {
+		prefix := strings.TrimSuffix(route.Prefix, "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return route.Index
+		}
+	}
+	if h.spaMode {
+		return h.spaIndex
+	}
+	return ""
 }
 
-// New creates a new Statiq plugin.
-// New creates a new Statiq plugin.
-func New(_ context.Context, next http.Handler, config *Config, _ string) (http.Handler, error) {
-    // Ensure the root path is absolute
-    root, err := filepath.Abs(config.Root)
-    if err != nil {
-        return nil, fmt.Errorf("invalid root path: %w", err)
-    }
-	// Ensure the directory exists
-if _, err := os.Stat(root); os.IsNotExist(err) {
-    if err := os.MkdirAll(root, 0755); err != nil {
-        return nil, fmt.Errorf("failed to create root directory: %w", err)
-    }
-}
-    // Check if custom 404 page exists - also make this check optional
-    notFoundResponseCode := http.StatusNotFound
-    if config.ErrorPage404 != "" {
-        // We'll validate the error page at runtime instead of initialization time
-        notFoundResponseCode = http.StatusOK // We'll serve the error page with 200 OK
-    }
-
-    // Create a custom handler
-    handler := &StatiqHandler{
-        root:                 http.Dir(root),
-        rootPath:             root,
-        enableDirListing:     config.EnableDirectoryListing,
-        indexFiles:           config.IndexFiles,
-        spaMode:              config.SPAMode,
-        spaIndex:             config.SPAIndex,
-        errorPage404:         config.ErrorPage404,
-        cacheControl:         config.CacheControl,
-        notFoundResponseCode: notFoundResponseCode,
-    }
-
-    // Return our custom handler
-    return handler, nil
+// serveError answers with the OnError hook when err is set, otherwise with
+// the custom page configured for code or a plain error response. The page
+// is copied as is, keeping the real status code.
+func (h *StatiqHandler) serveError(w http.ResponseWriter, r *http.Request, err error, code int) {
+	if h.onError != nil && err != nil {
+		h.onError(w, r, err, code)
+		return
+	}
+
+	page, ok := h.errorPages[code]
+	if !ok {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	f, err := h.root.Open(page)
+	if err != nil {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	defer f.Close()
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	header.Set("Cache-Control", "no-store")
+	if contentType := h.typeByExtension(path.Ext(page)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		io.Copy(w, f)
+	}
 }
-// ServeHTTP serves HTTP requests with static files
-func (h *StatiqHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Clean the path
-	upath := r.URL.Path
-	if !strings.HasPrefix(upath, "/") {
-		upath = "/" + upath
+
+// serveErrorPage404 serves the custom 404 page, or a plain 404 when none is configured
+func (h *StatiqHandler) serveErrorPage404(w http.ResponseWriter, r *http.Request) {
+	if errorPage := h.errorPage404For(r); errorPage != "" {
+		// Serve custom 404 page
+		w.WriteHeader(h.notFoundResponseCode)
+		h.serveRootFile(w, r, errorPage)
+		return
 	}
-	
-	// Try to open the file
-	f, err := h.root.Open(upath)
+
+	http.NotFound(w, r)
+}
+
+// serveIndexInPlace serves the first existing index file of dir without
+// redirecting. It returns false when the directory has no index file.
+func (h *StatiqHandler) serveIndexInPlace(w http.ResponseWriter, r *http.Request, dir string) bool {
+	for _, index := range h.indexFilesFor(r) {
+		indexPath := path.Join(dir, index)
+		indexFile, err := h.root.Open(indexPath)
+		if err != nil {
+			continue
+		}
+		info, err := indexFile.Stat()
+		indexFile.Close()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		h.serveRootFile(w, r, indexPath)
+		return true
+	}
+
+	return false
+}
+
+// serveAlias serves the target of an alias, resolving a directory to its index file
+func (h *StatiqHandler) serveAlias(w http.ResponseWriter, r *http.Request, target string) {
+	f, err := h.root.Open(target)
 	if err != nil {
-		// Handle not found
 		if os.IsNotExist(err) {
-			if h.spaMode {
-				// In SPA mode, serve the SPA index file
-				h.serveFile(w, r, filepath.Join(string(h.rootPath), h.spaIndex))
-				return
-			}
-			
-			if h.errorPage404 != "" {
-				// Serve custom 404 page
-				w.WriteHeader(h.notFoundResponseCode)
-				h.serveFile(w, r, filepath.Join(string(h.rootPath), h.errorPage404))
-				return
-			}
-			
-			http.NotFound(w, r)
+			h.serveNotFound(w, r)
 			return
 		}
-		http.Error(w, "Forbidden", http.StatusForbidden)
+		h.serveError(w, r, err, http.StatusForbidden)
 		return
 	}
-	defer f.Close()
 
-	// Get file info
 	d, err := f.Stat()
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		f.Close()
+		h.serveError(w, r, err, http.StatusInternalServerError)
 		return
 	}
-
-	// Handle directory
 	if d.IsDir() {
-		// Redirect if the directory name doesn't end in a slash
-		url := r.URL.Path
-		if len(url) == 0 || url[len(url)-1] != '/' {
-			localRedirect(w, r, url+"/")
-			return
+		f.Close()
+		if !h.serveIndexInPlace(w, r, target) {
+			h.serveErrorPage404(w, r)
 		}
+		return
+	}
+	h.serveOpenFile(w, r, f)
+}
 
-		// Try to serve an index file
-		for _, index := range h.indexFiles {
-			indexPath := path.Join(upath, index)  // Use path.Join for URL paths
-			indexFile, err := h.root.Open(indexPath)
-			if err == nil {
-				indexFile.Close()
-				localRedirect(w, r, indexPath)
-				return
-			}
+// serveRandomFile serves a randomly chosen regular file of the directory,
+// skipping subdirectories and dot-files. It returns false when the directory
+// holds no candidate.
+func (h *StatiqHandler) serveRandomFile(w http.ResponseWriter, r *http.Request, dir string, f http.File) bool {
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		return false
+	}
+
+	candidates := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
 		}
+		candidates = append(candidates, entry.Name())
+	}
+	if len(candidates) == 0 {
+		return false
+	}
 
-		// If directory listing is disabled, return 404
-		if !h.enableDirListing {
-			if h.errorPage404 != "" {
-				w.WriteHeader(h.notFoundResponseCode)
-				h.serveFile(w, r, filepath.Join(string(h.rootPath), h.errorPage404))
-				return
-			}
-			http.NotFound(w, r)
-			return
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(candidates))))
+	if err != nil {
+		return false
+	}
+
+	filePath := path.Join(dir, candidates[n.Int64()])
+	h.serveRootFile(w, r, filePath)
+	return true
+}
+
+// isUnsafePath reports whether a request path contains a NUL byte or a ".."
+// segment. Backslashes count as separators too, as they do on Windows.
+func isUnsafePath(upath string) bool {
+	if strings.IndexByte(upath, 0) >= 0 {
+		return true
+	}
+	for _, segment := range strings.FieldsFunc(upath, func(c rune) bool { return c == '/' || c == '\\' }) {
+		if segment == ".." {
+			return true
 		}
+	}
+	return false
+}
 
-		// Serve directory listing
-		h.serveDirectoryListing(w, r, f, d)
-		return
+// isRegularFile reports whether name resolves to a regular file under the root
+func (h *StatiqHandler) isRegularFile(name string) bool {
+	return isRegularFileIn(h.root, name)
+}
+
+// isRegularFileIn reports whether name exists in root and is not a directory
+func isRegularFileIn(root http.FileSystem, name string) bool {
+	f, err := root.Open(name)
+	if err != nil {
+		return false
 	}
+	defer f.Close()
 
-	// Set cache control headers if configured
-	h.setCacheHeaders(w, r, d)
+	info, err := f.Stat()
+	return err == nil && !info.IsDir()
+}
 
-	// Get content type based on file extension
-	name := d.Name()
-	ext := filepath.Ext(name)
-	contentType := mime.TypeByExtension(ext)
-	if contentType != "" {
-		w.Header().Set("Content-Type", contentType)
+// discardBody caps, drains and closes the request body. It returns false when
+// the body exceeded the configured limit and a 413 response has been written.
+// Without a limit the body is left to net/http, which bounds its own drain.
+func (h *StatiqHandler) discardBody(w http.ResponseWriter, r *http.Request) bool {
+	if h.requestBodyLimit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
 	}
 
-	// Serve the file
-	http.ServeContent(w, r, d.Name(), d.ModTime(), f.(io.ReadSeeker))
+	if r.ContentLength > h.requestBodyLimit {
+		r.Body.Close()
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.requestBodyLimit)
+
+	_, err := io.Copy(io.Discard, r.Body)
+	r.Body.Close()
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+
+	return true
 }
 
 // serveDirectoryListing generates and serves an HTML directory listing
 func (h *StatiqHandler) serveDirectoryListing(w http.ResponseWriter, r *http.Request, f http.File, d fs.FileInfo) {
 	// List directory contents
-	dirs, err := f.Readdir(-1)
+	dirs, err := h.readDir(r.URL.Path, f, d)
 	if err != nil {
-		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+		h.serveError(w, r, err, http.StatusInternalServerError)
 		return
 	}
-	
-	// Sort directories first, then by name
-	sort.Slice(dirs, func(i, j int) bool {
-		if dirs[i].IsDir() && !dirs[j].IsDir() {
-			return true
-		}
-		if !dirs[i].IsDir() && dirs[j].IsDir() {
-			return false
+
+	// Let clients revalidate unchanged directories cheaply
+	if h.etagForDirs {
+		etag := directoryETag(dirs)
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
-		return dirs[i].Name() < dirs[j].Name()
-	})
-	
+	}
+
 	// Create slice of dirEntry for the template
 	entries := make([]dirEntry, len(dirs))
 	for i, entry := range dirs {
@@ -240,11 +2393,32 @@ func (h *StatiqHandler) serveDirectoryListing(w http.ResponseWriter, r *http.Req
 			ModTime: entry.ModTime(),
 			IsDir:   entry.IsDir(),
 		}
+		if h.humanFileSizes {
+			entries[i].HumanSize = humanizeBytes(entry.Size())
+		}
+		if h.mimeIcons != nil {
+			if entry.IsDir() {
+				entries[i].Icon = directoryIcon
+			} else {
+				entries[i].Icon = h.mimeIcon(entry.Name())
+			}
+		}
+		if h.servesThumbnails() {
+			if !entry.IsDir() {
+				entries[i].Thumbnail = h.thumbnailURL(r.URL.Path, entry.Name())
+			}
+			if entries[i].Thumbnail == "" && entries[i].Icon == "" {
+				entries[i].Icon = thumbnailPlaceholder
+				if entry.IsDir() {
+					entries[i].Icon = directoryIcon
+				}
+			}
+		}
 	}
-	
+
 	// Set content type and render the HTML
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	
+
 	// Simple directory listing template
 	tmpl := template.Must(template.New("dirlist").Parse(`
 <!DOCTYPE html>
@@ -260,6 +2434,7 @@ func (h *StatiqHandler) serveDirectoryListing(w http.ResponseWriter, r *http.Req
         th { background-color: #4CAF50; color: white; }
         a { text-decoration: none; }
         a:hover { text-decoration: underline; }
+        img.thumb { max-width: 64px; max-height: 64px; vertical-align: middle; }
     </style>
 </head>
 <body>
@@ -279,8 +2454,8 @@ func (h *StatiqHandler) serveDirectoryListing(w http.ResponseWriter, r *http.Req
         {{end}}
         {{range .Files}}
         <tr>
-            <td><a href="{{.Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
-            <td>{{if .IsDir}}-{{else}}{{.Size}} bytes{{end}}</td>
+            <td>{{if .Thumbnail}}<img class="thumb" src="{{.Thumbnail}}" alt="" loading="lazy"> {{else if .Icon}}<span class="icon">{{.Icon}}</span> {{end}}<a href="{{.Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+            <td>{{if .IsDir}}-{{else if .HumanSize}}<span data-bytes="{{.Size}}">{{.HumanSize}}</span>{{else}}{{.Size}} bytes{{end}}</td>
             <td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
         </tr>
         {{end}}
@@ -288,7 +2463,7 @@ func (h *StatiqHandler) serveDirectoryListing(w http.ResponseWriter, r *http.Req
 </body>
 </html>
 `))
-	
+
 	// Execute the template
 	data := struct {
 		Path  string
@@ -297,66 +2472,203 @@ func (h *StatiqHandler) serveDirectoryListing(w http.ResponseWriter, r *http.Req
 		Path:  r.URL.Path,
 		Files: entries,
 	}
-	
+
 	err = tmpl.Execute(w, data)
 	if err != nil {
-		http.Error(w, "Error rendering directory listing", http.StatusInternalServerError)
+		h.serveError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// humanizeBytes formats a byte count using binary units, e.g. "512 B", "34 KB", "1.2 MB"
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	suffixes := []string{"KB", "MB", "GB", "TB", "PB", "EB"}
+	i := -1
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
 	}
+
+	if value >= 10 {
+		return fmt.Sprintf("%.0f %s", value, suffixes[i])
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
 }
 
 // setCacheHeaders sets cache control headers based on file extension
 func (h *StatiqHandler) setCacheHeaders(w http.ResponseWriter, r *http.Request, d fs.FileInfo) {
-	// Get file extension
+	if value := h.cacheControlValue(r, filepath.Ext(d.Name())); value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+
+	// Set Last-Modified header
+	if !h.disableLastModified {
+		w.Header().Set("Last-Modified", d.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	w.Header().Set("ETag", h.fileETag(r.URL.Path, d))
+}
+
+// setRuleHeaders applies the Headers rules matching a file. Rules are applied
+// in order, path-only rules before extension rules, so a later or
+// extension-specific value overwrites an earlier one.
+func (h *StatiqHandler) setRuleHeaders(w http.ResponseWriter, r *http.Request, d fs.FileInfo) {
+	if len(h.headerRules) == 0 {
+		return
+	}
+
 	ext := filepath.Ext(d.Name())
-	
-	// Check if we have a cache control setting for this extension
-	if maxAge, ok := h.cacheControl[ext]; ok {
-		w.Header().Set("Cache-Control", maxAge)
-	} else if maxAge, ok := h.cacheControl["*"]; ok {
+	for _, byExtension := range []bool{false, true} {
+		for _, rule := range h.headerRules {
+			if (rule.Extension != "") != byExtension {
+				continue
+			}
+			if rule.Extension != "" && !strings.EqualFold(rule.Extension, ext) {
+				continue
+			}
+			if rule.Pattern != "" && !matchPathPattern(rule.Pattern, r.URL.Path) {
+				continue
+			}
+			for name, value := range rule.Values {
+				w.Header().Set(name, value)
+			}
+		}
+	}
+}
+
+// setServiceWorkerHeader lets the service worker script claim any scope, so
+// pages outside the script's directory can register it for their own
+func (h *StatiqHandler) setServiceWorkerHeader(w http.ResponseWriter, r *http.Request) {
+	if h.serviceWorkerPath != "" && r.URL.Path == h.serviceWorkerPath {
+		w.Header().Set("Service-Worker-Allowed", "/")
+	}
+}
+
+// cacheControlValue picks the Cache-Control value for a request, from the
+// most to the least specific rule. It is empty when ExplicitCacheHeaders is
+// set and no rule matches.
+func (h *StatiqHandler) cacheControlValue(r *http.Request, ext string) string {
+	// Superseded asset versions are immutable, whatever the extension rules say
+	if h.isOldVersion(r.URL.Path) {
+		return immutableCacheControl
+	}
+
+	// Path-based rules take precedence over extension-based rules
+	for _, rule := range h.pathCacheRules {
+		if matchPathPattern(rule.Pattern, r.URL.Path) {
+			return rule.Value
+		}
+	}
+
+	// Extensions marked as no-cache bypass the CacheControl map entirely
+	if h.noCacheExtensions[ext] {
+		return "no-store"
+	}
+
+	cacheControl := h.cacheControlFor(r)
+	if maxAge, ok := cacheControl[ext]; ok {
+		// Check if we have a cache control setting for this extension
+		return maxAge
+	}
+	if maxAge, ok := cacheControl["*"]; ok {
 		// Use default setting if available
-		w.Header().Set("Cache-Control", maxAge)
-	} else {
-		// Default cache control
-		w.Header().Set("Cache-Control", "max-age=86400") // 24 hours
+		return maxAge
 	}
-	
-	// Set Last-Modified header
-	w.Header().Set("Last-Modified", d.ModTime().UTC().Format(http.TimeFormat))
+
+	// Without a matching rule nothing is sent when caching must be opted into
+	if h.explicitCacheHeaders {
+		return ""
+	}
+
+	// Default cache control
+	return "max-age=86400" // 24 hours
 }
 
 // serveFile serves a file directly from the filesystem
-// Change the parameter name
 func (h *StatiqHandler) serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
-    f, err := os.Open(filePath)
-    if err != nil {
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
-    }
-    defer f.Close()
-
-    d, err := f.Stat()
-    if err != nil {
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-        return
-    }
-
-    h.setCacheHeaders(w, r, d)
-    
-    // Now filepath refers to the package, not the parameter
-    ext := filepath.Ext(d.Name())
-    contentType := mime.TypeByExtension(ext)
-    if contentType != "" {
-        w.Header().Set("Content-Type", contentType)
-    }
-    
-    http.ServeContent(w, r, d.Name(), d.ModTime(), f)
+	f, err := os.Open(filePath)
+	if err != nil {
+		h.serveError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	h.serveOpenFile(w, r, f)
+}
+
+// serveRootFile serves the file at a URL path of the root, from memory when
+// ServeFromMemory is set
+func (h *StatiqHandler) serveRootFile(w http.ResponseWriter, r *http.Request, urlPath string) {
+	f, err := h.root.Open(urlPath)
+	if err != nil {
+		h.serveError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	h.serveOpenFile(w, r, f)
+}
+
+// serveOpenFile serves and closes f with its cache headers and content type
+func (h *StatiqHandler) serveOpenFile(w http.ResponseWriter, r *http.Request, f http.File) {
+	defer f.Close()
+
+	d, err := f.Stat()
+	if err != nil {
+		h.serveError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	h.setCacheHeaders(w, r, d)
+	h.setRuleHeaders(w, r, d)
+	h.setServiceWorkerHeader(w, r)
+
+	contentType := h.fileContentType(d)
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	h.serveContent(w, r, d, f)
+}
+
+// stripTrailingSlash redirects to the request path without its trailing
+// slashes. Leading slashes are collapsed so the Location cannot turn into a
+// protocol-relative URL such as //example.com.
+func (h *StatiqHandler) stripTrailingSlash(w http.ResponseWriter, r *http.Request) {
+	h.localRedirect(w, r, "/"+strings.Trim(r.URL.Path, "/"))
+}
+
+// stripURLPrefix returns a copy of the request with StripPrefix removed from
+// its path. It returns false when the path is outside the prefix; /static
+// covers /static and /static/app.js but not /staticfiles.
+func (h *StatiqHandler) stripURLPrefix(r *http.Request) (*http.Request, bool) {
+	rest := strings.TrimPrefix(r.URL.Path, h.stripPrefix)
+	if len(rest) == len(r.URL.Path) || rest != "" && rest[0] != '/' {
+		return nil, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+
+	stripped := new(http.Request)
+	*stripped = *r
+	stripped.URL = new(url.URL)
+	*stripped.URL = *r.URL
+	stripped.URL.Path = rest
+	stripped.URL.RawPath = ""
+	return stripped, true
 }
 
 // localRedirect gives a Moved Permanently response
-func localRedirect(w http.ResponseWriter, r *http.Request, newPath string) {
-	if q := r.URL.RawQuery; q != "" {
+func (h *StatiqHandler) localRedirect(w http.ResponseWriter, r *http.Request, newPath string) {
+	// Redirects point back below the prefix the client requested
+	if h.stripPrefix != "" && strings.HasPrefix(newPath, "/") {
+		newPath = h.stripPrefix + newPath
+	}
+	if q := r.URL.RawQuery; q != "" && !h.ignoreRedirectQuery {
 		newPath += "?" + q
 	}
 	w.Header().Set("Location", newPath)
 	w.WriteHeader(http.StatusMovedPermanently)
-}
\ No newline at end of file
+}
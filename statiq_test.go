@@ -1,15 +1,29 @@
 package statiq_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	statiq "github.com/hhftechnology/statiq"
+	"github.com/hhftechnology/statiq/middleware"
 )
 
 func TestStatiqBasicServing(t *testing.T) {
@@ -17,7 +31,7 @@ func TestStatiqBasicServing(t *testing.T) {
 
 	// Create config with current directory as root
 	cfg := statiq.CreateConfig()
-	
+
 	// Create a next handler that should never be called
 	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		// Should NEVER go through the next handler
@@ -56,53 +70,53 @@ func TestStatiqBasicServing(t *testing.T) {
 
 func TestStatiqWithCustomRoot(t *testing.T) {
 	t.Parallel()
-	
+
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "statiq-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tempDir)
-	
+
 	// Create a test file in the temp directory
 	testFilePath := filepath.Join(tempDir, "test.txt")
 	testContent := "Hello, Statiq!"
 	if err := os.WriteFile(testFilePath, []byte(testContent), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Configure Statiq with the temp directory as root
 	cfg := statiq.CreateConfig()
 	cfg.Root = tempDir
-	
+
 	// Create a next handler that should never be called
 	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		t.Fatal("next handler was called unexpectedly")
 	})
-	
+
 	// Create the handler
 	handler, err := statiq.New(context.Background(), next, cfg, "statiq")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Create a test recorder
 	recorder := httptest.NewRecorder()
-	
+
 	// Request the test file
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/test.txt", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Serve the request
 	handler.ServeHTTP(recorder, req)
-	
+
 	// Verify response code
 	if recorder.Code != http.StatusOK {
 		t.Errorf("invalid recorder status code, expected: %d, got: %d", http.StatusOK, recorder.Code)
 	}
-	
+
 	// Verify content
 	if recorder.Body.String() != testContent {
 		t.Errorf("invalid body content, expected: %q, got: %q", testContent, recorder.Body.String())
@@ -111,137 +125,245 @@ func TestStatiqWithCustomRoot(t *testing.T) {
 
 func TestIndexFiles(t *testing.T) {
 	t.Parallel()
-	
+
 	// Create a temporary directory structure
 	tempDir, err := os.MkdirTemp("", "statiq-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tempDir)
-	
+
 	// Create a subdirectory
 	subDir := filepath.Join(tempDir, "subdir")
 	if err := os.Mkdir(subDir, 0755); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Create a custom index file
 	indexPath := filepath.Join(subDir, "custom.html")
 	indexContent := "<html><body>Custom Index</body></html>"
 	if err := os.WriteFile(indexPath, []byte(indexContent), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Configure Statiq with custom index files
 	cfg := statiq.CreateConfig()
 	cfg.Root = tempDir
 	cfg.IndexFiles = []string{"custom.html", "index.html"}
-	
+
 	// Create the handler
 	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Test directory request with trailing slash
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/subdir/", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	recorder := httptest.NewRecorder()
 	handler.ServeHTTP(recorder, req)
-	
+
 	if recorder.Code != http.StatusMovedPermanently {
 		t.Errorf("Expected redirect for directory, got status code %d", recorder.Code)
 	}
-	
+
 	// Get the redirect location and follow it
 	location := recorder.Header().Get("Location")
 	if location != "/subdir/custom.html" {
 		t.Errorf("Expected redirect to /subdir/custom.html, got %s", location)
 	}
-	
+
 	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost"+location, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	recorder = httptest.NewRecorder()
 	handler.ServeHTTP(recorder, req)
-	
+
 	if recorder.Code != http.StatusOK {
 		t.Errorf("Expected 200 OK, got %d", recorder.Code)
 	}
-	
+
 	if recorder.Body.String() != indexContent {
 		t.Errorf("Expected index content, got %s", recorder.Body.String())
 	}
 }
 
+func TestIndexForExtensions(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"about/index.html": "<html>About</html>",
+		"v1.2/index.html":  "<html>Release</html>",
+		"empty/.keep":      "",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.IndexForExtensions = []string{""}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Extension-less directory requests serve the index at the original URL
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/about", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "<html>About</html>" {
+		t.Errorf("Expected index served in place for /about, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Extensions not in the list keep the redirect behaviour
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/v1.2", nil))
+	if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != "/v1.2/" {
+		t.Errorf("Expected redirect to /v1.2/, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	// Directories without an index still redirect
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/empty", nil))
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected redirect for directory without index, got %d", recorder.Code)
+	}
+}
+
+func TestIgnoreQueryOnRedirect(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{"about/index.html": "<html>About</html>"})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Query parameters are preserved by default
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/about/?utm_source=mail", nil))
+	if location := recorder.Header().Get("Location"); location != "/about/index.html?utm_source=mail" {
+		t.Errorf("Expected query preserved in redirect, got %s", location)
+	}
+
+	cfg.IgnoreQueryOnRedirect = true
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/about/?utm_source=mail", nil))
+	if location := recorder.Header().Get("Location"); location != "/about/index.html" {
+		t.Errorf("Expected query dropped from redirect, got %s", location)
+	}
+}
+
+func TestIgnoreTrailingSlashForFiles(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"file.txt":       "plain file",
+		"docs/index.htm": "docs index",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// By default /file.txt/ keeps resolving to the file
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/file.txt/", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "plain file" {
+		t.Errorf("Expected /file.txt/ served without redirect, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	cfg.IgnoreTrailingSlashForFiles = false
+	strict, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder = serve(strict, newRequest(t, http.MethodGet, "http://localhost/file.txt/", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for /file.txt/ when disabled, got %d", recorder.Code)
+	}
+
+	// Directories keep their normal index handling
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/docs/", nil))
+	if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != "/docs/index.htm" {
+		t.Errorf("Expected directory redirect to index, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+}
+
 func TestSPAMode(t *testing.T) {
 	t.Parallel()
-	
+
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "statiq-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tempDir)
-	
+
 	// Create an index.html file for SPA
 	spaContent := "<html><body>SPA Root</body></html>"
 	if err := os.WriteFile(filepath.Join(tempDir, "index.html"), []byte(spaContent), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Create a real file that should be served directly
 	realFileContent := "This is a real file"
 	if err := os.WriteFile(filepath.Join(tempDir, "real.txt"), []byte(realFileContent), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Configure Statiq with SPA mode
 	cfg := statiq.CreateConfig()
 	cfg.Root = tempDir
 	cfg.SPAMode = true
-	
+
 	// Create the handler
 	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Test real file request
 	recorder := httptest.NewRecorder()
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/real.txt", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	handler.ServeHTTP(recorder, req)
-	
+
 	if recorder.Code != http.StatusOK {
 		t.Errorf("Expected 200 OK for real file, got %d", recorder.Code)
 	}
-	
+
 	if recorder.Body.String() != realFileContent {
 		t.Errorf("Expected real file content, got %s", recorder.Body.String())
 	}
-	
+
 	// Test non-existent route that should fall back to index.html
 	recorder = httptest.NewRecorder()
 	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/non-existent-route", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	handler.ServeHTTP(recorder, req)
-	
+
 	if recorder.Code != http.StatusOK {
 		t.Errorf("Expected 200 OK for SPA route, got %d", recorder.Code)
 	}
-	
+
 	if recorder.Body.String() != spaContent {
 		t.Errorf("Expected SPA content, got %s", recorder.Body.String())
 	}
@@ -249,45 +371,45 @@ func TestSPAMode(t *testing.T) {
 
 func TestCustomErrorPage(t *testing.T) {
 	t.Parallel()
-	
+
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "statiq-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tempDir)
-	
+
 	// Create a custom 404 page
 	errorContent := "<html><body>Custom 404 Error</body></html>"
 	if err := os.WriteFile(filepath.Join(tempDir, "404.html"), []byte(errorContent), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Configure Statiq with custom error page
 	cfg := statiq.CreateConfig()
 	cfg.Root = tempDir
 	cfg.ErrorPage404 = "404.html"
-	
+
 	// Create the handler
 	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Test non-existent file request
 	recorder := httptest.NewRecorder()
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/non-existent.txt", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	handler.ServeHTTP(recorder, req)
-	
+
 	// Should serve the custom error page with 200 status
 	if recorder.Code != http.StatusOK {
 		t.Errorf("Expected 200 OK for custom error page, got %d", recorder.Code)
 	}
-	
+
 	if !strings.Contains(recorder.Body.String(), "Custom 404 Error") {
 		t.Errorf("Expected custom error content, got %s", recorder.Body.String())
 	}
@@ -295,23 +417,23 @@ func TestCustomErrorPage(t *testing.T) {
 
 func TestCacheControl(t *testing.T) {
 	t.Parallel()
-	
+
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "statiq-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tempDir)
-	
+
 	// Create test files with different extensions
 	if err := os.WriteFile(filepath.Join(tempDir, "test.html"), []byte("<html></html>"), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	if err := os.WriteFile(filepath.Join(tempDir, "test.css"), []byte("body {}"), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Configure Statiq with cache control settings
 	cfg := statiq.CreateConfig()
 	cfg.Root = tempDir
@@ -320,106 +442,217 @@ func TestCacheControl(t *testing.T) {
 		".css":  "max-age=86400",
 		"*":     "max-age=600",
 	}
-	
+
 	// Create the handler
 	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Test HTML file
 	recorder := httptest.NewRecorder()
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/test.html", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	handler.ServeHTTP(recorder, req)
-	
+
 	if recorder.Header().Get("Cache-Control") != "max-age=3600" {
 		t.Errorf("Expected Cache-Control: max-age=3600 for HTML, got %s", recorder.Header().Get("Cache-Control"))
 	}
-	
+
 	// Test CSS file
 	recorder = httptest.NewRecorder()
 	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/test.css", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	handler.ServeHTTP(recorder, req)
-	
+
 	if recorder.Header().Get("Cache-Control") != "max-age=86400" {
 		t.Errorf("Expected Cache-Control: max-age=86400 for CSS, got %s", recorder.Header().Get("Cache-Control"))
 	}
 }
 
+func TestNoCacheExtensions(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"index.html": "<html></html>",
+		"data.json":  "{}",
+		"app.js":     "void 0",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.NoCacheExtensions = []string{".html", ".json"}
+	cfg.CacheControl = map[string]string{
+		".html": "max-age=3600",
+		"*":     "max-age=600",
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/index.html": "no-store",
+		"/data.json":  "no-store",
+		"/app.js":     "max-age=600",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Cache-Control"); got != expected {
+			t.Errorf("Expected Cache-Control: %s for %s, got %s", expected, target, got)
+		}
+	}
+}
+
+func TestLegacyBrowserRedirect(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"index.html":   "<html>Modern</html>",
+		"upgrade.html": "<html>Please upgrade</html>",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.LegacyBrowserRedirect = "/upgrade.html"
+	cfg.LegacyBrowserUA = []string{"MSIE ", "Trident/"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/index.html", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Trident/7.0; rv:11.0) like Gecko")
+	recorder := serve(handler, req)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/upgrade.html" {
+		t.Errorf("Expected 302 to /upgrade.html for legacy browser, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	// The compatibility page itself must be reachable
+	req = newRequest(t, http.MethodGet, "http://localhost/upgrade.html", nil)
+	req.Header.Set("User-Agent", "Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.1)")
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK for compatibility page, got %d", recorder.Code)
+	}
+
+	req = newRequest(t, http.MethodGet, "http://localhost/index.html", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK for modern browser, got %d", recorder.Code)
+	}
+}
+
+func TestHSTSPreload(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.SecurityHeaders.HSTSMaxAge = 63072000
+	cfg.SecurityHeaders.HSTSIncludeSubdomains = true
+	cfg.HSTSPreload = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil))
+	expected := "max-age=63072000; includeSubDomains; preload"
+	if got := recorder.Header().Get("Strict-Transport-Security"); got != expected {
+		t.Errorf("Expected Strict-Transport-Security: %s, got %s", expected, got)
+	}
+
+	// Preload without includeSubDomains is rejected
+	cfg.SecurityHeaders.HSTSIncludeSubdomains = false
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected error for hstsPreload without hstsIncludeSubdomains")
+	}
+
+	// Preload with a short max-age is rejected
+	cfg.SecurityHeaders.HSTSIncludeSubdomains = true
+	cfg.SecurityHeaders.HSTSMaxAge = 3600
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected error for hstsPreload with max-age below 31536000")
+	}
+}
+
 func TestDirectoryListing(t *testing.T) {
 	t.Parallel()
-	
+
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "statiq-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(tempDir)
-	
+
 	// Create a subdirectory
 	subDir := filepath.Join(tempDir, "subdir")
 	if err := os.Mkdir(subDir, 0755); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Create a file in the subdirectory
 	if err := os.WriteFile(filepath.Join(subDir, "test.txt"), []byte("test"), 0644); err != nil {
 		t.Fatal(err)
 	}
-	
+
 	// Test with directory listing disabled (default)
 	cfg := statiq.CreateConfig()
 	cfg.Root = tempDir
-	
+
 	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	recorder := httptest.NewRecorder()
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/subdir/", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	handler.ServeHTTP(recorder, req)
-	
+
 	// Should return 404 when listing is disabled
 	if recorder.Code != http.StatusNotFound {
 		t.Errorf("Expected 404 Not Found for disabled directory listing, got %d", recorder.Code)
 	}
-	
+
 	// Test with directory listing enabled
 	cfg = statiq.CreateConfig()
 	cfg.Root = tempDir
 	cfg.EnableDirectoryListing = true
-	
+
 	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	recorder = httptest.NewRecorder()
 	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/subdir/", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	handler.ServeHTTP(recorder, req)
-	
+
 	// Should return 200 when listing is enabled
 	if recorder.Code != http.StatusOK {
 		t.Errorf("Expected 200 OK for enabled directory listing, got %d", recorder.Code)
 	}
-	
+
 	// Directory listing should contain the filename
 	body := recorder.Body.String()
 	if !strings.Contains(body, "test.txt") {
@@ -427,10 +660,2264 @@ func TestDirectoryListing(t *testing.T) {
 	}
 }
 
+func TestRequestBodyLimit(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{"test.txt": "body limit"})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.RequestBodyLimit = 16
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A small body is drained and the file is served normally
+	req := newRequest(t, http.MethodPost, "http://localhost/test.txt", strings.NewReader("small"))
+	recorder := serve(handler, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK for small body, got %d", recorder.Code)
+	}
+
+	// A declared Content-Length over the limit is rejected up front
+	req = newRequest(t, http.MethodPost, "http://localhost/test.txt", strings.NewReader(strings.Repeat("x", 32)))
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized body, got %d", recorder.Code)
+	}
+
+	// A body of unknown length is rejected once reading passes the limit
+	req = newRequest(t, http.MethodPost, "http://localhost/test.txt", io.MultiReader(strings.NewReader(strings.Repeat("x", 32))))
+	req.ContentLength = -1
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized streamed body, got %d", recorder.Code)
+	}
+
+	// With the limit disabled the body is never read by the handler
+	cfg.RequestBodyLimit = 0
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := &countingReader{r: strings.NewReader(strings.Repeat("x", 32))}
+	req = newRequest(t, http.MethodPost, "http://localhost/test.txt", body)
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK without a body limit, got %d", recorder.Code)
+	}
+	if body.n != 0 {
+		t.Errorf("Expected the body to be left unread without a limit, read %d bytes", body.n)
+	}
+}
+
+// countingReader records how many bytes have been read from it
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// newTestRoot creates a temporary root directory populated with the given files
+func newTestRoot(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	tempDir := t.TempDir()
+	for name, content := range files {
+		filePath := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return tempDir
+}
+
+// newRequest builds a request and fails the test if it cannot be created
+func newRequest(t *testing.T, method, target string, body io.Reader) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), method, target, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return req
+}
+
+// serve runs a request through the handler and returns the recorded response
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
 // Helper function to create a next handler that fails the test if called
 func next(t *testing.T) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Helper()
 		t.Fatal("next handler was called unexpectedly")
 	})
-}
\ No newline at end of file
+}
+
+func TestDirectoryListingHumanReadableSizes(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"files/small.txt": "tiny",
+		"files/large.bin": strings.Repeat("x", 1258291),
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.EnableDirectoryListing = true
+	cfg.FileSizeHumanReadable = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/files/", nil))
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		`<span data-bytes="4">4 B</span>`,
+		`<span data-bytes="1258291">1.2 MB</span>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Directory listing should contain %q, got: %s", expected, body)
+		}
+	}
+}
+
+func TestRandomDefaultFile(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"wallpapers/a.jpg":         "a",
+		"wallpapers/b.jpg":         "b",
+		"wallpapers/.hidden":       "hidden",
+		"wallpapers/nested/c.jpg":  "c",
+		"empty/.keep":              "",
+		"empty/only-dirs/file.txt": "nested",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.RandomDefaultFile = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/wallpapers/", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected 200 OK for random file, got %d", recorder.Code)
+		}
+		seen[recorder.Body.String()] = true
+	}
+	for body := range seen {
+		if body != "a" && body != "b" {
+			t.Errorf("Random file should exclude directories and dot-files, got %q", body)
+		}
+	}
+
+	// Directories without regular files still return 404
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/empty/", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for directory without candidates, got %d", recorder.Code)
+	}
+}
+
+func TestContentLengthThreshold(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"small.log": "short",
+		"large.log": strings.Repeat("line\n", 100),
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.ContentLengthThreshold = 64
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/small.log", nil))
+	if recorder.Header().Get("Content-Length") != "5" {
+		t.Errorf("Expected Content-Length: 5 below the threshold, got %q", recorder.Header().Get("Content-Length"))
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/large.log", nil))
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Errorf("Expected no Content-Length above the threshold, got %q", recorder.Header().Get("Content-Length"))
+	}
+	if recorder.Body.Len() != 500 {
+		t.Errorf("Expected full body above the threshold, got %d bytes", recorder.Body.Len())
+	}
+}
+
+func TestDisableLastModified(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil))
+	if recorder.Header().Get("Last-Modified") == "" {
+		t.Error("Expected Last-Modified by default")
+	}
+
+	cfg.DisableLastModified = true
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", recorder.Code)
+	}
+	if lastModified := recorder.Header().Get("Last-Modified"); lastModified != "" {
+		t.Errorf("Expected no Last-Modified header, got %s", lastModified)
+	}
+}
+
+func TestAutoExpireOldVersions(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"assets/v1/app.js":   "v1",
+		"assets/v2.9/app.js": "v2.9",
+		"assets/v3/app.js":   "v3",
+		"assets/v10/app.js":  "v10",
+		"video/app.js":       "not a version",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.AutoExpireOldVersions = true
+	cfg.CurrentVersion = "v3"
+	cfg.CacheControl = map[string]string{".js": "no-cache"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/assets/v1/app.js":   "public, max-age=31536000, immutable",
+		"/assets/v2.9/app.js": "public, max-age=31536000, immutable",
+		"/assets/v3/app.js":   "no-cache",
+		"/assets/v10/app.js":  "no-cache",
+		"/video/app.js":       "no-cache",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Cache-Control"); got != expected {
+			t.Errorf("Expected Cache-Control: %s for %s, got %s", expected, target, got)
+		}
+	}
+
+	cfg.CurrentVersion = "latest"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected error for an unparsable currentVersion")
+	}
+}
+
+func TestPerPathCacheControl(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"api/config.json":       "{}",
+		"api/v1/users.json":     "[]",
+		"assets/config.json":    "{}",
+		"downloads/archive.zip": "zip",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.CacheControl = map[string]string{".json": "max-age=86400"}
+	cfg.PerPathCacheControl = []statiq.PathCacheRule{
+		{Pattern: "/api/**", Value: "no-cache"},
+		{Pattern: "/downloads/", Value: "private, max-age=60"},
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/api/config.json":       "no-cache",
+		"/api/v1/users.json":     "no-cache",
+		"/assets/config.json":    "max-age=86400",
+		"/downloads/archive.zip": "private, max-age=60",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Cache-Control"); got != expected {
+			t.Errorf("Expected Cache-Control: %s for %s, got %s", expected, target, got)
+		}
+	}
+}
+
+func TestETagForDirs(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{"files/a.txt": "a"})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.EnableDirectoryListing = true
+	cfg.ETagForDirs = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/files/", nil))
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with ETag for directory listing, got %d %q", recorder.Code, etag)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/files/", nil)
+	req.Header.Set("If-None-Match", etag)
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for unchanged directory, got %d", recorder.Code)
+	}
+
+	// Adding a file changes the listing and its ETag
+	if err := os.WriteFile(filepath.Join(tempDir, "files", "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req = newRequest(t, http.MethodGet, "http://localhost/files/", nil)
+	req.Header.Set("If-None-Match", etag)
+	recorder = serve(handler, req)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag after the directory changed, got %d", recorder.Code)
+	}
+}
+
+func TestETagAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{"a.css": "body { color: red; }"})
+
+	etags := map[string]string{}
+	for _, algorithm := range []string{"", "crc32", "sha1", "md5"} {
+		cfg := statiq.CreateConfig()
+		cfg.Root = tempDir
+		cfg.ETagAlgorithm = algorithm
+		cfg.MinifyCSS = true
+
+		handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/a.css", nil))
+		etag := recorder.Header().Get("ETag")
+		if recorder.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("Expected 200 with a weak ETag for %q, got %d %q", algorithm, recorder.Code, etag)
+		}
+		etags[algorithm] = etag
+
+		req := newRequest(t, http.MethodGet, "http://localhost/a.css", nil)
+		req.Header.Set("If-None-Match", etag)
+		recorder = serve(handler, req)
+		if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+			t.Errorf("Expected an empty 304 for %q, got %d", algorithm, recorder.Code)
+		}
+
+		req = newRequest(t, http.MethodGet, "http://localhost/a.css", nil)
+		req.Header.Set("If-None-Match", `W/"stale"`)
+		if recorder = serve(handler, req); recorder.Code != http.StatusOK {
+			t.Errorf("Expected 200 for a stale ETag with %q, got %d", algorithm, recorder.Code)
+		}
+	}
+
+	if etags[""] != etags["crc32"] || etags["sha1"] == etags["md5"] {
+		t.Errorf("Expected CRC32 by default and distinct hashes per algorithm, got %v", etags)
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.ETagAlgorithm = "sha256"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an unknown ETag algorithm")
+	}
+}
+
+func TestETagSeed(t *testing.T) {
+	t.Parallel()
+
+	// Two instances whose copies of the file differ by a few milliseconds
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	roots := make([]string, 2)
+	for i := range roots {
+		roots[i] = newTestRoot(t, map[string]string{"app.js": "code"})
+		stamp := modTime.Add(time.Duration(i*7) * time.Millisecond)
+		if err := os.Chtimes(filepath.Join(roots[i], "app.js"), stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	etagFor := func(root, seed string) string {
+		t.Helper()
+		cfg := statiq.CreateConfig()
+		cfg.Root = root
+		cfg.ETagSeed = seed
+		handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil)).Header().Get("ETag")
+	}
+
+	seeded := etagFor(roots[0], "2024-03-01T12:00:00Z")
+	if !strings.HasPrefix(seeded, `W/"`) || etagFor(roots[1], "2024-03-01T12:00:00Z") != seeded {
+		t.Errorf("Expected instances sharing a seed to agree on the ETag, got %q", seeded)
+	}
+	if etagFor(roots[0], "2024-03-02T12:00:00Z") == seeded {
+		t.Error("Expected another seed to change the ETag")
+	}
+	if etagFor(roots[0], "") == etagFor(roots[1], "") {
+		t.Error("Expected unseeded ETags to tell the copies apart")
+	}
+
+	// Files sharing a name, size and time in different directories differ
+	root := newTestRoot(t, map[string]string{"a/app.js": "code", "b/app.js": "code"})
+	for _, name := range []string{"a/app.js", "b/app.js"} {
+		if err := os.Chtimes(filepath.Join(root, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := statiq.CreateConfig()
+	cfg.Root = root
+	cfg.ETagSeed = "2024-03-01T12:00:00Z"
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := serve(handler, newRequest(t, http.MethodGet, "http://localhost/a/app.js", nil)).Header().Get("ETag")
+	b := serve(handler, newRequest(t, http.MethodGet, "http://localhost/b/app.js", nil)).Header().Get("ETag")
+	if a == "" || a == b {
+		t.Errorf("Expected distinct ETags for a/app.js and b/app.js, got %q and %q", a, b)
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	t.Parallel()
+
+	var order []string
+	tag := func(name string) statiq.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				w.Header().Add("X-Chain", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+	cfg.MiddlewareChain = []statiq.Middleware{tag("outer"), tag("inner"), middleware.RecoveryMiddleware}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK through the chain, got %d", recorder.Code)
+	}
+	if strings.Join(order, ",") != "outer,inner" {
+		t.Errorf("Expected first middleware outermost, got %v", order)
+	}
+}
+
+func TestDetachLargeFileSend(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("0123456789", 1024)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"large.bin": large, "small.txt": "small"})
+	cfg.DetachLargeFileSend = true
+	cfg.DetachThreshold = 1024
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req := newRequest(t, http.MethodGet, server.URL+path, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	// Files over the threshold are sent from the hijacked connection, which is then closed
+	resp, body := get("/large.bin", nil)
+	if resp.StatusCode != http.StatusOK || body != large {
+		t.Errorf("Expected the full large file, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if !resp.Close {
+		t.Error("Expected Connection: close on a detached send")
+	}
+	if resp.Header.Get("Cache-Control") == "" {
+		t.Error("Expected cache headers to be kept on a detached send")
+	}
+
+	// Small files and range requests stay on the regular path
+	resp, body = get("/small.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != "small" || resp.Close {
+		t.Errorf("Expected small file served normally, got %d %q close=%v", resp.StatusCode, body, resp.Close)
+	}
+	resp, body = get("/large.bin", http.Header{"Range": {"bytes=0-9"}})
+	if resp.StatusCode != http.StatusPartialContent || body != "0123456789" {
+		t.Errorf("Expected 206 for a range request, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestDetachLargeFileSendHoldsRequestSlot(t *testing.T) {
+	t.Parallel()
+
+	// Large enough that the send blocks on a client that stops reading
+	large := strings.Repeat("0123456789", 4<<20)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"large.bin": large, "small.txt": "small"})
+	cfg.DetachLargeFileSend = true
+	cfg.DetachThreshold = 1024
+	cfg.RequestCountLimit = 1
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(conn, "GET /large.bin HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	status := func() int {
+		t.Helper()
+		resp, err := server.Client().Get(server.URL + "/small.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The unfinished detached send still counts against the limit
+	if got := status(); got != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while the detached send is running, got %d", got)
+	}
+
+	// Its slot is released once the send ends
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for status() != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the request slot back after the detached send ended")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDetachLargeFileSendWithCSP(t *testing.T) {
+	t.Parallel()
+
+	large := "<html><body>" + strings.Repeat("0123456789", 1024) + "</body></html>"
+	logs := &lockedBuffer{}
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"large.html": large})
+	cfg.DetachLargeFileSend = true
+	cfg.DetachThreshold = 1024
+	cfg.CSP = "default-src 'self'"
+	cfg.AccessLog = true
+	cfg.AccessLogWriter = logs
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/large.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hook-applied CSP header travels with the detached send
+	if resp.StatusCode != http.StatusOK || string(body) != large || !resp.Close {
+		t.Errorf("Expected a detached send of the full page, got %d with %d bytes close=%v", resp.StatusCode, len(body), resp.Close)
+	}
+	if got := resp.Header.Get("Content-Security-Policy"); got != cfg.CSP {
+		t.Errorf("Expected CSP on the detached send, got %q", got)
+	}
+
+	// The access log records the size of the detached body
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), `"bytes":`+strconv.Itoa(len(large))) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the detached body size in the access log, got %q", logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for a server goroutine to write while the test reads
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+func TestHotReplaceFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"app.js":             "new build",
+		"maintenance/app.js": "maintenance build",
+		"next/app.js":        "next build",
+	})
+	cfg.HotReplaceFiles = map[string]string{"/app.js": "maintenance/app.js", "/missing.js": "/gone.js"}
+	cfg.HotReplacer = &statiq.HotReplacer{}
+	// The swap must keep working once the handler is wrapped
+	cfg.AccessLog = true
+	cfg.AccessLogWriter = io.Discard
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "maintenance build" {
+		t.Errorf("Expected the replacement file, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	// A replacement that does not exist falls back to the regular lookup
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/missing.js", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing replacement, got %d", recorder.Code)
+	}
+
+	// The map can be swapped at runtime
+	if _, ok := handler.(*statiq.StatiqHandler); ok {
+		t.Fatal("Expected the access log to wrap the handler")
+	}
+	cfg.HotReplacer.Set(map[string]string{"/app.js": "next/app.js"})
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil))
+	if recorder.Body.String() != "next build" {
+		t.Errorf("Expected the swapped replacement, got %q", recorder.Body.String())
+	}
+
+	cfg.HotReplacer.Set(nil)
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil))
+	if recorder.Body.String() != "new build" {
+		t.Errorf("Expected the original file once replacements are cleared, got %q", recorder.Body.String())
+	}
+}
+
+func TestVersionFile(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"release/version.json": `{"version":"on-disk"}`})
+	cfg.VersionFile = "version.json"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/version.json", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected generated JSON, got %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	expected := `{"version":"` + statiq.Version + `","built":"` + statiq.BuildTime + `","plugin":"statiq"}`
+	if recorder.Body.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, recorder.Body.String())
+	}
+
+	// A real file on disk always wins
+	cfg.VersionFile = "release/version.json"
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/release/version.json", nil))
+	if recorder.Body.String() != `{"version":"on-disk"}` {
+		t.Errorf("Expected the file on disk, got %s", recorder.Body.String())
+	}
+}
+
+func TestReadDirCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"files/a.txt": "a"})
+	cfg.EnableDirectoryListing = true
+	cfg.ReadDirCacheTTL = time.Hour
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/files/", nil))
+	if !strings.Contains(recorder.Body.String(), "a.txt") {
+		t.Fatalf("Expected a.txt in the listing, got %s", recorder.Body.String())
+	}
+
+	// Changing a file's contents leaves the directory untouched, so the cached listing is reused
+	filePath := filepath.Join(cfg.Root, "files", "a.txt")
+	if err := os.WriteFile(filePath, []byte("a longer body"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/files/", nil))
+	if strings.Contains(recorder.Body.String(), "13 bytes") {
+		t.Error("Expected the cached listing to be served")
+	}
+
+	// Adding an entry changes the directory and invalidates the cached listing
+	dir := filepath.Join(cfg.Root, "files")
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(dir, future, future); err != nil {
+		t.Fatal(err)
+	}
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/files/", nil))
+	if !strings.Contains(recorder.Body.String(), "b.txt") {
+		t.Errorf("Expected b.txt after the directory changed, got %s", recorder.Body.String())
+	}
+}
+
+func TestDirectoryListingMimeIcons(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"files/photo.png":  "png",
+		"files/manual.pdf": "pdf",
+		"files/main.go":    "package main",
+		"files/data.bin":   "bin",
+		"files/sub/x.txt":  "x",
+	})
+	cfg.EnableDirectoryListing = true
+	cfg.DirectoryListingMimeIcons = true
+	cfg.DirectoryListingMimeIconMap = map[string]string{"text/x-go": "🐹"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := serve(handler, newRequest(t, http.MethodGet, "http://localhost/files/", nil)).Body.String()
+	for name, icon := range map[string]string{"photo.png": "🖼️", "manual.pdf": "📕", "main.go": "🐹", "data.bin": "📎", "sub/": "📁"} {
+		if !strings.Contains(body, `<span class="icon">`+icon+`</span> <a href="`+name+`">`) {
+			t.Errorf("Expected icon %s for %s", icon, name)
+		}
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html></html>"})
+	cfg.ProxyHeaders = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"http://localhost/index.html", "http://localhost/missing.html"} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, target, nil))
+		if got := recorder.Header().Get("X-Served-By"); got != "statiq" {
+			t.Errorf("Expected X-Served-By: statiq for %s, got %q", target, got)
+		}
+	}
+}
+
+// blockingWriter holds the response open until release is closed
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bw *blockingWriter) Write(p []byte) (int, error) {
+	close(bw.started)
+	<-bw.release
+	return bw.ResponseRecorder.Write(p)
+}
+
+func TestRequestCountLimit(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"a.txt": "a"})
+	cfg.RequestCountLimit = 1
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &blockingWriter{ResponseRecorder: httptest.NewRecorder(), started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(slow, newRequest(t, http.MethodGet, "http://localhost/a.txt", nil))
+	}()
+	<-slow.started
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/a.txt", nil))
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "5" {
+		t.Errorf("Expected 503 with Retry-After while at the limit, got %d %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+
+	close(slow.release)
+	<-done
+	if slow.Code != http.StatusOK {
+		t.Errorf("Expected the in-flight request to succeed, got %d", slow.Code)
+	}
+
+	if recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/a.txt", nil)); recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 once the in-flight request finished, got %d", recorder.Code)
+	}
+}
+
+func TestRejectLargeURI(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"a.txt": "a"})
+	cfg.RejectLargeURI = true
+	cfg.MaxURILength = 32
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]int{
+		"/a.txt":                            http.StatusOK,
+		"/a.txt?" + strings.Repeat("x", 25): http.StatusOK,
+		"/a.txt?" + strings.Repeat("x", 26): http.StatusRequestURITooLong,
+		"/" + strings.Repeat("a", 64):       http.StatusRequestURITooLong,
+	}
+	for uri, want := range tests {
+		req := newRequest(t, http.MethodGet, "http://localhost"+uri, nil)
+		req.RequestURI = uri
+		if recorder := serve(handler, req); recorder.Code != want {
+			t.Errorf("Expected %d for a %d byte URI, got %d", want, len(uri), recorder.Code)
+		}
+	}
+}
+
+func TestBlockedUserAgents(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "<html>Home</html>"})
+	// Common scraper and HTTP library User-Agents
+	cfg.BlockedUserAgents = []string{
+		`(?i)(ahrefs|semrush|mj12|dotbot)bot`,
+		`(?i)^python-requests/`,
+		`(?i)^(curl|wget)/`,
+		`^Scrapy/`,
+		`^$`,
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]int{
+		"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)":          http.StatusForbidden,
+		"Mozilla/5.0 (compatible; SemrushBot/7~bl; +http://www.semrush.com/bot.html)": http.StatusForbidden,
+		"python-requests/2.31.0":              http.StatusForbidden,
+		"curl/8.4.0":                          http.StatusForbidden,
+		"Scrapy/2.11.0 (+https://scrapy.org)": http.StatusForbidden,
+		"":                                    http.StatusForbidden,
+		"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0":   http.StatusOK,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": http.StatusOK,
+	}
+	for ua, want := range tests {
+		req := newRequest(t, http.MethodGet, "http://localhost/index.html", nil)
+		req.Header.Set("User-Agent", ua)
+		if recorder := serve(handler, req); recorder.Code != want {
+			t.Errorf("Expected %d for User-Agent %q, got %d", want, ua, recorder.Code)
+		}
+	}
+
+	cfg.BlockedUserAgents = []string{"("}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+func TestSlowloadSimulation(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"a.txt": "a"})
+	cfg.SlowloadSimulation = 50 * time.Millisecond
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/a.txt", nil))
+	if elapsed := time.Since(start); recorder.Code != http.StatusOK || elapsed < cfg.SlowloadSimulation {
+		t.Errorf("Expected a 200 delayed by at least %s, got %d after %s", cfg.SlowloadSimulation, recorder.Code, elapsed)
+	}
+
+	// Cancelled requests stop waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := newRequest(t, http.MethodGet, "http://localhost/a.txt", nil).WithContext(ctx)
+	if recorder = serve(handler, req); recorder.Body.Len() != 0 {
+		t.Errorf("Expected no response for a cancelled request, got %q", recorder.Body.String())
+	}
+}
+
+func TestMimeTypes(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"module.wasm":  "\x00asm",
+		"photo.avif":   "avif",
+		"data.statiqx": "custom",
+		"page.html":    "<html></html>",
+	})
+	cfg.MimeTypes = map[string]string{
+		".wasm":    "application/wasm",
+		"AVIF":     "image/avif",
+		".statiqx": "application/x-statiq",
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"/module.wasm":  "application/wasm",
+		"/photo.avif":   "image/avif",
+		"/data.statiqx": "application/x-statiq",
+		"/page.html":    "text/html; charset=utf-8",
+	}
+	for target, want := range tests {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Content-Type"); got != want {
+			t.Errorf("Expected Content-Type %q for %s, got %q", want, target, got)
+		}
+	}
+
+	// The overrides stay local to the instance
+	if got := mime.TypeByExtension(".statiqx"); got != "" {
+		t.Errorf("Expected the global MIME registry to be untouched, got %q", got)
+	}
+
+	cfg.MimeTypes = map[string]string{".bad": "not a type;"}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an invalid content type")
+	}
+}
+
+func TestServeFromMemory(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"index.html":      "<html>Home</html>",
+		"assets/app.js":   "console.log('v1');",
+		"assets/logo.svg": "<svg></svg>",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.ServeFromMemory = true
+	cfg.EnableDirectoryListing = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes on disk after startup are not visible
+	if err := os.WriteFile(filepath.Join(tempDir, "assets", "app.js"), []byte("console.log('v2');"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(tempDir, "index.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/assets/app.js", nil))
+	etag := recorder.Header().Get("ETag")
+	if recorder.Body.String() != "console.log('v1');" || etag == "" {
+		t.Errorf("Expected the file loaded at startup with an ETag, got %q %q", recorder.Body.String(), etag)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/assets/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	if recorder = serve(handler, req); recorder.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for the in-memory ETag, got %d", recorder.Code)
+	}
+
+	if recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/", nil)); recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected a redirect to the in-memory index, got %d", recorder.Code)
+	}
+	if recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil)); recorder.Body.String() != "<html>Home</html>" {
+		t.Errorf("Expected the deleted index to be served from memory, got %q", recorder.Body.String())
+	}
+
+	listing := serve(handler, newRequest(t, http.MethodGet, "http://localhost/assets/", nil)).Body.String()
+	if !strings.Contains(listing, `href="app.js"`) || !strings.Contains(listing, `href="logo.svg"`) {
+		t.Errorf("Expected the in-memory directory listing, got %q", listing)
+	}
+
+	if recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/missing.txt", nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a file that was never loaded, got %d", recorder.Code)
+	}
+}
+
+func TestMemFSMaxSizeMB(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"a/small.txt": "v1",
+		"b/large.bin": strings.Repeat("x", 2<<20),
+		"c/later.txt": "v1",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.ServeFromMemory = true
+	cfg.MemFSMaxSizeMB = 1
+	cfg.EnableDirectoryListing = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a/small.txt", "c/later.txt", "a/new.txt"} {
+		if err := os.WriteFile(filepath.Join(tempDir, filepath.FromSlash(name)), []byte("v2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"/a/small.txt": "v1", // loaded before the limit was reached
+		"/c/later.txt": "v2", // skipped once the limit was reached
+		"/a/new.txt":   "v2",
+	}
+	for target, want := range tests {
+		if got := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil)).Body.String(); got != want {
+			t.Errorf("Expected %q for %s, got %q", want, target, got)
+		}
+	}
+
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/b/large.bin", nil)); recorder.Body.Len() != 2<<20 {
+		t.Errorf("Expected the large file from disk, got %d bytes", recorder.Body.Len())
+	}
+
+	// Directories are listed from disk once the snapshot is partial
+	listing := serve(handler, newRequest(t, http.MethodGet, "http://localhost/a/", nil)).Body.String()
+	if !strings.Contains(listing, `href="new.txt"`) {
+		t.Errorf("Expected the listing to include files created after startup, got %q", listing)
+	}
+}
+
+func TestErrorPages403And500(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"errors/403.html": "<html>No entry</html>",
+		"errors/500.html": "<html>Broken</html>",
+	})
+	cfg.ErrorPage403 = "/errors/403.html"
+	cfg.ErrorPage500 = "/errors/500.html"
+	cfg.BlockedUserAgents = []string{"^BadBot"}
+	// A missing SPA index makes unknown routes fail with 500
+	cfg.SPAMode = true
+	cfg.SPAIndex = "missing.html"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(t, http.MethodGet, "http://localhost/errors/", nil)
+	req.Header.Set("User-Agent", "BadBot/1.0")
+	recorder := serve(handler, req)
+	if recorder.Code != http.StatusForbidden || recorder.Body.String() != "<html>No entry</html>" {
+		t.Errorf("Expected the custom 403 page with status 403, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if ct := recorder.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected an HTML error page, got %q", ct)
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/app/route", nil))
+	if recorder.Code != http.StatusInternalServerError || recorder.Body.String() != "<html>Broken</html>" {
+		t.Errorf("Expected the custom 500 page with status 500, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	cfg.ErrorPage500 = "/errors/missing.html"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a missing error page")
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"api/users.json":  "[]",
+		"api/v1/x.json":   "{}",
+		"api/index.html":  "<html></html>",
+		"docs/index.html": "<html></html>",
+	})
+	cfg.Headers = []statiq.HeaderRule{
+		{Extension: ".html", Values: map[string]string{"X-Content-Type-Options": "nosniff", "X-Kind": "page"}},
+		{Pattern: "/api/*", Values: map[string]string{"X-Kind": "api", "X-Api": "1"}},
+		{Pattern: "/api/*", Values: map[string]string{"X-Api": "2"}},
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]map[string]string{
+		"/api/users.json":  {"X-Kind": "api", "X-Api": "2", "X-Content-Type-Options": ""},
+		"/api/v1/x.json":   {"X-Kind": "", "X-Api": ""},
+		"/api/index.html":  {"X-Kind": "page", "X-Api": "2", "X-Content-Type-Options": "nosniff"},
+		"/docs/index.html": {"X-Kind": "page", "X-Api": ""},
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		for name, value := range expected {
+			if got := recorder.Header().Get(name); got != value {
+				t.Errorf("Expected %s: %q for %s, got %q", name, value, target, got)
+			}
+		}
+	}
+
+	cfg.Headers = []statiq.HeaderRule{{Values: map[string]string{"X-Kind": "any"}}}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a rule without pattern or extension")
+	}
+}
+
+func TestOnNotFound(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "home", "page.txt": "page", "404.html": "custom"})
+	cfg.ErrorPage404 = "404.html"
+	cfg.OnNotFound = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprintf(w, "gone: %s", r.URL.Path)
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/missing", nil))
+	if recorder.Code != http.StatusGone || recorder.Body.String() != "gone: /missing" {
+		t.Errorf("Expected the OnNotFound response, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.txt", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "page" {
+		t.Errorf("Expected existing files to be served, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	// SPA mode still answers unknown routes with the index
+	cfg.SPAMode = true
+	cfg.SPAIndex = "index.html"
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/app/route", nil))
+	if recorder.Body.String() != "home" {
+		t.Errorf("Expected SPA mode to take precedence, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestOnError(t *testing.T) {
+	t.Parallel()
+
+	var hookErr error
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "home"})
+	// A missing SPA index makes unknown routes fail with 500
+	cfg.SPAMode = true
+	cfg.SPAIndex = "missing.html"
+	cfg.OnError = func(w http.ResponseWriter, r *http.Request, err error, status int) {
+		hookErr = err
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "failed: %s", r.URL.Path)
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/app/route", nil))
+	if recorder.Code != http.StatusInternalServerError || recorder.Body.String() != "failed: /app/route" {
+		t.Errorf("Expected the OnError response, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if !errors.Is(hookErr, fs.ErrNotExist) {
+		t.Errorf("Expected OnError to receive the open error, got %v", hookErr)
+	}
+}
+
+func TestHideDotFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		".env":                           "SECRET=1",
+		".git/config":                    "[core]",
+		".well-known/acme-challenge/tok": "token",
+		"files/.htaccess":                "deny",
+		"files/visible.txt":              "visible",
+	})
+	cfg.EnableDirectoryListing = true
+	cfg.AllowDotFiles = []string{".well-known"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]int{
+		"/.env":                           http.StatusNotFound,
+		"/.git/config":                    http.StatusNotFound,
+		"/files/.htaccess":                http.StatusNotFound,
+		"/files/visible.txt":              http.StatusOK,
+		"/.well-known/acme-challenge/tok": http.StatusOK,
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if recorder.Code != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, target, recorder.Code)
+		}
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/files/", nil))
+	if body := recorder.Body.String(); strings.Contains(body, ".htaccess") || !strings.Contains(body, "visible.txt") {
+		t.Errorf("Expected the listing to omit dot-files, got %s", body)
+	}
+
+	cfg.HideDotFiles = false
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/.env", nil)); recorder.Code != http.StatusOK {
+		t.Errorf("Expected dot-files to be served with hideDotFiles off, got %d", recorder.Code)
+	}
+}
+
+func TestCachePolicies(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"app.js":     "js",
+		"index.html": "<html></html>",
+		"data.json":  "{}",
+		"style.css":  "css",
+		"notes.txt":  "txt",
+	})
+	cfg.CacheControl = map[string]string{".css": "max-age=600", ".js": "max-age=60", "*": "max-age=120"}
+	cfg.CachePolicies = map[string]statiq.CachePolicy{
+		".js":   {MaxAge: 31536000, Immutable: true, Public: true},
+		".html": {NoCache: true},
+		".json": {NoStore: true, MaxAge: 10},
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/app.js":     "public, max-age=31536000, immutable",
+		"/index.html": "no-cache",
+		"/data.json":  "no-store",
+		"/style.css":  "max-age=600",
+		"/notes.txt":  "max-age=120",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Cache-Control"); got != expected {
+			t.Errorf("Expected Cache-Control: %s for %s, got %s", expected, target, got)
+		}
+	}
+
+	cfg.CachePolicies = map[string]statiq.CachePolicy{".js": {MaxAge: -1}}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a negative maxAge")
+	}
+}
+
+func TestPerExtensionMaxAge(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"app.js":    "js",
+		"style.css": "css",
+		"notes.txt": "txt",
+	})
+	cfg.PerExtensionMaxAge = map[string]int{".js": 31536000, ".css": 3600, "*": 60}
+	cfg.CacheControl = map[string]string{".css": "no-cache"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/app.js":    "public, max-age=31536000",
+		"/style.css": "no-cache",
+		"/notes.txt": "public, max-age=60",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Cache-Control"); got != expected {
+			t.Errorf("Expected Cache-Control: %s for %s, got %s", expected, target, got)
+		}
+	}
+
+	cfg.PerExtensionMaxAge = map[string]int{".js": -1}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a negative max age")
+	}
+}
+
+func TestSPARoutes(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"index.html":           "main",
+		"app/index.html":       "app",
+		"app-v2/index.html":    "app-v2",
+		"app/admin/index.html": "app-admin",
+	})
+	cfg.SPARoutes = []statiq.SPARoute{
+		{Prefix: "/app", Index: "app/index.html"},
+		{Prefix: "/app/admin/", Index: "app/admin/index.html"},
+		{Prefix: "/app-v2", Index: "app-v2/index.html"},
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/app/users/42":      "app",
+		"/app-v2/users/42":   "app-v2",
+		"/app/admin/reports": "app-admin",
+		"/app/administrator": "app",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != expected {
+			t.Errorf("Expected %q for %s, got %d %q", expected, target, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// Without SPA mode, paths outside every route stay missing
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/application", nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 outside the SPA routes, got %d", recorder.Code)
+	}
+
+	cfg.SPAMode = true
+	cfg.SPAIndex = "index.html"
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/application", nil)); recorder.Body.String() != "main" {
+		t.Errorf("Expected the global SPA index outside the routes, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestSPAIndexHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"index.html": "app", "app.js": "code"})
+	cfg.SPAMode = true
+	cfg.SPAIndex = "index.html"
+	cfg.CacheControl = map[string]string{".html": "max-age=31536000", ".js": "max-age=31536000"}
+	cfg.SPAIndexHeaders = map[string]string{"Cache-Control": "no-store", "X-Robots-Tag": "noindex"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/users/42", nil))
+	if recorder.Body.String() != "app" || recorder.Header().Get("Cache-Control") != "no-store" || recorder.Header().Get("X-Robots-Tag") != "noindex" {
+		t.Errorf("Expected the SPA index headers on the fallback, got %q %v", recorder.Body.String(), recorder.Header())
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil))
+	if recorder.Header().Get("Cache-Control") != "max-age=31536000" || recorder.Header().Get("X-Robots-Tag") != "" {
+		t.Errorf("Expected assets to keep their cache headers, got %v", recorder.Header())
+	}
+}
+
+func TestAliases(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"assets/images/favicon.ico": "icon",
+		"site/v2/docs/index.html":   "docs",
+	})
+	cfg.Aliases = map[string]string{
+		"/favicon.ico": "assets/images/favicon.ico",
+		"/docs":        "/site/v2/docs",
+	}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/favicon.ico": "icon",
+		"/docs":        "docs",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != expected {
+			t.Errorf("Expected %q for %s, got %d %q", expected, target, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// Missing targets fail at startup unless checked lazily
+	cfg.Aliases["/robots.txt"] = "/seo/robots.txt"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a missing alias target")
+	}
+	cfg.LazyAliasCheck = true
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/robots.txt", nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing lazy alias target, got %d", recorder.Code)
+	}
+}
+
+func TestMemCache(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"app.js": "version1", "large.txt": strings.Repeat("x", 64)})
+	cfg.MemCache = true
+	cfg.MemCacheMaxFile = 32
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil))
+	if first.Body.String() != "version1" || first.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Fatalf("Expected the file, got %q %q", first.Body.String(), first.Header().Get("Content-Type"))
+	}
+
+	// Rewriting the file without changing its size or modification time goes unnoticed
+	name := filepath.Join(cfg.Root, "app.js")
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte("version2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	cached := serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil))
+	if cached.Body.String() != "version1" || cached.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("Expected the cached file, got %q", cached.Body.String())
+	}
+
+	// A new modification time is a new version
+	if err := os.Chtimes(name, info.ModTime().Add(time.Second), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/app.js", nil)); recorder.Body.String() != "version2" {
+		t.Errorf("Expected the changed file, got %q", recorder.Body.String())
+	}
+
+	// Files from memCacheMaxFile on are always read from disk
+	if err := os.WriteFile(filepath.Join(cfg.Root, "large.txt"), []byte(strings.Repeat("y", 64)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/large.txt", nil)); recorder.Body.String() != strings.Repeat("y", 64) {
+		t.Errorf("Expected the large file from disk, got %q", recorder.Body.String())
+	}
+
+	cfg.ServeFromMemory = true
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected memCache to be refused together with serveFromMemory")
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"Images/Logo.PNG":  "logo",
+		"docs/index.html":  "docs",
+		"docs/Guide.html":  "guide",
+		"docs/.env":        "secret",
+		"docs/guide.HTML2": "other",
+	})
+	cfg.CaseInsensitive = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/images/logo.png":       "logo",
+		"/IMAGES/Logo.PNG":       "logo",
+		"/Docs/guide.html":       "guide",
+		"/DOCS/INDEX.HTML":       "docs",
+		"/docs/missing.html":     "404",
+		"/docs/.ENV":             "404",
+		"/images/logo.png/extra": "404",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		got := recorder.Body.String()
+		if recorder.Code != http.StatusOK {
+			got = strconv.Itoa(recorder.Code)
+		}
+		if got != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, target, got)
+		}
+	}
+
+	// Directories resolve to their index file like exact matches do
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/DOCS/", nil))
+	if location := recorder.Header().Get("Location"); location != "/docs/index.html" {
+		t.Errorf("Expected the directory to resolve to its index file, got %d %q", recorder.Code, location)
+	}
+
+	cfg.CaseInsensitive = false
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/images/logo.png", nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a case mismatch by default, got %d", recorder.Code)
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	t.Parallel()
+
+	content := strings.Repeat("0123456789", 30)
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"data.txt": content})
+
+	for _, fromMemory := range []bool{false, true} {
+		cfg.ServeFromMemory = fromMemory
+		handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := newRequest(t, http.MethodGet, "http://localhost/data.txt", nil)
+		req.Header.Set("Range", "bytes=0-99")
+		recorder := serve(handler, req)
+		if recorder.Code != http.StatusPartialContent {
+			t.Fatalf("Expected 206 (memory %v), got %d", fromMemory, recorder.Code)
+		}
+		if got := recorder.Header().Get("Content-Range"); got != "bytes 0-99/300" {
+			t.Errorf("Expected Content-Range: bytes 0-99/300 (memory %v), got %q", fromMemory, got)
+		}
+		if recorder.Body.String() != content[:100] || recorder.Header().Get("Content-Length") != "100" {
+			t.Errorf("Expected the first 100 bytes (memory %v), got %d", fromMemory, recorder.Body.Len())
+		}
+
+		req = newRequest(t, http.MethodGet, "http://localhost/data.txt", nil)
+		req.Header.Set("Range", "bytes=0-9,-5")
+		recorder = serve(handler, req)
+		mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+		if recorder.Code != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Expected a multipart/byteranges 206 (memory %v), got %d %s", fromMemory, recorder.Code, recorder.Header().Get("Content-Type"))
+		}
+		reader := multipart.NewReader(recorder.Body, params["boundary"])
+		for _, expected := range []struct{ contentRange, body string }{
+			{"bytes 0-9/300", content[:10]},
+			{"bytes 295-299/300", content[295:]},
+		} {
+			part, err := reader.NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(part)
+			if part.Header.Get("Content-Range") != expected.contentRange || string(body) != expected.body {
+				t.Errorf("Expected part %s %q (memory %v), got %s %q", expected.contentRange, expected.body, fromMemory, part.Header.Get("Content-Range"), body)
+			}
+		}
+		if _, err := reader.NextPart(); err != io.EOF {
+			t.Errorf("Expected exactly two parts (memory %v), got %v", fromMemory, err)
+		}
+
+		req = newRequest(t, http.MethodGet, "http://localhost/data.txt", nil)
+		req.Header.Set("Range", "bytes=500-600")
+		if recorder := serve(handler, req); recorder.Code != http.StatusRequestedRangeNotSatisfiable || recorder.Header().Get("Content-Range") != "bytes */300" {
+			t.Errorf("Expected 416 with bytes */300 (memory %v), got %d %q", fromMemory, recorder.Code, recorder.Header().Get("Content-Range"))
+		}
+	}
+}
+
+func TestHEADOnlyMode(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello"})
+	cfg.HEADOnlyMode = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		recorder := serve(handler, newRequest(t, method, "http://localhost/page.txt", nil))
+		if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+			t.Errorf("Expected %s to return 200 without a body, got %d %q", method, recorder.Code, recorder.Body.String())
+		}
+		if recorder.Header().Get("Content-Length") != "5" || recorder.Header().Get("ETag") == "" {
+			t.Errorf("Expected the file's headers for %s, got %v", method, recorder.Header())
+		}
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodOptions, http.MethodDelete} {
+		recorder := serve(handler, newRequest(t, method, "http://localhost/page.txt", nil))
+		if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("Expected 405 for %s, got %d with Allow %q", method, recorder.Code, recorder.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestCSP(t *testing.T) {
+	t.Parallel()
+
+	const policy = "default-src 'self'; script-src 'self' 'nonce-abc'"
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"page.html":      "<html></html>",
+		"style.css":      "body{}",
+		"docs/notes.txt": "notes",
+	})
+	cfg.CSP = policy
+	cfg.EnableDirectoryListing = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/page.html": policy,
+		"/docs/":     policy,
+		"/style.css": "",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got := recorder.Header().Get("Content-Security-Policy"); got != expected {
+			t.Errorf("Expected Content-Security-Policy %q for %s, got %q", expected, target, got)
+		}
+	}
+
+	cfg.CSPReportOnly = true
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.html", nil))
+	if recorder.Header().Get("Content-Security-Policy-Report-Only") != policy || recorder.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("Expected only the report-only header, got %v", recorder.Header())
+	}
+
+	cfg.CSP = ""
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for cspReportOnly without csp")
+	}
+}
+
+func TestRequiredQueryParam(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello"})
+	cfg.RequiredQueryParam = "key"
+	cfg.RequiredQueryValue = "secret"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]int{
+		"/page.txt?key=secret":       http.StatusOK,
+		"/page.txt?v=2&key=secret":   http.StatusOK,
+		"/page.txt":                  http.StatusForbidden,
+		"/page.txt?key=":             http.StatusForbidden,
+		"/page.txt?key=secre":        http.StatusForbidden,
+		"/page.txt?key=secret-extra": http.StatusForbidden,
+		"/page.txt?KEY=secret":       http.StatusForbidden,
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if recorder.Code != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, target, recorder.Code)
+		}
+	}
+
+	cfg.RequiredQueryValue = ""
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for requiredQueryParam without requiredQueryValue")
+	}
+}
+
+func TestExplicitCacheHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"app.js": "js", "notes.txt": "txt"})
+	cfg.CacheControl = map[string]string{".js": "max-age=60"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serve(handler, newRequest(t, http.MethodGet, "http://localhost/notes.txt", nil)).Header().Get("Cache-Control"); got != "max-age=86400" {
+		t.Errorf("Expected the default Cache-Control, got %q", got)
+	}
+
+	cfg.ExplicitCacheHeaders = true
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, expected := range map[string]string{
+		"/app.js":    "max-age=60",
+		"/notes.txt": "",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if got, ok := recorder.Header()["Cache-Control"]; expected == "" && ok || expected != "" && (len(got) != 1 || got[0] != expected) {
+			t.Errorf("Expected Cache-Control %q for %s, got %q", expected, target, got)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	t.Parallel()
+
+	root := newTestRoot(t, map[string]string{
+		"page.txt":          "hello",
+		"public/status.txt": "up",
+	})
+	htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(htpasswd, []byte(
+		"# htpasswd -B and htpasswd -s\n"+
+			"alice:$2y$05$saltsaltsaltsaltsaltsO92IY0PiCUbu1FBI8L/kVq28CWPFAIWy\n"+
+			"\n"+
+			"bob:{SHA}t6h1/B6iKLkGEEG3zsS9PFKrPOM=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = root
+	cfg.BasicAuth = statiq.BasicAuth{Realm: "Staging", HtpasswdFile: htpasswd, Exclude: []string{"/public/"}}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target, user, password string) *httptest.ResponseRecorder {
+		req := newRequest(t, http.MethodGet, "http://localhost"+target, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		return serve(handler, req)
+	}
+
+	for _, c := range []struct {
+		user, password string
+		expected       int
+	}{
+		{"alice", "s3cret", http.StatusOK},
+		// A second request is answered from the verified credentials
+		{"alice", "s3cret", http.StatusOK},
+		{"bob", "letmein", http.StatusOK},
+		{"alice", "s3cret!", http.StatusUnauthorized},
+		{"alice", "letmein", http.StatusUnauthorized},
+		{"bob", "s3cret", http.StatusUnauthorized},
+		{"mallory", "s3cret", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		recorder := get("/page.txt", c.user, c.password)
+		if recorder.Code != c.expected {
+			t.Errorf("Expected %d for %s:%s, got %d", c.expected, c.user, c.password, recorder.Code)
+		}
+		if c.expected == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") != `Basic realm="Staging", charset="UTF-8"` {
+			t.Errorf("Unexpected challenge %q", recorder.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	if recorder := get("/public/status.txt", "", ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected excluded paths to bypass authentication, got %d", recorder.Code)
+	}
+
+	for name, content := range map[string]string{
+		"md5":       "carol:$apr1$abcdefgh$0123456789abcdefghijkl\n",
+		"malformed": "alice:$2y$05$short\n",
+		"no hash":   "alice\n",
+	} {
+		if err := os.WriteFile(htpasswd, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+			t.Errorf("Expected an error for a %s htpasswd entry", name)
+		}
+	}
+}
+
+func TestIPAllowList(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"page.txt": "hello"})
+	cfg.IPAllowList = []string{"192.0.2.0/24", "2001:db8::/32"}
+	cfg.IPDenyList = []string{"192.0.2.128/25", "2001:db8:bad::1"}
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		remote, xff string
+		expected    int
+	}{
+		{"192.0.2.0:1234", "", http.StatusOK},
+		{"192.0.2.127:1234", "", http.StatusOK},
+		// Denied ranges win over allowed ones
+		{"192.0.2.128:1234", "", http.StatusForbidden},
+		{"192.0.2.255:1234", "", http.StatusForbidden},
+		{"192.0.3.0:1234", "", http.StatusForbidden},
+		{"[2001:db8::1]:1234", "", http.StatusOK},
+		{"[2001:db8:bad::1]:1234", "", http.StatusForbidden},
+		{"[2001:db9::1]:1234", "", http.StatusForbidden},
+		// The client IP is taken from X-Forwarded-For through trusted proxies only
+		{"10.0.0.1:1234", "192.0.2.10", http.StatusOK},
+		{"10.0.0.1:1234", "192.0.2.200, 10.0.0.2", http.StatusForbidden},
+		{"10.0.0.1:1234", "198.51.100.1, 192.0.2.10, 10.0.0.2", http.StatusOK},
+		{"198.51.100.1:1234", "192.0.2.10", http.StatusForbidden},
+		{"192.0.2.10:1234", "198.51.100.1", http.StatusOK},
+	} {
+		req := newRequest(t, http.MethodGet, "http://localhost/page.txt", nil)
+		req.RemoteAddr = c.remote
+		if c.xff != "" {
+			req.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if recorder := serve(handler, req); recorder.Code != c.expected {
+			t.Errorf("Expected %d for %s (X-Forwarded-For %q), got %d", c.expected, c.remote, c.xff, recorder.Code)
+		}
+	}
+
+	cfg.IPDenyList = []string{"192.0.2.0/33"}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{"a.txt": "a"})
+	cfg.GlobalRateLimit = 20
+
+	handler, err := statiq.New(ctx, next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// A burst from many clients at once uses up the shared window
+	const burst = 30
+	codes := make(chan int, burst)
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL + "/a.txt")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 20 || counts[http.StatusServiceUnavailable] != burst-20 {
+		t.Errorf("Expected 20 requests served and %d refused, got %v", burst-20, counts)
+	}
+
+	resp, err := http.Get(server.URL + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if retry, _ := strconv.Atoi(resp.Header.Get("Retry-After")); resp.StatusCode != http.StatusServiceUnavailable || retry < 1 || retry > 60 {
+		t.Errorf("Expected 503 with Retry-After until the window resets, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	t.Parallel()
+
+	root := newTestRoot(t, map[string]string{
+		"about/index.html": "about",
+		"docs/notes.txt":   "notes",
+		"page.txt":         "page",
+		"evil.com":         "file",
+	})
+
+	for mode, expectations := range map[string]map[string]string{
+		"redirect": {
+			"/about":     "301 /about/",
+			"/about/":    "301 /about/index.html",
+			"/page.txt/": "200 page",
+			"/docs":      "301 /docs/",
+		},
+		"none": {
+			"/about":     "200 about",
+			"/about/":    "200 about",
+			"/page.txt/": "200 page",
+			"/docs":      "301 /docs/",
+		},
+		"strip": {
+			"/about":         "301 /about/",
+			"/page.txt/":     "301 /page.txt",
+			"/page.txt//":    "301 /page.txt",
+			"/missing/":      "301 /missing",
+			"//evil.com/":    "301 /evil.com",
+			"/page.txt":      "200 page",
+			"/docs/":         "200",
+			"/docs/missing/": "301 /docs/missing",
+		},
+	} {
+		cfg := statiq.CreateConfig()
+		cfg.Root = root
+		cfg.EnableDirectoryListing = true
+		cfg.TrailingSlash = mode
+
+		handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for target, expected := range expectations {
+			recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+			got := strconv.Itoa(recorder.Code)
+			switch {
+			case recorder.Code == http.StatusMovedPermanently:
+				got += " " + recorder.Header().Get("Location")
+			case recorder.Code == http.StatusOK && !strings.HasSuffix(target, "/docs/"):
+				got += " " + recorder.Body.String()
+			}
+			if got != expected {
+				t.Errorf("Expected %q for %s with trailingSlash %s, got %q", expected, target, mode, got)
+			}
+		}
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = root
+	cfg.TrailingSlash = "strip"
+	cfg.SPAMode = true
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for trailingSlash strip with spaMode")
+	}
+	cfg.SPAMode = false
+	cfg.TrailingSlash = "add"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an unknown trailingSlash mode")
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"index.html":          "home",
+		"about us/index.html": "about",
+		"blog/post.html":      "post",
+		"docs/guide.pdf":      "pdf",
+		"style.css":           "css",
+		".private/page.html":  "hidden",
+	})
+	cfg.Sitemap = true
+	cfg.SitemapChangeFreq = map[string]string{".html": "daily", "pdf": "monthly"}
+	cfg.SitemapPriority = map[string]float64{".PDF": 0.3}
+	cfg.SitemapBaseURL = "https://example.com/"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The URLs are built on sitemapBaseURL, never on request headers
+	req := newRequest(t, http.MethodGet, "http://attacker.example/sitemap.xml", nil)
+	req.Header.Set("X-Forwarded-Proto", "javascript")
+	recorder := serve(handler, req)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/xml; charset=utf-8" {
+		t.Fatalf("Expected the sitemap, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	var sitemap struct {
+		URLs []struct {
+			Loc        string  `xml:"loc"`
+			LastMod    string  `xml:"lastmod"`
+			ChangeFreq string  `xml:"changefreq"`
+			Priority   float64 `xml:"priority"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &sitemap); err != nil {
+		t.Fatalf("Expected a valid sitemap: %v\n%s", err, recorder.Body.String())
+	}
+
+	got := []string{}
+	for _, u := range sitemap.URLs {
+		if _, err := time.Parse(time.RFC3339, u.LastMod); err != nil {
+			t.Errorf("Expected an RFC 3339 lastmod for %s, got %q", u.Loc, u.LastMod)
+		}
+		got = append(got, fmt.Sprintf("%s %s %g", u.Loc, u.ChangeFreq, u.Priority))
+	}
+	expected := []string{
+		"https://example.com/ daily 0.5",
+		"https://example.com/about%20us/ daily 0.5",
+		"https://example.com/blog/post.html daily 0.5",
+		"https://example.com/docs/guide.pdf monthly 0.3",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the entries\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	// The walk of the root is reused until the cache expires
+	if err := os.WriteFile(filepath.Join(cfg.Root, "new.html"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if body := serve(handler, newRequest(t, http.MethodGet, "http://localhost/sitemap.xml", nil)).Body.String(); strings.Contains(body, "new.html") {
+		t.Errorf("Expected the cached sitemap, got:\n%s", body)
+	}
+
+	// The sitemap is subject to the same access rules as the files
+	cfg.IPAllowList = []string{"10.0.0.0/8"}
+	handler, err = statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/sitemap.xml", nil)); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the sitemap outside ipAllowList, got %d", recorder.Code)
+	}
+	cfg.IPAllowList = nil
+
+	for _, base := range []string{"", "example.com", "https://example.com/blog", "https://user@example.com"} {
+		cfg.SitemapBaseURL = base
+		if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+			t.Errorf("Expected an error for sitemapBaseURL %q", base)
+		}
+	}
+	cfg.SitemapBaseURL = "https://example.com"
+
+	cfg.SitemapChangeFreq = map[string]string{".html": "sometimes"}
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an unknown changefreq")
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	t.Parallel()
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = newTestRoot(t, map[string]string{
+		"app.js":         "app",
+		"index.html":     "home",
+		"docs/notes.txt": "notes",
+	})
+	cfg.StripPrefix = "/static/"
+	cfg.EnableDirectoryListing = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/static/app.js":  "200 app",
+		"/static":         "301 /static/index.html",
+		"/static/":        "301 /static/index.html",
+		"/static/docs":    "301 /static/docs/",
+		"/static/docs/":   "200",
+		"/app.js":         "404",
+		"/staticfiles/js": "404",
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		got := strconv.Itoa(recorder.Code)
+		switch {
+		case recorder.Code == http.StatusMovedPermanently:
+			got += " " + recorder.Header().Get("Location")
+		case recorder.Code == http.StatusOK && !strings.HasSuffix(target, "/"):
+			got += " " + recorder.Body.String()
+		}
+		if got != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, target, got)
+		}
+		if target == "/static/docs/" && !strings.Contains(recorder.Body.String(), `href="notes.txt"`) {
+			t.Errorf("Expected the listing of docs, got:\n%s", recorder.Body.String())
+		}
+	}
+
+	// Requests outside the prefix reach the next handler with their path intact
+	cfg.PassThrough = true
+	var passed string
+	handler, err = statiq.New(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = r.URL.Path
+		w.WriteHeader(http.StatusTeapot)
+	}), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/api/users", nil)); recorder.Code != http.StatusTeapot || passed != "/api/users" {
+		t.Errorf("Expected /api/users to pass through, got %d for %q", recorder.Code, passed)
+	}
+	if recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/static/app.js", nil)); recorder.Code != http.StatusOK {
+		t.Errorf("Expected files below the prefix to be served, got %d", recorder.Code)
+	}
+
+	cfg.StripPrefix = "static"
+	if _, err := statiq.New(context.Background(), next(t), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for a prefix without a leading slash")
+	}
+}
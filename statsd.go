@@ -0,0 +1,61 @@
+package statiq
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultStatsdPrefix is the metric name prefix used when StatsdPrefix is unset
+const defaultStatsdPrefix = "statiq"
+
+// statsdClient pushes per-request metrics to a StatsD server over UDP.
+// Sends are fire-and-forget: a missing or overloaded server never slows
+// down or fails a request.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+}
+
+// newStatsdClient connects to addr, closing the socket once ctx is done
+func newStatsdClient(ctx context.Context, addr, prefix string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &statsdClient{conn: conn, prefix: prefix}, nil
+}
+
+// send writes the metrics of one request as a single multi-metric datagram
+func (c *statsdClient) send(status int, bytes int64, duration time.Duration) {
+	p := c.prefix
+	packet := fmt.Sprintf("%srequests.total:1|c\n%sresponse_time:%d|ms\n%sstatus.%d:1|c\n%sbytes:%d|c",
+		p, p, duration.Milliseconds(), p, status, p, bytes)
+	c.conn.Write([]byte(packet))
+}
+
+// wrapStatsd reports every request served by next to the StatsD server
+func (h *StatiqHandler) wrapStatsd(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &logRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		h.statsd.send(recorder.status, recorder.bytes, time.Since(start))
+	})
+}
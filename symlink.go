@@ -0,0 +1,120 @@
+package statiq
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FollowSymlinks policies
+const (
+	followSymlinksNone     = "none"
+	followSymlinksSameRoot = "same-root"
+	followSymlinksAny      = "any"
+)
+
+// defaultSymlinkChainLimit is how many links a path may go through when SymlinkChainLimit is unset
+const defaultSymlinkChainLimit = 8
+
+// errSymlinkChain stops resolving a path that goes through too many links
+var errSymlinkChain = errors.New("too many symbolic links")
+
+// symlinkPolicy decides which paths through symbolic links may be served
+type symlinkPolicy struct {
+	// root is the root directory with its own links resolved
+	root     string
+	sameRoot bool
+	any      bool
+	// chainLimit is the most links followed while resolving one path
+	chainLimit int
+}
+
+// allows reports whether the slash-separated path below the root may be
+// served. Without links on the way it resolves to itself; otherwise
+// same-root accepts it only when the final target stays inside the root,
+// which also catches relative links such as ../../etc/passwd. Paths through
+// more links than the chain limit, such as circular ones, are refused.
+func (p *symlinkPolicy) allows(name string) bool {
+	full := filepath.Join(p.root, filepath.FromSlash(path.Clean("/"+name)))
+	resolved, err := evalSymlinks(full, p.chainLimit)
+	if errors.Is(err, errSymlinkChain) {
+		return false
+	}
+	if err != nil {
+		// Missing files and dangling links are left to Open to report
+		return true
+	}
+	if resolved == full || p.any {
+		return true
+	}
+	return p.sameRoot && (resolved == p.root || strings.HasPrefix(resolved, p.root+string(filepath.Separator)))
+}
+
+// evalSymlinks resolves the links of an absolute path like
+// filepath.EvalSymlinks, failing with errSymlinkChain once more than limit
+// links were followed
+func evalSymlinks(name string, limit int) (string, error) {
+	volume := filepath.VolumeName(name)
+	resolved := volume + string(filepath.Separator)
+	links := 0
+	for rest := name[len(volume):]; rest != ""; {
+		var component string
+		if i := strings.IndexRune(rest, filepath.Separator); i >= 0 {
+			component, rest = rest[:i], rest[i+1:]
+		} else {
+			component, rest = rest, ""
+		}
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, component)
+		info, err := os.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > limit {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: errSymlinkChain}
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		// The link's target replaces it, relative to its directory unless absolute
+		if filepath.IsAbs(target) {
+			volume = filepath.VolumeName(target)
+			resolved = volume + string(filepath.Separator)
+			target = target[len(volume):]
+		}
+		rest = target + string(filepath.Separator) + rest
+	}
+	return filepath.Clean(resolved), nil
+}
+
+// symlinkFS refuses to open paths the policy does not allow
+type symlinkFS struct {
+	http.FileSystem
+	policy *symlinkPolicy
+}
+
+func (s symlinkFS) Open(name string) (http.File, error) {
+	if !s.policy.allows(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return s.FileSystem.Open(name)
+}
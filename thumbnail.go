@@ -0,0 +1,110 @@
+package statiq
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// thumbnailPrefix is the URL path under which listing thumbnails are served
+const thumbnailPrefix = "/_thumbs/"
+
+// thumbnailSize is the bounding box of generated thumbnails, in pixels
+const thumbnailSize = 64
+
+// thumbnailPlaceholder stands in for the thumbnail of files that are not images
+const thumbnailPlaceholder = "📄"
+
+// servesThumbnails reports whether directory listings show image thumbnails
+func (h *StatiqHandler) servesThumbnails() bool {
+	return h.enableDirListing && h.thumbnailDir != ""
+}
+
+// thumbnailURL returns the thumbnail location of a file in a listed
+// directory, or "" when the file is not a JPEG or PNG image
+func (h *StatiqHandler) thumbnailURL(dir, name string) string {
+	if imageFormat(h.typeByExtension(filepath.Ext(name))) == "" {
+		return ""
+	}
+	return (&url.URL{Path: path.Join(thumbnailPrefix, dir, name)}).EscapedPath()
+}
+
+// serveThumbnail serves the thumbnail of the image at urlPath, generating it
+// into ThumbnailDir on first use. Regenerated thumbnails get a new file name
+// whenever the source image changes.
+func (h *StatiqHandler) serveThumbnail(w http.ResponseWriter, r *http.Request, urlPath string) {
+	ext := filepath.Ext(urlPath)
+	format := imageFormat(h.typeByExtension(ext))
+	if format == "" {
+		h.serveNotFound(w, r)
+		return
+	}
+
+	f, err := h.root.Open(urlPath)
+	if err != nil {
+		h.serveNotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	d, err := f.Stat()
+	if err != nil || d.IsDir() || d.Size() > maxImageTransformSize {
+		h.serveNotFound(w, r)
+		return
+	}
+
+	sum := sha1.Sum([]byte(transformCacheKey("thumb", urlPath, d)))
+	cacheFile := filepath.Join(h.thumbnailDir, hex.EncodeToString(sum[:])+ext)
+	if _, err := os.Stat(cacheFile); err != nil {
+		thumb, err := createThumbnail(f, format)
+		if err != nil {
+			log.Printf("statiq: creating thumbnail of %s failed: %v", urlPath, err)
+			h.serveError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if err := h.writeCacheFile(cacheFile, thumb); err != nil {
+			log.Printf("statiq: caching thumbnail of %s failed: %v", urlPath, err)
+			h.serveError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.serveFile(w, r, cacheFile)
+}
+
+// createThumbnail scales an image down to fit within thumbnailSize pixels,
+// encoding the result in its source format
+func createThumbnail(content io.Reader, format string) ([]byte, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	img = resizeImage(img, thumbnailSize, thumbnailSize)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: defaultImageQuality})
+	} else {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// isThumbnailPath reports whether a request path addresses a listing thumbnail
+func (h *StatiqHandler) isThumbnailPath(urlPath string) bool {
+	return h.servesThumbnails() && strings.HasPrefix(urlPath, thumbnailPrefix)
+}
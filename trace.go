@@ -0,0 +1,116 @@
+package statiq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// parseTraceparent returns the trace ID of a W3C traceparent header
+// ("00-<trace-id>-<parent-id>-<flags>"), or "" when the header is malformed
+func parseTraceparent(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ""
+	}
+	// Only version 00 is fully specified; later versions may append fields
+	if parts[0] == "00" && len(parts) != 4 {
+		return ""
+	}
+	for _, part := range parts[:4] {
+		if strings.ToLower(part) != part {
+			return ""
+		}
+		if _, err := hex.DecodeString(part); err != nil {
+			return ""
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return ""
+	}
+	return parts[1]
+}
+
+// newTraceparent starts a new, unsampled trace
+func newTraceparent() string {
+	return "00-" + randomHex(16) + "-" + randomHex(8) + "-00"
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// setTraceHeaders echoes the request's trace context on the response,
+// starting a new trace when the request carries no valid traceparent. The
+// request is updated too, so the access log sees the same trace.
+func (h *StatiqHandler) setTraceHeaders(w http.ResponseWriter, r *http.Request) {
+	if !h.traceHeaders {
+		return
+	}
+
+	traceparent := r.Header.Get("traceparent")
+	if parseTraceparent(traceparent) == "" {
+		// tracestate means nothing without the traceparent it belongs to
+		traceparent = newTraceparent()
+		r.Header.Set("traceparent", traceparent)
+		r.Header.Del("tracestate")
+	}
+
+	w.Header().Set("traceparent", traceparent)
+	if tracestate := r.Header.Values("tracestate"); len(tracestate) > 0 {
+		w.Header().Set("tracestate", strings.Join(tracestate, ","))
+	}
+}
+
+// traceID is the trace ID logged for a request when TraceHeaders is set
+func (h *StatiqHandler) traceID(r *http.Request) string {
+	if !h.traceHeaders {
+		return ""
+	}
+	return parseTraceparent(r.Header.Get("traceparent"))
+}
+
+// isHexID reports whether id is a lower-case hex ID of one of the given lengths
+func isHexID(id string, lengths ...int) bool {
+	for _, n := range lengths {
+		if len(id) == n && strings.ToLower(id) == id {
+			_, err := hex.DecodeString(id)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// setServiceMeshHeaders continues an incoming Zipkin B3 trace, as used by
+// Istio and Envoy, on the response. The response gets a span of its own,
+// with the caller's span as its parent.
+func (h *StatiqHandler) setServiceMeshHeaders(w http.ResponseWriter, r *http.Request) {
+	if !h.serviceMeshHeaders {
+		return
+	}
+
+	traceID := r.Header.Get("X-B3-TraceId")
+	if !isHexID(traceID, 16, 32) {
+		return
+	}
+
+	header := w.Header()
+	header.Set("X-B3-TraceId", traceID)
+	header.Set("X-B3-SpanId", randomHex(8))
+	if parent := r.Header.Get("X-B3-SpanId"); isHexID(parent, 16) {
+		header.Set("X-B3-ParentSpanId", parent)
+	}
+	if sampled := r.Header.Get("X-B3-Sampled"); sampled != "" {
+		header.Set("X-B3-Sampled", sampled)
+	}
+	if flags := r.Header.Get("X-B3-Flags"); flags != "" {
+		header.Set("X-B3-Flags", flags)
+	}
+}
@@ -0,0 +1,289 @@
+package statiq
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// bodyCloseTag is the marker before which HTML snippets are injected
+var bodyCloseTag = []byte("</body>")
+
+// htmlInjection returns markup to insert before </body> for the given request
+type htmlInjection func(r *http.Request) string
+
+// feedbackInjection renders the floating feedback widget pointing at feedbackURL
+func feedbackInjection(feedbackURL string) htmlInjection {
+	snippet := `<div id="statiq-feedback" style="position:fixed;right:1em;bottom:1em;z-index:9999;` +
+		`padding:.5em 1em;background:#4CAF50;border-radius:4px;font-family:sans-serif;">` +
+		`<a href="` + template.HTMLEscapeString(feedbackURL) + `" style="color:#fff;text-decoration:none;" ` +
+		`target="_blank" rel="noopener">Feedback</a></div>`
+
+	return func(_ *http.Request) string {
+		return snippet
+	}
+}
+
+// serviceWorkerInjection registers the service worker at scriptPath, scoped to
+// the directory of the HTML page being served. The script is sent with
+// Service-Worker-Allowed so that scope may lie outside the script's directory.
+func serviceWorkerInjection(scriptPath string) htmlInjection {
+	scriptURL := template.JSEscapeString(path.Join("/", scriptPath))
+
+	return func(r *http.Request) string {
+		scope := path.Dir(r.URL.Path)
+		if !strings.HasSuffix(scope, "/") {
+			scope += "/"
+		}
+
+		return `<script>if ('serviceWorker' in navigator) { navigator.serviceWorker.register('` +
+			scriptURL + `', { scope: '` + template.JSEscapeString(scope) + `' }); }</script>`
+	}
+}
+
+// bodyOpenTag is the start of the tag after which headless mode begins emitting content
+var bodyOpenTag = []byte("<body")
+
+// maxHeadlessPrelude bounds how much of a document is held back while looking
+// for the opening <body> tag before giving up and passing it through unchanged
+const maxHeadlessPrelude = 64 * 1024
+
+// isHTMLResponse reports whether the response headers describe an HTML document
+func isHTMLResponse(w http.ResponseWriter) bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "text/html")
+}
+
+// injectWriter streams the response body through, inserting a snippet just
+// before the first </body> tag. Only a tag-sized tail is ever held back, so
+// large documents are never buffered in memory.
+type injectWriter struct {
+	http.ResponseWriter
+	snippet     []byte
+	pending     []byte
+	active      bool
+	done        bool
+	wroteHeader bool
+}
+
+func newInjectWriter(w http.ResponseWriter, snippet string) *injectWriter {
+	return &injectWriter{ResponseWriter: w, snippet: []byte(snippet)}
+}
+
+// WriteHeader drops Content-Length since the injected body is longer than the file
+func (iw *injectWriter) WriteHeader(code int) {
+	if iw.wroteHeader {
+		return
+	}
+	iw.wroteHeader = true
+	iw.active = code == http.StatusOK
+	if iw.active {
+		iw.Header().Del("Content-Length")
+	}
+	iw.ResponseWriter.WriteHeader(code)
+}
+
+func (iw *injectWriter) Write(p []byte) (int, error) {
+	if !iw.wroteHeader {
+		iw.WriteHeader(http.StatusOK)
+	}
+	if !iw.active || iw.done {
+		return iw.ResponseWriter.Write(p)
+	}
+
+	data := append(iw.pending, p...)
+	iw.pending = nil
+
+	if idx := bytes.Index(bytes.ToLower(data), bodyCloseTag); idx >= 0 {
+		iw.done = true
+		out := make([]byte, 0, len(data)+len(iw.snippet))
+		out = append(out, data[:idx]...)
+		out = append(out, iw.snippet...)
+		out = append(out, data[idx:]...)
+		if _, err := iw.ResponseWriter.Write(out); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	// Hold back enough bytes to detect a tag split across writes
+	keep := len(bodyCloseTag) - 1
+	if keep > len(data) {
+		keep = len(data)
+	}
+	iw.pending = append([]byte(nil), data[len(data)-keep:]...)
+	if _, err := iw.ResponseWriter.Write(data[:len(data)-keep]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close flushes any held-back bytes. Documents without a </body> tag get the
+// snippet appended at the end.
+func (iw *injectWriter) Close() error {
+	if !iw.active || iw.done {
+		return nil
+	}
+	iw.done = true
+	_, err := iw.ResponseWriter.Write(append(iw.pending, iw.snippet...))
+	return err
+}
+
+// bodyExtractWriter strips the document prelude (doctype, <html>, <head>) and
+// the outer <body> tags, streaming only the body's inner HTML. Documents
+// without a <body> tag are passed through unchanged.
+type bodyExtractWriter struct {
+	http.ResponseWriter
+	buf         []byte
+	active      bool
+	inBody      bool
+	done        bool
+	wroteHeader bool
+}
+
+func newBodyExtractWriter(w http.ResponseWriter) *bodyExtractWriter {
+	return &bodyExtractWriter{ResponseWriter: w}
+}
+
+// WriteHeader drops Content-Length since the extracted body is shorter than the file
+func (bw *bodyExtractWriter) WriteHeader(code int) {
+	if bw.wroteHeader {
+		return
+	}
+	bw.wroteHeader = true
+	bw.active = code == http.StatusOK
+	if bw.active {
+		bw.Header().Del("Content-Length")
+	}
+	bw.ResponseWriter.WriteHeader(code)
+}
+
+func (bw *bodyExtractWriter) Write(p []byte) (int, error) {
+	if !bw.wroteHeader {
+		bw.WriteHeader(http.StatusOK)
+	}
+	if !bw.active {
+		return bw.ResponseWriter.Write(p)
+	}
+	if bw.done {
+		// Everything after </body> is discarded
+		return len(p), nil
+	}
+
+	data := append(bw.buf, p...)
+	bw.buf = nil
+
+	if !bw.inBody {
+		start := findBodyOpen(data)
+		if start < 0 {
+			if len(data) > maxHeadlessPrelude {
+				// Not a full document: stop looking and pass it through
+				bw.done, bw.active = true, false
+				if _, err := bw.ResponseWriter.Write(data); err != nil {
+					return 0, err
+				}
+				return len(p), nil
+			}
+			bw.buf = data
+			return len(p), nil
+		}
+		bw.inBody = true
+		data = data[start:]
+	}
+
+	if idx := bytes.Index(bytes.ToLower(data), bodyCloseTag); idx >= 0 {
+		bw.done = true
+		if _, err := bw.ResponseWriter.Write(data[:idx]); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	// Hold back enough bytes to detect a tag split across writes
+	keep := len(bodyCloseTag) - 1
+	if keep > len(data) {
+		keep = len(data)
+	}
+	bw.buf = append([]byte(nil), data[len(data)-keep:]...)
+	if _, err := bw.ResponseWriter.Write(data[:len(data)-keep]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close flushes held-back bytes: the whole document when no <body> tag was
+// found, or the tail of a body that was never closed.
+func (bw *bodyExtractWriter) Close() error {
+	if !bw.active || bw.done {
+		return nil
+	}
+	bw.done = true
+	_, err := bw.ResponseWriter.Write(bw.buf)
+	return err
+}
+
+// findBodyOpen returns the offset just past the opening <body> tag, or -1 when
+// the complete tag has not been seen yet
+func findBodyOpen(data []byte) int {
+	lower := bytes.ToLower(data)
+	offset := 0
+	for {
+		idx := bytes.Index(lower[offset:], bodyOpenTag)
+		if idx < 0 {
+			return -1
+		}
+		idx += offset + len(bodyOpenTag)
+		if idx >= len(lower) {
+			return -1
+		}
+		// Reject longer tag names such as <bodyguard>
+		if c := lower[idx]; c == '>' || c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '/' {
+			end := bytes.IndexByte(lower[idx:], '>')
+			if end < 0 {
+				return -1
+			}
+			return idx + end + 1
+		}
+		offset = idx
+	}
+}
+
+// transformsHTML reports whether any streaming HTML transform is configured
+func (h *StatiqHandler) transformsHTML() bool {
+	return len(h.htmlInjections) > 0 || h.headlessMode
+}
+
+// wrapHTMLTransforms chains the configured HTML transforms around w. The
+// returned finish function must be called once the body has been written.
+func (h *StatiqHandler) wrapHTMLTransforms(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	var closers []func() error
+
+	if h.headlessMode {
+		bw := newBodyExtractWriter(w)
+		w = bw
+		closers = append(closers, bw.Close)
+	}
+
+	if len(h.htmlInjections) > 0 {
+		iw := newInjectWriter(w, h.injectionSnippet(r))
+		w = iw
+		closers = append(closers, iw.Close)
+	}
+
+	return w, func() {
+		// Close the outermost writer first so its tail flows through the inner ones
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+}
+
+// injectionSnippet collects the markup of every configured injection for r
+func (h *StatiqHandler) injectionSnippet(r *http.Request) string {
+	var b strings.Builder
+	for _, inject := range h.htmlInjections {
+		b.WriteString(inject(r))
+	}
+	return b.String()
+}
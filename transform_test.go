@@ -0,0 +1,137 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+func TestFeedbackPageInjection(t *testing.T) {
+	t.Parallel()
+
+	// Place </body> across the 32 KB copy buffer boundary used by http.ServeContent
+	large := "<html><body>" + strings.Repeat("a", 32*1024-15) + "</body></html>"
+
+	tempDir := newTestRoot(t, map[string]string{
+		"index.html": "<html><body><p>Docs</p></BODY></html>",
+		"large.html": large,
+		"plain.txt":  "</body>",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.FeedbackPage = "https://feedback.example.com/?site=docs&lang=en"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	widget := `<div id="statiq-feedback"`
+	href := `href="https://feedback.example.com/?site=docs&amp;lang=en"`
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil))
+	body := recorder.Body.String()
+	if !strings.Contains(body, widget) || !strings.Contains(body, href) {
+		t.Fatalf("Expected feedback widget in HTML, got %s", body)
+	}
+	if strings.Index(body, widget) > strings.Index(body, "</BODY>") {
+		t.Errorf("Expected widget before </body>, got %s", body)
+	}
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Errorf("Expected no Content-Length on injected response, got %s", recorder.Header().Get("Content-Length"))
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/large.html", nil))
+	body = recorder.Body.String()
+	if len(body) <= len(large) || !strings.HasSuffix(body, "</body></html>") || strings.Count(body, widget) != 1 {
+		t.Errorf("Expected single widget injected into large HTML, got %d bytes", len(body))
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/plain.txt", nil))
+	if recorder.Body.String() != "</body>" {
+		t.Errorf("Expected non-HTML file untouched, got %s", recorder.Body.String())
+	}
+}
+
+func TestServiceWorkerInjection(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"docs/guide.html": "<html><body>Guide</body></html>",
+		"index.html":      "<html><body>Home</body></html>",
+		"js/sw.js":        "self.addEventListener('fetch', () => {})",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.ServiceWorkerScript = "js/sw.js"
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/docs/guide.html", nil))
+	expected := `<script>if ('serviceWorker' in navigator) { navigator.serviceWorker.register('/js/sw.js', { scope: '/docs/' }); }</script></body>`
+	if !strings.Contains(recorder.Body.String(), expected) {
+		t.Errorf("Expected service worker registration, got %s", recorder.Body.String())
+	}
+
+	// The root page registers a scope above the script's own directory
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/index.html", nil))
+	expected = `navigator.serviceWorker.register('/js/sw.js', { scope: '/' })`
+	if !strings.Contains(recorder.Body.String(), expected) {
+		t.Errorf("Expected root-scoped registration, got %s", recorder.Body.String())
+	}
+
+	// The script itself must allow that scope or register() is rejected
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/js/sw.js", nil))
+	if got := recorder.Header().Get("Service-Worker-Allowed"); got != "/" {
+		t.Errorf("Expected Service-Worker-Allowed: /, got %q", got)
+	}
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/docs/guide.html", nil))
+	if got := recorder.Header().Get("Service-Worker-Allowed"); got != "" {
+		t.Errorf("Expected no Service-Worker-Allowed on pages, got %q", got)
+	}
+
+	// HEAD responses carry no body to inject into
+	recorder = serve(handler, newRequest(t, http.MethodHead, "http://localhost/docs/guide.html", nil))
+	if recorder.Body.Len() != 0 {
+		t.Errorf("Expected empty body for HEAD, got %s", recorder.Body.String())
+	}
+}
+
+func TestHeadlessMode(t *testing.T) {
+	t.Parallel()
+
+	tempDir := newTestRoot(t, map[string]string{
+		"page.html":     "<!DOCTYPE html>\n<html><head><title>Page</title><style>body{}</style></head>\n<body class=\"main\"><h1>Hello</h1><p>World</p></body>\n</html>\n",
+		"fragment.html": "<section>Already a fragment</section>",
+	})
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = tempDir
+	cfg.HeadlessMode = true
+
+	handler, err := statiq.New(context.Background(), next(t), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost/page.html", nil))
+	if body := recorder.Body.String(); body != "<h1>Hello</h1><p>World</p>" {
+		t.Errorf("Expected inner body HTML, got %q", body)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("Expected text/html Content-Type, got %s", contentType)
+	}
+
+	recorder = serve(handler, newRequest(t, http.MethodGet, "http://localhost/fragment.html", nil))
+	if body := recorder.Body.String(); body != "<section>Already a fragment</section>" {
+		t.Errorf("Expected fragment passed through unchanged, got %q", body)
+	}
+}
@@ -0,0 +1,250 @@
+package statiq_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	statiq "github.com/hhftechnology/statiq"
+)
+
+// traversalSecret is stored next to the served root and must never leak
+const traversalSecret = "TOP-SECRET-CONTENT"
+
+// newTraversalHandler serves a root whose parent directory holds a secret file
+func newTraversalHandler(t testing.TB) http.Handler {
+	t.Helper()
+
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(parent, "secret.txt"):     traversalSecret,
+		filepath.Join(root, "docs", "page.txt"): "page",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = root
+	cfg.EnableDirectoryListing = true
+
+	handler, err := statiq.New(context.Background(), http.NotFoundHandler(), cfg, "statiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+// traversalSeeds are escaped request paths attempting to reach the secret
+var traversalSeeds = []string{
+	"/../secret.txt",
+	"/docs/../../secret.txt",
+	"/..%2fsecret.txt",
+	"/docs%2f..%2f..%2fsecret.txt",
+	"/%2e%2e/secret.txt",
+	"/%2E%2E%2Fsecret.txt",
+	"/docs/%2e%2e/%2e%2e/secret.txt",
+	"/..\\secret.txt",
+	"/docs\\..\\..\\secret.txt",
+	"/..%5csecret.txt",
+	"//../secret.txt",
+	"/docs//..//..//secret.txt",
+	"/secret.txt%00",
+	"/docs/page.txt%00/../../../secret.txt",
+	"/%00/../secret.txt",
+}
+
+func TestPathTraversal(t *testing.T) {
+	t.Parallel()
+
+	handler := newTraversalHandler(t)
+
+	for _, target := range traversalSeeds {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", target, recorder.Code)
+		}
+		if strings.Contains(recorder.Body.String(), traversalSecret) {
+			t.Errorf("Secret leaked for %s", target)
+		}
+	}
+
+	// Dot segments that stay inside a directory name are legitimate
+	for target, expected := range map[string]int{
+		"/docs/page.txt":   http.StatusOK,
+		"//docs//page.txt": http.StatusOK,
+		"/docs/..page.txt": http.StatusNotFound,
+		"/docs/.../":       http.StatusNotFound,
+	} {
+		recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+		if recorder.Code != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, target, recorder.Code)
+		}
+	}
+}
+
+func FuzzPathTraversal(f *testing.F) {
+	for _, seed := range traversalSeeds {
+		f.Add(seed)
+	}
+
+	handler := newTraversalHandler(f)
+
+	f.Fuzz(func(t *testing.T, target string) {
+		u, err := url.Parse("http://localhost" + target)
+		if err != nil || u.Host != "localhost" {
+			t.Skip()
+		}
+
+		req := newRequest(t, http.MethodGet, "http://localhost/", nil)
+		req.URL = u
+		req.RequestURI = u.RequestURI()
+		recorder := serve(handler, req)
+		if strings.Contains(recorder.Body.String(), traversalSecret) {
+			t.Fatalf("Secret leaked for %q with status %d", target, recorder.Code)
+		}
+	})
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	t.Parallel()
+
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(parent, "secret.txt"):     traversalSecret,
+		filepath.Join(root, "docs", "page.txt"): "page",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{
+		"inside.txt":      "docs/page.txt",
+		"linked":          "docs",
+		"escape.txt":      "../secret.txt",
+		"docs/escape.txt": "../../secret.txt",
+		"absolute.txt":    filepath.Join(parent, "secret.txt"),
+		"outside":         parent,
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("Symlinks are not supported: %v", err)
+		}
+	}
+
+	targets := []string{"/docs/page.txt", "/inside.txt", "/linked/page.txt", "/escape.txt", "/docs/escape.txt", "/absolute.txt", "/outside/secret.txt"}
+	for _, test := range []struct {
+		mode     string
+		memory   bool
+		expected []int
+	}{
+		{"", false, []int{200, 403, 403, 403, 403, 403, 403}},
+		{"none", false, []int{200, 403, 403, 403, 403, 403, 403}},
+		{"same-root", false, []int{200, 200, 200, 403, 403, 403, 403}},
+		{"any", false, []int{200, 200, 200, 200, 200, 200, 200}},
+		// Links refused by the policy are not loaded into memory either
+		{"none", true, []int{200, 404, 404, 404, 404, 404, 404}},
+		{"same-root", true, []int{200, 200, 404, 404, 404, 404, 404}},
+	} {
+		cfg := statiq.CreateConfig()
+		cfg.Root = root
+		cfg.ServeFromMemory = test.memory
+		if test.mode != "" {
+			cfg.FollowSymlinks = test.mode
+		}
+
+		handler, err := statiq.New(context.Background(), http.NotFoundHandler(), cfg, "statiq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, target := range targets {
+			recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+			if recorder.Code != test.expected[i] {
+				t.Errorf("Expected %d for %s with %q (memory %t), got %d", test.expected[i], target, cfg.FollowSymlinks, test.memory, recorder.Code)
+			}
+			if test.expected[i] != http.StatusOK && strings.Contains(recorder.Body.String(), traversalSecret) {
+				t.Errorf("Secret leaked for %s with %q", target, cfg.FollowSymlinks)
+			}
+		}
+	}
+
+	cfg := statiq.CreateConfig()
+	cfg.Root = root
+	cfg.FollowSymlinks = "some"
+	if _, err := statiq.New(context.Background(), http.NotFoundHandler(), cfg, "statiq"); err == nil {
+		t.Error("Expected an error for an unknown followSymlinks mode")
+	}
+}
+
+func TestSymlinkChainLimit(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "page.txt"), []byte("page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"a":     "b",
+		"b":     "a",
+		"hop1":  "hop2",
+		"hop2":  "hop3",
+		"hop3":  "page.txt",
+		"dir":   ".",
+		"loopy": "loopy/x",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("Symlinks are not supported: %v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		mode  string
+		limit int
+		code  map[string]int
+	}{
+		{"any", 0, map[string]int{"/a": 403, "/loopy": 403, "/hop1": 200, "/dir/dir/dir/page.txt": 200}},
+		{"same-root", 0, map[string]int{"/a": 403, "/hop1": 200}},
+		{"any", 2, map[string]int{"/a": 403, "/hop1": 403, "/hop2": 200, "/dir/dir/dir/page.txt": 403}},
+	} {
+		cfg := statiq.CreateConfig()
+		cfg.Root = root
+		cfg.FollowSymlinks = test.mode
+		if test.limit > 0 {
+			cfg.SymlinkChainLimit = test.limit
+		}
+
+		handler, err := statiq.New(context.Background(), http.NotFoundHandler(), cfg, "statiq")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for target, expected := range test.code {
+				recorder := serve(handler, newRequest(t, http.MethodGet, "http://localhost"+target, nil))
+				if recorder.Code != expected {
+					t.Errorf("Expected %d for %s with %q and limit %d, got %d", expected, target, test.mode, test.limit, recorder.Code)
+				}
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Resolving circular links with %q did not finish", test.mode)
+		}
+	}
+}
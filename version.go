@@ -0,0 +1,48 @@
+package statiq
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+)
+
+// Build information reported by the VersionFile endpoint, injected at build time:
+//
+//	go build -ldflags "-X github.com/hhftechnology/statiq.Version=$(git rev-parse HEAD) -X github.com/hhftechnology/statiq.BuildTime=$(date -u +%FT%TZ)"
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+)
+
+// serveVersion answers requests for the configured version file path with the
+// generated build information. It is only used when no such file exists on disk.
+func (h *StatiqHandler) serveVersion(w http.ResponseWriter, r *http.Request) bool {
+	if h.versionPath == "" || r.URL.Path != h.versionPath {
+		return false
+	}
+
+	body, err := json.Marshal(struct {
+		Version string `json:"version"`
+		Built   string `json:"built"`
+		Plugin  string `json:"plugin"`
+	}{Version, BuildTime, "statiq"})
+	if err != nil {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+	return true
+}
+
+// versionFilePath turns the configured file name into the URL path it answers on
+func versionFilePath(name string) string {
+	if name == "" {
+		return ""
+	}
+	return path.Join("/", name)
+}
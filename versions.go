@@ -0,0 +1,68 @@
+package statiq
+
+import (
+	"strconv"
+	"strings"
+)
+
+// immutableCacheControl is served for assets that can never change
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// parseVersion parses a version path component such as "v2" or "v1.10" (the
+// "v" prefix is optional). It returns false for anything else.
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if s == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(s, ".")
+	version := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		version[i] = n
+	}
+
+	return version, true
+}
+
+// compareVersions returns -1, 0 or 1 as a is older than, equal to or newer than b
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// isOldVersion reports whether the URL path has a "v<N>" component older than the current version
+func (h *StatiqHandler) isOldVersion(urlPath string) bool {
+	if h.currentVersion == nil {
+		return false
+	}
+
+	for _, segment := range strings.Split(urlPath, "/") {
+		if len(segment) < 2 || (segment[0] != 'v' && segment[0] != 'V') {
+			continue
+		}
+		if version, ok := parseVersion(segment); ok && compareVersions(version, h.currentVersion) < 0 {
+			return true
+		}
+	}
+
+	return false
+}
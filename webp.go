@@ -0,0 +1,561 @@
+package statiq
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// maxWebPDimension is the largest width or height a VP8L bitstream can describe
+const maxWebPDimension = 1 << 14
+
+// vp8lCodeLengthOrder is the order in which code length code lengths are written
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lAlphabetSizes are the sizes of the green, red, blue, alpha and distance
+// alphabets without a color cache
+var vp8lAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// errWebPTooLarge is returned for images a VP8L bitstream cannot hold
+var errWebPTooLarge = errors.New("image too large for WebP")
+
+// webpTileBits is the log2 size of the tiles that share a predictor
+const webpTileBits = 5
+
+// webpPredictors are the VP8L predictor modes tried for each tile: none
+// (opaque black), left, top, select and clamped gradient
+var webpPredictors = []int{0, 1, 2, 11, 12}
+
+// encodeWebP encodes img as a lossless WebP (VP8L) image. The encoder keeps
+// to a small subset of the format: subtract-green and per-tile predictor
+// transforms followed by literal pixels coded with one set of prefix codes.
+func encodeWebP(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > maxWebPDimension || height > maxWebPDimension {
+		return nil, errWebPTooLarge
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Rect, img, bounds.Min, draw.Src)
+
+	// Subtract green from red and blue
+	pixels := nrgba.Pix
+	hasAlpha := false
+	for i := 0; i < len(pixels); i += 4 {
+		pixels[i] -= pixels[i+1]
+		pixels[i+2] -= pixels[i+1]
+		hasAlpha = hasAlpha || pixels[i+3] != 0xff
+	}
+
+	modes, tilesX, residuals := predictWebP(pixels, width, height)
+
+	bw := &bitWriter{}
+	bw.writeBits(0x2f, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if hasAlpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3)
+
+	// Subtract-green transform
+	bw.writeBits(1, 1)
+	bw.writeBits(2, 2)
+
+	// Predictor transform with its sub-sampled image of modes in green
+	bw.writeBits(1, 1)
+	bw.writeBits(0, 2)
+	bw.writeBits(webpTileBits-2, 3)
+	writeWebPImage(bw, modes, tilesX, false)
+
+	// No further transforms
+	bw.writeBits(0, 1)
+	writeWebPImage(bw, residuals, width, true)
+
+	data := bw.bytes()
+	chunkSize := len(data)
+	padded := chunkSize + chunkSize&1
+
+	out := make([]byte, 0, 20+padded)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(12+padded))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(chunkSize))
+	out = append(out, data...)
+	if chunkSize&1 == 1 {
+		out = append(out, 0)
+	}
+	return out, nil
+}
+
+// webpToken is a literal pixel or, when length is set, a backward reference
+type webpToken struct {
+	pixel    int // offset of the literal pixel
+	length   int
+	distance int // VP8L distance code: 1 is the pixel above, 2 the pixel to the left
+}
+
+// Backward references shorter than webpMinCopy are not worth their codes
+const (
+	webpMinCopy = 3
+	webpMaxCopy = 4096
+)
+
+// writeWebPImage writes an entropy-coded image of RGBA-ordered pixels
+func writeWebPImage(bw *bitWriter, pixels []byte, width int, main bool) {
+	tokens := webpTokens(pixels, width)
+
+	var histograms [5][]int
+	for i := range histograms {
+		histograms[i] = make([]int, vp8lAlphabetSizes[i])
+	}
+	for _, token := range tokens {
+		if token.length > 0 {
+			lengthPrefix, _, _ := webpPrefix(token.length)
+			distancePrefix, _, _ := webpPrefix(token.distance)
+			histograms[0][256+lengthPrefix]++
+			histograms[4][distancePrefix]++
+			continue
+		}
+		i := token.pixel
+		histograms[0][pixels[i+1]]++
+		histograms[1][pixels[i]]++
+		histograms[2][pixels[i+2]]++
+		histograms[3][pixels[i+3]]++
+	}
+
+	// No color cache, and for the main image no meta prefix codes
+	bw.writeBits(0, 1)
+	if main {
+		bw.writeBits(0, 1)
+	}
+
+	var codes [5][]huffmanCode
+	for i, histogram := range histograms {
+		codes[i] = writePrefixCode(bw, histogram)
+	}
+
+	for _, token := range tokens {
+		if token.length > 0 {
+			prefix, extraBits, extra := webpPrefix(token.length)
+			bw.writeCode(codes[0][256+prefix])
+			bw.writeBits(extra, extraBits)
+			prefix, extraBits, extra = webpPrefix(token.distance)
+			bw.writeCode(codes[4][prefix])
+			bw.writeBits(extra, extraBits)
+			continue
+		}
+		i := token.pixel
+		bw.writeCode(codes[0][pixels[i+1]])
+		bw.writeCode(codes[1][pixels[i]])
+		bw.writeCode(codes[2][pixels[i+2]])
+		bw.writeCode(codes[3][pixels[i+3]])
+	}
+}
+
+// webpTokens greedily replaces runs that repeat the pixel to the left or the
+// row above with backward references
+func webpTokens(pixels []byte, width int) []webpToken {
+	count := len(pixels) / 4
+	tokens := make([]webpToken, 0, count)
+
+	for p := 0; p < count; {
+		length, distance := 0, 0
+		if p >= 1 {
+			length, distance = webpMatch(pixels, p, 1, count), 2
+		}
+		if p >= width {
+			if above := webpMatch(pixels, p, width, count); above > length {
+				length, distance = above, 1
+			}
+		}
+
+		if length >= webpMinCopy {
+			tokens = append(tokens, webpToken{length: length, distance: distance})
+			p += length
+			continue
+		}
+		tokens = append(tokens, webpToken{pixel: 4 * p})
+		p++
+	}
+
+	return tokens
+}
+
+// webpMatch returns how many pixels from p on equal the pixels offset before them
+func webpMatch(pixels []byte, p, offset, count int) int {
+	length := 0
+	for p+length < count && length < webpMaxCopy {
+		i, j := 4*(p+length), 4*(p+length-offset)
+		if pixels[i] != pixels[j] || pixels[i+1] != pixels[j+1] || pixels[i+2] != pixels[j+2] || pixels[i+3] != pixels[j+3] {
+			break
+		}
+		length++
+	}
+	return length
+}
+
+// webpPrefix splits a length or distance code into its prefix symbol and extra bits
+func webpPrefix(value int) (int, int, uint32) {
+	d := value - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	highest := 0
+	for d>>(highest+1) != 0 {
+		highest++
+	}
+	second := (d >> (highest - 1)) & 1
+	extraBits := highest - 1
+	return 2*highest + second, extraBits, uint32(d & (1<<extraBits - 1))
+}
+
+// predictWebP picks the predictor with the smallest residuals for every tile
+// and returns the mode image, its width and the residual pixels
+func predictWebP(pixels []byte, width, height int) ([]byte, int, []byte) {
+	tile := 1 << webpTileBits
+	tilesX, tilesY := (width+tile-1)/tile, (height+tile-1)/tile
+	modes := make([]byte, 4*tilesX*tilesY)
+	residuals := make([]byte, len(pixels))
+
+	var pred [4]byte
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			best, bestCost := webpPredictors[0], -1
+			for _, mode := range webpPredictors {
+				cost := 0
+				for y := ty * tile; y < height && y < (ty+1)*tile; y++ {
+					for x := tx * tile; x < width && x < (tx+1)*tile; x++ {
+						predictPixel(pixels, width, x, y, mode, &pred)
+						i := 4 * (y*width + x)
+						for c := 0; c < 4; c++ {
+							cost += residualCost(pixels[i+c] - pred[c])
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			m := 4 * (ty*tilesX + tx)
+			modes[m+1], modes[m+3] = byte(best), 0xff
+			for y := ty * tile; y < height && y < (ty+1)*tile; y++ {
+				for x := tx * tile; x < width && x < (tx+1)*tile; x++ {
+					predictPixel(pixels, width, x, y, best, &pred)
+					i := 4 * (y*width + x)
+					for c := 0; c < 4; c++ {
+						residuals[i+c] = pixels[i+c] - pred[c]
+					}
+				}
+			}
+		}
+	}
+
+	return modes, tilesX, residuals
+}
+
+// residualCost favours residuals close to zero in either direction
+func residualCost(residual byte) int {
+	if residual >= 128 {
+		return 256 - int(residual)
+	}
+	return int(residual)
+}
+
+// predictPixel computes the VP8L prediction of the pixel at x, y. The first
+// pixel predicts opaque black, the top row its left neighbour and the left
+// column its top neighbour, whatever the mode.
+func predictPixel(pixels []byte, width, x, y, mode int, pred *[4]byte) {
+	i := 4 * (y*width + x)
+	switch {
+	case x == 0 && y == 0:
+		*pred = [4]byte{0, 0, 0, 0xff}
+		return
+	case y == 0:
+		copy(pred[:], pixels[i-4:i])
+		return
+	case x == 0:
+		copy(pred[:], pixels[i-4*width:i-4*width+4])
+		return
+	}
+
+	left, top, topLeft := pixels[i-4:i], pixels[i-4*width:i-4*width+4], pixels[i-4*width-4:i-4*width]
+	switch mode {
+	case 0:
+		*pred = [4]byte{0, 0, 0, 0xff}
+	case 1:
+		copy(pred[:], left)
+	case 2:
+		copy(pred[:], top)
+	case 11:
+		var distLeft, distTop int
+		for c := 0; c < 4; c++ {
+			estimate := int(left[c]) + int(top[c]) - int(topLeft[c])
+			distLeft += abs(estimate - int(left[c]))
+			distTop += abs(estimate - int(top[c]))
+		}
+		if distLeft < distTop {
+			copy(pred[:], left)
+		} else {
+			copy(pred[:], top)
+		}
+	default:
+		for c := 0; c < 4; c++ {
+			v := int(left[c]) + int(top[c]) - int(topLeft[c])
+			if v < 0 {
+				v = 0
+			} else if v > 255 {
+				v = 255
+			}
+			pred[c] = byte(v)
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// writePrefixCode writes the prefix code for a histogram and returns the code
+// of every symbol. Alphabets with at most two used symbols below 256 use the
+// compact "simple" code.
+func writePrefixCode(bw *bitWriter, histogram []int) []huffmanCode {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	lengths := make([]int, len(histogram))
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		bw.writeBits(1, 1)
+		bw.writeBits(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.writeBits(0, 1)
+			bw.writeBits(uint32(used[0]), 1)
+		} else {
+			bw.writeBits(1, 1)
+			bw.writeBits(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.writeBits(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return canonicalCodes(lengths)
+	}
+
+	lengths = huffmanLengths(histogram, 15)
+
+	// The code lengths themselves are coded with a code over 19 symbols
+	lengthHistogram := make([]int, 19)
+	for _, length := range lengths {
+		lengthHistogram[length]++
+	}
+	lengthLengths := huffmanLengths(lengthHistogram, 7)
+	lengthCodes := canonicalCodes(lengthLengths)
+
+	count := len(vp8lCodeLengthOrder)
+	for count > 4 && lengthLengths[vp8lCodeLengthOrder[count-1]] == 0 {
+		count--
+	}
+
+	bw.writeBits(0, 1)
+	bw.writeBits(uint32(count-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:count] {
+		bw.writeBits(uint32(lengthLengths[symbol]), 3)
+	}
+	// Code lengths are given for the whole alphabet
+	bw.writeBits(0, 1)
+	for _, length := range lengths {
+		bw.writeCode(lengthCodes[length])
+	}
+
+	return canonicalCodes(lengths)
+}
+
+// huffmanCode is a prefix code with its bits already reversed for LSB-first output
+type huffmanCode struct {
+	bits   uint32
+	length int
+}
+
+// canonicalCodes assigns canonical prefix codes for the given code lengths
+func canonicalCodes(lengths []int) []huffmanCode {
+	var counts [16]int
+	for _, length := range lengths {
+		if length > 0 {
+			counts[length]++
+		}
+	}
+
+	var next [16]uint32
+	code := uint32(0)
+	for length := 1; length < 16; length++ {
+		code = (code + uint32(counts[length-1])) << 1
+		next[length] = code
+	}
+
+	codes := make([]huffmanCode, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		codes[symbol] = huffmanCode{bits: reverseBits(next[length], length), length: length}
+		next[length]++
+	}
+	return codes
+}
+
+func reverseBits(code uint32, length int) uint32 {
+	var reversed uint32
+	for i := 0; i < length; i++ {
+		reversed = reversed<<1 | code&1
+		code >>= 1
+	}
+	return reversed
+}
+
+// huffmanLengths computes code lengths no longer than maxLength. Histograms
+// with a single used symbol get a second one so the code stays complete.
+func huffmanLengths(histogram []int, maxLength int) []int {
+	counts := append([]int(nil), histogram...)
+
+	used := 0
+	for _, count := range counts {
+		if count > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		for symbol := range counts {
+			if counts[symbol] == 0 {
+				counts[symbol] = 1
+				used++
+				if used == 2 {
+					break
+				}
+			}
+		}
+	}
+
+	for {
+		lengths := buildHuffmanLengths(counts)
+		longest := 0
+		for _, length := range lengths {
+			if length > longest {
+				longest = length
+			}
+		}
+		if longest <= maxLength {
+			return lengths
+		}
+		// Flatten the distribution until the tree is shallow enough
+		for symbol, count := range counts {
+			if count > 1 {
+				counts[symbol] = (count + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanNode is a node of the tree built by buildHuffmanLengths
+type huffmanNode struct {
+	weight      int
+	symbol      int
+	left, right *huffmanNode
+}
+
+// huffmanQueue orders nodes by weight, breaking ties by symbol for determinism
+type huffmanQueue []*huffmanNode
+
+func (q huffmanQueue) Len() int { return len(q) }
+func (q huffmanQueue) Less(i, j int) bool {
+	if q[i].weight != q[j].weight {
+		return q[i].weight < q[j].weight
+	}
+	return q[i].symbol < q[j].symbol
+}
+func (q huffmanQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *huffmanQueue) Push(x interface{}) { *q = append(*q, x.(*huffmanNode)) }
+func (q *huffmanQueue) Pop() interface{} {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// buildHuffmanLengths returns unrestricted Huffman code lengths for counts
+func buildHuffmanLengths(counts []int) []int {
+	queue := &huffmanQueue{}
+	for symbol, count := range counts {
+		if count > 0 {
+			*queue = append(*queue, &huffmanNode{weight: count, symbol: symbol})
+		}
+	}
+	heap.Init(queue)
+
+	next := len(counts)
+	for queue.Len() > 1 {
+		a := heap.Pop(queue).(*huffmanNode)
+		b := heap.Pop(queue).(*huffmanNode)
+		heap.Push(queue, &huffmanNode{weight: a.weight + b.weight, symbol: next, left: a, right: b})
+		next++
+	}
+
+	lengths := make([]int, len(counts))
+	var walk func(node *huffmanNode, depth int)
+	walk = func(node *huffmanNode, depth int) {
+		if node.left == nil {
+			lengths[node.symbol] = depth
+			return
+		}
+		walk(node.left, depth+1)
+		walk(node.right, depth+1)
+	}
+	walk(heap.Pop(queue).(*huffmanNode), 0)
+
+	return lengths
+}
+
+// bitWriter packs values least significant bit first, as VP8L requires
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (bw *bitWriter) writeBits(value uint32, n int) {
+	bw.acc |= uint64(value) << bw.nbits
+	bw.nbits += uint(n)
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *bitWriter) writeCode(code huffmanCode) {
+	bw.writeBits(code.bits, code.length)
+}
+
+// bytes flushes the partial last byte and returns the packed bits
+func (bw *bitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nbits = 0, 0
+	}
+	return bw.buf
+}
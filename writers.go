@@ -0,0 +1,76 @@
+package statiq
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// headerHookWriter runs a hook exactly once, right before the status line and
+// headers are written, so response headers can be adjusted after
+// http.ServeContent has populated them.
+type headerHookWriter struct {
+	http.ResponseWriter
+	hook        func(h http.Header, code int)
+	hooked      bool
+	wroteHeader bool
+}
+
+func newHeaderHookWriter(w http.ResponseWriter, hook func(h http.Header, code int)) *headerHookWriter {
+	return &headerHookWriter{ResponseWriter: w, hook: hook}
+}
+
+func (hw *headerHookWriter) WriteHeader(code int) {
+	if hw.wroteHeader {
+		return
+	}
+	hw.wroteHeader = true
+	hw.runHook(code)
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+// runHook applies the hook to the headers the first time it is called
+func (hw *headerHookWriter) runHook(code int) {
+	if !hw.hooked {
+		hw.hooked = true
+		hw.hook(hw.Header(), code)
+	}
+}
+
+func (hw *headerHookWriter) Write(p []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(p)
+}
+
+// Push keeps HTTP/2 server push working behind the hook
+func (hw *headerHookWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := hw.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+// Hijack runs the hook before handing over the connection, so a detached
+// send writes the same headers as a regular response
+func (hw *headerHookWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := hw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("statiq: response writer does not support hijacking")
+	}
+	hw.runHook(http.StatusOK)
+	return hijacker.Hijack()
+}
+
+// Flush keeps streamed responses flushing behind the hook
+func (hw *headerHookWriter) Flush() {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := hw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}